@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// addrCmd represents the addr command
+var addrCmd = &cobra.Command{
+	Use:   "addr filename [address...]",
+	Short: "Find the symbol and source line for addresses",
+	Long: `Looks up the symbol containing each address, listing the symbol and
+offset, section and, when the file has DWARF debug information, the source
+file and line number. This is useful when decoding crash dumps.
+
+Addresses are read as hex, with or without a '0x' prefix. When none are
+given on the command line, they are read from stdin, one or more per line.
+On ARM, bit 0 of code addresses (the Thumb bit) is ignored, so function
+pointers and return addresses can be used as-is:
+
+  elfquery addr zephyr.elf 0x10000457 0x100023c1
+
+The same lookup is available in SQL as 'sym_at(addr)'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if output != "" && !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			return
+		}
+
+		addrs := args[1:]
+		if len(addrs) == 0 {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				addrs = append(addrs, strings.Fields(scanner.Text())...)
+			}
+		}
+
+		var infos []elf2sql.AddrInfo
+		for _, arg := range addrs {
+			addr, e := parseAddr(arg)
+			if e != nil {
+				fmt.Printf("invalid address: %s\n", arg)
+				continue
+			}
+			infos = append(infos, img.Addr(addr))
+		}
+
+		switch {
+		case output == "":
+			for _, a := range infos {
+				fmt.Printf("0x%08x: %s\n", a.Addr, a)
+			}
+		case df == elf2sql.DFJson:
+			if infos == nil {
+				infos = []elf2sql.AddrInfo{}
+			}
+			b, e := json.Marshal(infos)
+			check(e)
+			fmt.Println(string(b))
+		default:
+			var rows [][]interface{}
+			for _, a := range infos {
+				rows = append(rows, []interface{}{fmt.Sprintf("0x%08X", a.Addr),
+					a.Location(), a.Section, a.Source()})
+			}
+			fmt.Print(elf2sql.RenderTable("", []string{"Address", "Symbol", "Section", "Source"}, rows, df))
+		}
+	},
+}
+
+// parseAddr reads an address in hex, with or without a '0x' prefix.
+func parseAddr(s string) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return strconv.ParseUint(s, 16, 64)
+}
+
+func init() {
+	rootCmd.AddCommand(addrCmd)
+
+	addrCmd.Flags().StringP("output", "o", "", "output format (text, pretty, color, csv, md, html, json)")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// callgraphCmd represents the callgraph command
+var callgraphCmd = &cobra.Command{
+	Use:   "callgraph filename [function...]",
+	Short: "Static call graph and worst-case stack depth",
+	Long: `Builds a static call graph by disassembling every function in the ELF
+file, and lists the callers and callees of the specified functions. Without
+any functions, every call site is listed. The same data is available in the
+'calls' table of 'elfquery sql'.
+
+Indirect calls (through a function pointer) have no callee, and direct
+branches to another function (tail calls) are treated as calls.
+
+Use --dot to export the call graph (or the part of it reachable from the
+specified functions) in Graphviz DOT format:
+
+  elfquery callgraph zephyr.elf main --dot | dot -Tsvg > main.svg
+
+With --stack-usage, frame sizes are read from the '.su' files generated by
+GCC's '-fstack-usage' option into the 'stack_usage' table, as with
+'elfquery sql --stack-usage', and the worst-case stack depth is calculated
+for the specified functions. Without any functions, every entry point is
+analysed: functions that are never called directly (thread entry functions,
+callbacks) and interrupt handlers found in the vector table. The depth is a
+lower bound when the call tree contains recursion, indirect calls, dynamic
+frames or functions without stack usage information, which are flagged in
+the results.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		// Populate the database with the ELF data, and the stack usage if
+		// requested
+		dir, _ := cmd.Flags().GetString("stack-usage")
+		elf2sql.StackUsageDir = dir
+		e := elf2sql.InitDB(args[0])
+		defer elf2sql.CloseDB()
+		if e != nil {
+			fmt.Printf("unable to initialise the SQLite3 database in memory: %s\n", e)
+			return
+		}
+
+		// Calls in relocatable objects aren't resolved until link time
+		img := elf2sql.CurrentImage()
+		if img == nil {
+			fmt.Printf("call graphs require a linked image, not a static library\n")
+			return
+		}
+
+		funcs := args[1:]
+		g := img.CallGraph()
+		for _, f := range funcs {
+			if _, ok := img.Lookup(f); !ok {
+				fmt.Printf("function '%s' not found\n", f)
+				return
+			}
+		}
+
+		// Graphviz export
+		if dot, _ := cmd.Flags().GetBool("dot"); dot {
+			fmt.Print(g.DOT(funcs...))
+			return
+		}
+
+		// Worst-case stack depth
+		if dir != "" {
+			if len(funcs) == 0 {
+				funcs = g.EntryPoints()
+			}
+			e = elf2sql.LoadStackDepths(funcs)
+			if e != nil {
+				fmt.Printf("unable to calculate stack depth: %s\n", e)
+				return
+			}
+			query := `SELECT Entry, CASE ISR WHEN 1 THEN 'isr' ELSE '' END AS Kind, Depth,
+				trim(CASE Recursive WHEN 1 THEN 'recursive ' ELSE '' END ||
+				CASE Indirect WHEN 1 THEN 'indirect ' ELSE '' END ||
+				CASE Dynamic WHEN 1 THEN 'dynamic ' ELSE '' END ||
+				CASE WHEN Missing > 0 THEN 'missing:' || Missing ELSE '' END) AS Notes,
+				Path FROM stack_depth ORDER BY Depth DESC`
+			s, e := elf2sql.RunQuery(query, df)
+			if e != nil {
+				fmt.Printf("invalid query: %s\n", query)
+				return
+			}
+			fmt.Print(s)
+			return
+		}
+
+		// Callers and callees
+		query := `SELECT Caller, ifnull(Callee, '') AS Callee, printf('0x%X', Address) AS Address,
+			Indirect FROM calls ORDER BY Address`
+		if len(funcs) > 0 {
+			quoted := make([]string, len(funcs))
+			for i, f := range funcs {
+				quoted[i] = "'" + strings.ReplaceAll(f, "'", "''") + "'"
+			}
+			list := strings.Join(quoted, ",")
+			query = fmt.Sprintf(`SELECT Caller, ifnull(Callee, '') AS Callee,
+				printf('0x%%X', Address) AS Address, Indirect FROM calls
+				WHERE Caller IN (%s) OR Callee IN (%s) ORDER BY Address`, list, list)
+		}
+		s, e := elf2sql.RunQuery(query, df)
+		if e != nil {
+			fmt.Printf("invalid query: %s\n", query)
+			return
+		}
+		fmt.Print(s)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(callgraphCmd)
+
+	callgraphCmd.Flags().BoolP("dot", "d", false, "export the call graph in Graphviz DOT format")
+	callgraphCmd.Flags().StringP("stack-usage", "u", "", "directory of GCC .su files for worst-case stack depth")
+	callgraphCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
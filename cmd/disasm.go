@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// disasmCmd represents the disasm command
+var disasmCmd = &cobra.Command{
+	Use:   "disasm filename symbol|range [symbol|range...]",
+	Short: "Disassemble functions or address ranges",
+	Long: `Disassembles the contents of one or more symbols or address ranges,
+annotating branch and call targets and literal pool references with symbol
+names.
+
+Each argument can be one of:
+
+  main                    A symbol name
+  0x10000C14              The symbol containing the specified address
+  0x10000C14-0x10000C40   An address range (end address is exclusive)
+  0x10000C14+64           A start address and length in bytes
+
+Supported instruction sets are ARM Thumb/Thumb-2 (Cortex-M), ARM (A32),
+AArch64, x86, x86-64 and 32- and 64-bit RISC-V (including compressed
+instructions).`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			return
+		}
+
+		for i, arg := range args[1:] {
+			start, end, e := parseRange(img, arg)
+			if e != nil {
+				fmt.Printf("%s: %s\n", arg, e)
+				continue
+			}
+			s, e := img.Listing(start, end)
+			if e != nil {
+				fmt.Printf("%s: %s\n", arg, e)
+				continue
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(s)
+		}
+	},
+}
+
+// parseRange converts a symbol name, address or address range argument into
+// a start and (exclusive) end address.
+func parseRange(img *elf2sql.Image, arg string) (uint64, uint64, error) {
+	if i := strings.IndexAny(arg, "-+"); i > 0 {
+		start, e := strconv.ParseUint(arg[:i], 0, 64)
+		if e != nil {
+			return 0, 0, fmt.Errorf("invalid address: %s", arg[:i])
+		}
+		v, e := strconv.ParseUint(arg[i+1:], 0, 64)
+		if e != nil {
+			return 0, 0, fmt.Errorf("invalid address or length: %s", arg[i+1:])
+		}
+		if arg[i] == '+' {
+			return start, start + v, nil
+		}
+		return start, v, nil
+	}
+
+	if addr, e := strconv.ParseUint(arg, 0, 64); e == nil {
+		sym, _, ok := img.SymbolAt(img.CodeAddr(addr))
+		if !ok {
+			return 0, 0, fmt.Errorf("no symbol contains 0x%X", addr)
+		}
+		return img.SymbolExtent(sym)
+	}
+
+	return img.SymbolRange(arg)
+}
+
+func init() {
+	rootCmd.AddCommand(disasmCmd)
+}
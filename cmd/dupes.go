@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// dupeGroup is a set of identical functions with different names
+type dupeGroup struct {
+	Hash      string
+	Size      uint64
+	Functions []string
+	Wasted    uint64
+}
+
+// dupeSimilar is a pair of near-identical functions
+type dupeSimilar struct {
+	Similarity float64
+	A          string
+	SizeA      uint64
+	B          string
+	SizeB      uint64
+}
+
+// dupesCmd represents the dupes command
+var dupesCmd = &cobra.Command{
+	Use:   "dupes filename",
+	Short: "Find identical and near-identical functions",
+	Long: `Hashes the body of every function, and lists functions with different
+names but identical code, followed by pairs of functions whose code is
+similar. Unfolded template instantiations and copy-pasted drivers are common
+sources of both.
+
+Calls, branches and data references to other symbols are compared by symbol
+name rather than by their encoding, so identical functions at different
+addresses are still found. In relocatable objects and libraries, the symbols
+named by relocations are compared instead.
+
+Similarity is the proportion of short runs of instructions that two
+functions have in common, from 0 to 1. Immediates, offsets and addresses
+within the function are ignored, so copies that differ only in constants are
+still similar. Pairs at or above the threshold are
+listed (-t 1 lists identical functions only). The hashes are also available
+in the 'code_hashes' table of 'elfquery sql'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+		threshold, _ := cmd.Flags().GetFloat64("threshold")
+		minSize, _ := cmd.Flags().GetUint64("min-size")
+		demangled, _ := cmd.Flags().GetBool("demangle")
+
+		hashes, e := hashFunctions(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			return
+		}
+		var funcs []elf2sql.CodeHash
+		for _, h := range hashes {
+			if demangled {
+				h.Name = demangle.Filter(h.Name)
+			}
+			if h.Size >= minSize {
+				funcs = append(funcs, h)
+			}
+		}
+
+		groups := identicalFunctions(funcs)
+		similar := []dupeSimilar{}
+		if threshold < 1 {
+			for _, p := range elf2sql.NearDuplicates(funcs, threshold) {
+				similar = append(similar, dupeSimilar{p.Similarity,
+					dupeName(p.A), p.A.Size, dupeName(p.B), p.B.Size})
+			}
+		}
+
+		if df == elf2sql.DFJson {
+			b, e := json.Marshal(struct {
+				Identical []dupeGroup   `json:"identical"`
+				Similar   []dupeSimilar `json:"similar"`
+			}{groups, similar})
+			check(e)
+			fmt.Println(string(b))
+			return
+		}
+
+		var rows [][]interface{}
+		for _, g := range groups {
+			rows = append(rows, []interface{}{g.Hash[:16], g.Size, len(g.Functions),
+				g.Wasted, strings.Join(g.Functions, ", ")})
+		}
+		fmt.Print(elf2sql.RenderTable("Identical",
+			[]string{"Hash", "Size", "Count", "Wasted", "Functions"}, rows, df))
+
+		if threshold < 1 {
+			rows = nil
+			for _, s := range similar {
+				rows = append(rows, []interface{}{fmt.Sprintf("%.2f", s.Similarity),
+					s.A, s.SizeA, s.B, s.SizeB})
+			}
+			fmt.Print(elf2sql.RenderTable("Similar",
+				[]string{"Similarity", "Function", "Size", "Similar To", "Size"}, rows, df))
+		}
+	},
+}
+
+// hashFunctions hashes the functions in an ELF file, or in every ELF object
+// of a static library.
+func hashFunctions(name string) ([]elf2sql.CodeHash, error) {
+	data, e := os.ReadFile(name)
+	if e != nil {
+		return nil, e
+	}
+	if !elf2sql.IsArchive(data) {
+		img, e := elf2sql.NewImage(data)
+		if e != nil {
+			return nil, e
+		}
+		return img.CodeHashes(), nil
+	}
+
+	members, e := elf2sql.ReadArchive(data)
+	if e != nil {
+		return nil, e
+	}
+	var hashes []elf2sql.CodeHash
+	for _, m := range members {
+		if !bytes.HasPrefix(m.Data, []byte(elf.ELFMAG)) {
+			continue
+		}
+		img, e := elf2sql.NewImage(m.Data)
+		if e != nil {
+			return nil, fmt.Errorf("%s: %s", m.Name, e)
+		}
+		for _, h := range img.CodeHashes() {
+			h.Member = m.Name
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes, nil
+}
+
+// identicalFunctions groups functions by hash, returning the groups with
+// more than one name, largest saving first.
+func identicalFunctions(funcs []elf2sql.CodeHash) []dupeGroup {
+	byHash := make(map[string][]elf2sql.CodeHash)
+	var order []string
+	for _, f := range funcs {
+		if byHash[f.Hash] == nil {
+			order = append(order, f.Hash)
+		}
+		byHash[f.Hash] = append(byHash[f.Hash], f)
+	}
+
+	groups := []dupeGroup{}
+	for _, h := range order {
+		fs := byHash[h]
+		names := make(map[string]bool)
+		for _, f := range fs {
+			names[f.Name] = true
+		}
+		if len(names) < 2 {
+			continue
+		}
+		g := dupeGroup{Hash: h, Size: fs[0].Size, Wasted: fs[0].Size * uint64(len(fs)-1)}
+		for _, f := range fs {
+			g.Functions = append(g.Functions, dupeName(f))
+		}
+		groups = append(groups, g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Wasted > groups[j].Wasted
+	})
+	return groups
+}
+
+// dupeName returns a function's name, followed by its archive member if any.
+func dupeName(f elf2sql.CodeHash) string {
+	if f.Member != "" {
+		return fmt.Sprintf("%s (%s)", f.Name, f.Member)
+	}
+	return f.Name
+}
+
+func init() {
+	rootCmd.AddCommand(dupesCmd)
+
+	dupesCmd.Flags().Float64P("threshold", "t", 0.9, "minimum similarity of near-identical functions (0-1)")
+	dupesCmd.Flags().Uint64P("min-size", "m", 16, "ignore functions smaller than this many bytes")
+	dupesCmd.Flags().BoolP("demangle", "C", false, "demangle C++ function names")
+	dupesCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// gapsCmd represents the gaps command
+var gapsCmd = &cobra.Command{
+	Use:   "gaps filename",
+	Short: "Report padding, gaps, overlaps and aliases between symbols",
+	Long: `Walks the code and data symbols of each allocated section in address
+order, and reports the space that isn't covered by exactly one symbol:
+
+  padding  Alignment padding before a symbol
+  gap      Bytes not covered by any symbol, such as string literals or
+           assembly routines without a size
+  overlap  Bytes covered by more than one symbol
+  alias    A symbol at the same address as another
+
+By default a summary is shown for each section, giving the bytes lost to
+each kind. Use --all to list every entry instead. The same data is available
+in the 'layout_gaps' table of 'elfquery sql'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		// Populate the database with the ELF data
+		e := elf2sql.InitDB(args[0])
+		defer elf2sql.CloseDB()
+		if e != nil {
+			fmt.Printf("unable to initialise the SQLite3 database in memory\n")
+			return
+		}
+
+		where := ""
+		if sec, _ := cmd.Flags().GetString("section"); sec != "" {
+			where = fmt.Sprintf("AND s.Name = '%s'", strings.ReplaceAll(sec, "'", "''"))
+		}
+
+		query := fmt.Sprintf(`SELECT s.Name AS Section, s.Size,
+			s.Size - ifnull(SUM(CASE WHEN g.Kind IN ('padding', 'gap') THEN g.Size END), 0) AS Symbols,
+			ifnull(SUM(CASE WHEN g.Kind = 'padding' THEN g.Size END), 0) AS Padding,
+			ifnull(SUM(CASE WHEN g.Kind = 'gap' THEN g.Size END), 0) AS Gaps,
+			ifnull(SUM(CASE WHEN g.Kind = 'overlap' THEN g.Size END), 0) AS Overlaps,
+			COUNT(CASE WHEN g.Kind = 'alias' THEN 1 END) AS Aliases
+			FROM sections s LEFT JOIN layout_gaps g
+			ON g.SectionIndex = s.ID AND g.Member IS s.Member
+			WHERE s.IsAlloc = 1 AND s.Size > 0 %s
+			GROUP BY s.Member, s.ID ORDER BY s.Member, s.ID`, where)
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			query = fmt.Sprintf(`SELECT g.Section, g.Kind,
+				printf('0x%%X', g.Address) AS Address, g.Size,
+				ifnull(g.Before, '') AS Before, ifnull(g.After, '') AS After
+				FROM layout_gaps g JOIN sections s
+				ON g.SectionIndex = s.ID AND g.Member IS s.Member
+				WHERE 1 %s ORDER BY g.ID`, where)
+		}
+
+		s, e := elf2sql.RunQuery(query, df)
+		if e != nil {
+			fmt.Printf("invalid query: %s\n", query)
+			return
+		}
+		fmt.Print(s)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gapsCmd)
+
+	gapsCmd.Flags().BoolP("all", "a", false, "list every padding, gap, overlap and alias")
+	gapsCmd.Flags().StringP("section", "s", "", "only report on the named section")
+	gapsCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// hashResult is the JSON form of a file's content hash
+type hashResult struct {
+	File   string           `json:"file"`
+	Size   uint64           `json:"size"`
+	SHA256 string           `json:"sha256"`
+	Blocks []hashBlockEntry `json:"blocks"`
+}
+
+// hashBlockEntry is the JSON form of a hashed section or block
+type hashBlockEntry struct {
+	Section string `json:"section"`
+	Address uint64 `json:"address"`
+	Size    uint64 `json:"size"`
+	CRC32   uint32 `json:"crc32"`
+	SHA256  string `json:"sha256"`
+}
+
+// hashCmd represents the hash command
+var hashCmd = &cobra.Command{
+	Use:   "hash filename [filename...]",
+	Short: "Hash the loadable contents of ELF or image files",
+	Long: `Computes a SHA-256 hash of the loadable contents of an ELF file, which
+is the data that 'elfquery objcopy' would write, along with the CRC32 and
+SHA-256 of each section. Debug information, symbols, timestamps and the rest
+of the ELF container aren't included, so two builds of the same firmware
+give the same hash even when their DWARF differs.
+
+The hash covers the load address and contents of each contiguous run of
+bytes, so Intel HEX, S-record and raw binary files (loaded at --base) give
+the same hash as the ELF file they were made from. Sections can be selected
+with -j and excluded with -R, as for 'elfquery objcopy'.
+
+With one file, the hash of the whole file is shown in the '*' row. With more
+than one file, the hashes are compared and the exit status is 1 if they
+differ:
+
+  elfquery hash build1/zephyr/zephyr.elf build2/zephyr/zephyr.elf`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		var hashes []*elf2sql.ContentHash
+		for _, filename := range args {
+			m, e := readMemImage(cmd, filename)
+			if e != nil {
+				fmt.Printf("%s\n", e)
+				os.Exit(1)
+			}
+			hashes = append(hashes, elf2sql.HashMemImage(m))
+		}
+
+		same := true
+		for _, h := range hashes[1:] {
+			if h.SHA256 != hashes[0].SHA256 {
+				same = false
+			}
+		}
+
+		switch {
+		case df == elf2sql.DFJson:
+			var res []hashResult
+			for i, h := range hashes {
+				r := hashResult{args[i], h.Size, h.SHA256, []hashBlockEntry{}}
+				for _, b := range h.Blocks {
+					r.Blocks = append(r.Blocks, hashBlockEntry{b.Section, b.Address, b.Size,
+						b.CRC32, b.SHA256})
+				}
+				res = append(res, r)
+			}
+			b, e := json.Marshal(res)
+			check(e)
+			fmt.Println(string(b))
+		case len(hashes) == 1:
+			var rows [][]interface{}
+			for _, b := range hashes[0].Blocks {
+				rows = append(rows, []interface{}{b.Section, fmt.Sprintf("0x%08X", b.Address),
+					b.Size, fmt.Sprintf("0x%08X", b.CRC32), b.SHA256})
+			}
+			// The hash of the whole file is the '*' row
+			rows = append(rows, []interface{}{"*", "", hashes[0].Size, "", hashes[0].SHA256})
+			fmt.Print(elf2sql.RenderTable("", []string{"Section", "Address", "Size", "CRC32",
+				"SHA256"}, rows, df))
+		default:
+			var rows [][]interface{}
+			for i, h := range hashes {
+				rows = append(rows, []interface{}{args[i], h.Size, h.SHA256})
+			}
+			fmt.Print(elf2sql.RenderTable("", []string{"File", "Size", "SHA256"}, rows, df))
+
+			// Machine-readable formats rely on the exit status instead
+			switch {
+			case df != elf2sql.DFText && df != elf2sql.DFPretty && df != elf2sql.DFPrettyCol:
+			case same:
+				fmt.Printf("IDENTICAL: %d files have the same loadable contents\n", len(args))
+			default:
+				fmt.Printf("DIFFERENT: the loadable contents don't match\n")
+			}
+		}
+
+		if !same {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hashCmd)
+
+	hashCmd.Flags().StringArrayP("only-section", "j", nil, "only hash the named section (wildcards allowed)")
+	hashCmd.Flags().StringArrayP("remove-section", "R", nil, "don't hash the named section (wildcards allowed)")
+	hashCmd.Flags().String("base", "0", "load address of raw binary input, in hex")
+	hashCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// historyRow is the JSON form of a build in 'history show'
+type historyRow struct {
+	Commit     string   `json:"commit"`
+	Branch     string   `json:"branch"`
+	Timestamp  string   `json:"timestamp"`
+	Flash      int64    `json:"flash,omitempty"`
+	RAM        int64    `json:"ram,omitempty"`
+	Size       *int64   `json:"size,omitempty"`
+	Regression []string `json:"regression"`
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Track section and symbol sizes across commits",
+	Long: `Records the section and symbol sizes of each build in a persistent SQLite
+database, keyed by git commit, and shows how they change over time. The
+database is selected with --db and is created by 'history add'. It can also be
+queried with any SQLite tool (tables 'builds', 'history_sections' and
+'history_symbols'), or charted with 'elfquery http --history'.
+
+Flash usage is the size of every allocated section with contents, including
+the initial values of data, and RAM usage is the size of every writable
+allocated section.
+
+  elfquery history add build/zephyr/zephyr.elf --commit $(git rev-parse HEAD) --branch main
+  elfquery history show --branch main --threshold 1%
+  elfquery history show --symbol z_impl_k_sem_take
+  elfquery history blame flash --branch main`,
+}
+
+// historyAddCmd represents the history add command
+var historyAddCmd = &cobra.Command{
+	Use:   "add filename",
+	Short: "Add a build's section and symbol sizes to the history",
+	Long: `Appends the section and symbol sizes of an ELF file to the history
+database for the given commit. A build already recorded for the same commit
+and branch is replaced, so CI jobs can be re-run safely.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		commit, _ := cmd.Flags().GetString("commit")
+		if commit == "" {
+			fmt.Printf("a commit is required (--commit)\n")
+			os.Exit(1)
+		}
+		branch, _ := cmd.Flags().GetString("branch")
+
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			os.Exit(1)
+		}
+		db, _ := cmd.Flags().GetString("db")
+		h, e := elf2sql.CreateHistory(db)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+		defer h.Close()
+
+		_, e = h.Add(img, elf2sql.HistoryBuild{Commit: commit, Branch: branch, File: args[0]})
+		if e != nil {
+			fmt.Printf("unable to add build: %s\n", e)
+			os.Exit(1)
+		}
+		flash, ram := img.MemoryUsage()
+		fmt.Printf("Added %s: flash %d bytes, RAM %d bytes\n", shortCommit(commit), flash, ram)
+	},
+}
+
+// historyShowCmd represents the history show command
+var historyShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show flash, RAM, section or symbol sizes over time",
+	Long: `Lists the recorded builds in the order they were added, with their flash
+and RAM usage and the change from the previous build. With --section or
+--symbol, the size of that section or symbol is shown instead.
+
+Builds that grew by more than --threshold are marked as regressions. The
+threshold is in bytes ('512') or a percentage of the previous size ('1%').`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+		branch, _ := cmd.Flags().GetString("branch")
+		section, _ := cmd.Flags().GetString("section")
+		symbol, _ := cmd.Flags().GetString("symbol")
+		last, _ := cmd.Flags().GetInt("last")
+		s, _ := cmd.Flags().GetString("threshold")
+		threshold, e := elf2sql.ParseThreshold(s)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+
+		h, e := openHistory(cmd)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+		defer h.Close()
+
+		var res []historyRow
+		switch {
+		case section != "" || symbol != "":
+			kind, name := "section", section
+			if symbol != "" {
+				kind, name = "symbol", symbol
+			}
+			points, e := h.Trend(kind, name, branch)
+			if e != nil {
+				fmt.Printf("%s\n", e)
+				os.Exit(1)
+			}
+			for i, p := range points {
+				size := p.Size
+				r := historyRow{Commit: p.Build.Commit, Branch: p.Build.Branch,
+					Timestamp: p.Build.Timestamp, Size: &size, Regression: []string{}}
+				if i > 0 && threshold.Exceeded(points[i-1].Size, p.Size) {
+					r.Regression = append(r.Regression, name)
+				}
+				res = append(res, r)
+			}
+		default:
+			builds, e := h.Builds(branch)
+			if e != nil {
+				fmt.Printf("%s\n", e)
+				os.Exit(1)
+			}
+			for i, b := range builds {
+				r := historyRow{Commit: b.Commit, Branch: b.Branch, Timestamp: b.Timestamp,
+					Flash: b.Flash, RAM: b.RAM, Regression: []string{}}
+				if i > 0 && threshold.Exceeded(builds[i-1].Flash, b.Flash) {
+					r.Regression = append(r.Regression, "flash")
+				}
+				if i > 0 && threshold.Exceeded(builds[i-1].RAM, b.RAM) {
+					r.Regression = append(r.Regression, "ram")
+				}
+				res = append(res, r)
+			}
+		}
+
+		// Deltas are taken before limiting to the last builds
+		deltas := historyDeltas(res)
+		if last > 0 && len(res) > last {
+			deltas = deltas[len(res)-last:]
+			res = res[len(res)-last:]
+		}
+
+		if df == elf2sql.DFJson {
+			if res == nil {
+				res = []historyRow{}
+			}
+			b, e := json.Marshal(res)
+			check(e)
+			fmt.Println(string(b))
+			return
+		}
+
+		var rows [][]interface{}
+		for i, r := range res {
+			row := []interface{}{shortCommit(r.Commit), r.Branch, r.Timestamp}
+			if r.Size != nil {
+				row = append(row, *r.Size, deltas[i][0])
+			} else {
+				row = append(row, r.Flash, deltas[i][0], r.RAM, deltas[i][1])
+			}
+			rows = append(rows, append(row, strings.Join(r.Regression, ", ")))
+		}
+		header := []string{"Commit", "Branch", "Timestamp", "Flash", "Change", "RAM", "Change",
+			"Regression"}
+		if section != "" || symbol != "" {
+			header = []string{"Commit", "Branch", "Timestamp", "Size", "Change", "Regression"}
+		}
+		fmt.Print(elf2sql.RenderTable("", header, rows, df))
+	},
+}
+
+// historyBlameCmd represents the history blame command
+var historyBlameCmd = &cobra.Command{
+	Use:   "blame name",
+	Short: "Find the commit where a section or symbol grew, and why",
+	Long: `Finds the build where a section or symbol grew, and lists the symbols
+that changed size in that build, largest growth first. The name can also be
+'flash' or 'ram' for the total memory usage, when no section or symbol has
+that name.
+
+The build reported is the first to exceed --limit bytes, or the first to
+grow by more than --threshold (in bytes or percent) from the previous build,
+or else the one with the largest increase:
+
+  elfquery history blame text --limit 65536
+  elfquery history blame ram --threshold 256
+  elfquery history blame z_impl_k_sem_take`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+		branch, _ := cmd.Flags().GetString("branch")
+		limit, _ := cmd.Flags().GetInt64("limit")
+		var threshold *elf2sql.Threshold
+		if cmd.Flags().Changed("threshold") {
+			s, _ := cmd.Flags().GetString("threshold")
+			t, e := elf2sql.ParseThreshold(s)
+			if e != nil {
+				fmt.Printf("%s\n", e)
+				os.Exit(1)
+			}
+			threshold = &t
+		}
+
+		h, e := openHistory(cmd)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+		defer h.Close()
+
+		b, e := h.Blame(args[0], branch, limit, threshold)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+
+		if df == elf2sql.DFJson {
+			type symbol struct {
+				Name    string `json:"name"`
+				Section string `json:"section"`
+				Before  int64  `json:"before"`
+				After   int64  `json:"after"`
+				Change  int64  `json:"change"`
+			}
+			res := struct {
+				Kind     string   `json:"kind"`
+				Name     string   `json:"name"`
+				Reason   string   `json:"reason"`
+				Commit   string   `json:"commit"`
+				Branch   string   `json:"branch"`
+				Previous string   `json:"previous"`
+				Before   int64    `json:"before"`
+				After    int64    `json:"after"`
+				Symbols  []symbol `json:"symbols"`
+			}{b.Kind, b.Name, b.Reason, b.Build.Commit, b.Build.Branch, b.Prev.Commit, b.Before,
+				b.After, []symbol{}}
+			for _, s := range b.Symbols {
+				res.Symbols = append(res.Symbols, symbol{s.Name, s.Section, s.Before, s.After,
+					s.After - s.Before})
+			}
+			out, e := json.Marshal(res)
+			check(e)
+			fmt.Println(string(out))
+			return
+		}
+
+		what := b.Kind + " " + b.Name
+		if b.Kind == b.Name {
+			what = b.Name
+		}
+		fmt.Printf("%s %s at %s (%s, %s): %d -> %d bytes (%s)\n", what, b.Reason,
+			shortCommit(b.Build.Commit), b.Build.Branch, b.Build.Timestamp, b.Before, b.After,
+			signed(b.After-b.Before))
+		fmt.Printf("Previous build: %s\n", shortCommit(b.Prev.Commit))
+		if len(b.Symbols) == 0 {
+			fmt.Printf("No symbols changed size; the growth is in unnamed data or padding\n")
+			return
+		}
+		var rows [][]interface{}
+		for _, s := range b.Symbols {
+			rows = append(rows, []interface{}{s.Name, s.Section, s.Before, s.After,
+				signed(s.After - s.Before)})
+		}
+		fmt.Print(elf2sql.RenderTable("", []string{"Symbol", "Section", "Before", "After",
+			"Change"}, rows, df))
+	},
+}
+
+// historyDeltas returns the change in size (or flash and RAM usage) of each
+// build from the previous one, formatted with a sign.
+func historyDeltas(res []historyRow) [][2]string {
+	deltas := make([][2]string, len(res))
+	for i := 1; i < len(res); i++ {
+		if res[i].Size != nil {
+			deltas[i][0] = signed(*res[i].Size - *res[i-1].Size)
+		} else {
+			deltas[i][0] = signed(res[i].Flash - res[i-1].Flash)
+			deltas[i][1] = signed(res[i].RAM - res[i-1].RAM)
+		}
+	}
+	return deltas
+}
+
+// signed formats a change in size with an explicit sign.
+func signed(n int64) string {
+	if n > 0 {
+		return fmt.Sprintf("+%d", n)
+	}
+	return fmt.Sprint(n)
+}
+
+// shortCommit abbreviates a git commit hash to 12 characters.
+func shortCommit(c string) string {
+	if len(c) > 12 {
+		return c[:12]
+	}
+	return c
+}
+
+// openHistory opens the existing history database named by --db.
+func openHistory(cmd *cobra.Command) (*elf2sql.History, error) {
+	db, _ := cmd.Flags().GetString("db")
+	return elf2sql.OpenHistory(db)
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyAddCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	historyCmd.AddCommand(historyBlameCmd)
+
+	historyCmd.PersistentFlags().String("db", "history.db", "history database file")
+
+	historyAddCmd.Flags().String("commit", "", "git commit of the build")
+	historyAddCmd.Flags().String("branch", "", "git branch of the build")
+
+	historyShowCmd.Flags().String("branch", "", "only show builds of this branch")
+	historyShowCmd.Flags().String("section", "", "show the size of this section")
+	historyShowCmd.Flags().String("symbol", "", "show the size of this symbol")
+	historyShowCmd.Flags().String("threshold", "1%", "growth that counts as a regression, in bytes or percent")
+	historyShowCmd.Flags().IntP("last", "n", 0, "only show the last n builds")
+	historyShowCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+
+	historyBlameCmd.Flags().String("branch", "", "only search builds of this branch")
+	historyBlameCmd.Flags().Int64("limit", 0, "find the first build larger than this many bytes")
+	historyBlameCmd.Flags().String("threshold", "", "find the first build that grew by more than this, in bytes or percent")
+	historyBlameCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
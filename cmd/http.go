@@ -10,14 +10,19 @@ import (
 
 // httpCmd represents the http command
 var httpCmd = &cobra.Command{
-	Use:   "http filename",
+	Use:   "http [filename]",
 	Short: "HTTP based file analysis",
 	Long: `Starts up an HTTP server instance that can be used to perform
 detailed analysis of the specified ELF file.`,
-	Args: cobra.MinimumNArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// Populate the database with the ELF data
-		e := elf2sql.InitDB(args[0])
+		filename, e := loadOptions(cmd, args)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return
+		}
+		e = elf2sql.InitDB(filename)
 		defer elf2sql.CloseDB()
 		if e != nil {
 			fmt.Printf("unable to initialise the SQLite3 database in memory\n")
@@ -25,6 +30,8 @@ detailed analysis of the specified ELF file.`,
 		}
 
 		// Start thee HTTP server
+		httpserver.HistoryFile, _ = cmd.Flags().GetString("history")
+		httpserver.Threshold, _ = cmd.Flags().GetString("threshold")
 		port, _ := cmd.Flags().GetInt16("port")
 		httpserver.Start(port)
 	},
@@ -35,4 +42,11 @@ func init() {
 
 	// Allow a custom port number
 	httpCmd.PersistentFlags().Int16P("port", "p", 1443, "Port number")
+	httpCmd.Flags().StringP("stack-usage", "u", "", "directory of GCC .su files for the 'stack_usage' table")
+	httpCmd.Flags().BoolP("zephyr", "z", false, "decode Zephyr RTOS devices, init entries, stacks and more into the 'zephyr_*' tables")
+	httpCmd.Flags().StringP("build-dir", "b", "", "Zephyr build directory to load the ELF, map, .config and devicetree from")
+	httpCmd.Flags().String("svd", "", "CMSIS-SVD file for the 'peripherals', 'registers' and 'peripheral_refs' tables")
+	httpCmd.Flags().String("history", "", "size history database to chart at /history (see 'elfquery history')")
+	httpCmd.Flags().String("threshold", "1%", "growth highlighted as a regression on the history chart, in bytes or percent")
+	httpCmd.Flags().String("mcuboot", "", "signed MCUboot image for the 'mcuboot' and 'mcuboot_tlvs' tables")
 }
@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"debug/elf"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
+	"github.com/microbuilder/elfquery/elf2sql"
 	"github.com/spf13/cobra"
 )
 
@@ -15,10 +17,25 @@ var infoCmd = &cobra.Command{
 	Use:   "info filename",
 	Short: "Basic file details",
 	Long: `Lists key information about the specified ELF file, such as the
-target machine, ELF file type, sections, etc.`,
+target machine, ELF file type, sections, etc.
+
+By default the details are printed in a readable, free-form layout. With -o,
+they are rendered as tables in the same formats as 'elfquery sql', or as a
+nested JSON object with 'header', 'attributes', 'sizes', 'segments' and
+'sections' members (segments and sections require --full). In CSV, each
+table starts with its own header row, and every row begins with the name of
+its table:
+
+  elfquery info zephyr.elf --full -o json | jq .sizes.total`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		full, _ := cmd.Flags().GetBool("full")
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if output != "" && !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
 
 		f := ioReader(args[0])
 		_elf, err := elf.NewFile(f)
@@ -34,93 +51,285 @@ target machine, ELF file type, sections, etc.`,
 			os.Exit(1)
 		}
 
-		var arch string
-		switch _elf.Class.String() {
-		case "ELFCLASS64":
-			arch = "64 bits"
-		case "ELFCLASS32":
-			arch = "32 bits"
-		}
+		img, err := elf2sql.OpenImage(args[0])
+		check(err)
+		info := readInfo(_elf, img, full)
 
-		var mach string
-		switch _elf.Machine.String() {
-		case "EM_AARCH64":
-			mach = "ARM64"
-		case "EM_ARM":
-			mach = "ARM"
-		case "EM_386":
-			mach = "x86"
-		case "EM_X86_64":
-			mach = "x86_64"
+		switch {
+		case output == "":
+			printInfo(info, full)
+		case df == elf2sql.DFJson:
+			b, err := json.Marshal(info)
+			check(err)
+			fmt.Println(string(b))
+		default:
+			fmt.Print(renderInfo(info, full, df))
 		}
+	},
+}
+
+// infoHeader holds the decoded ELF file header
+type infoHeader struct {
+	Machine    string `json:"machine"`
+	Class      string `json:"class"`
+	Type       string `json:"type"`
+	Data       string `json:"data"`
+	OSABI      string `json:"os_abi"`
+	ABIVersion uint8  `json:"abi_version"`
+	Entry      uint64 `json:"entry"`
+	Flags      string `json:"flags"`
+}
 
-		fmt.Printf("Machine: %s\n", mach)
-		fmt.Printf("ELF Class: %s\n", arch)
-		fmt.Printf("ELF Type: %s\n", _elf.Type)
-		fmt.Printf("ELF Data: %s\n", _elf.Data)
-		fmt.Printf("OS ABI: %s\n", _elf.OSABI)
-		fmt.Printf("OS ABI Version: 0x%X\n", _elf.ABIVersion)
-		fmt.Printf("Entry Point: 0x%08X\n", _elf.Entry)
+// infoAttribute is a decoded build attribute
+type infoAttribute struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
 
-		// Calculate size data across each section
-		sztext, szdata, szbss := 0, 0, 0
-		for _, s := range _elf.Sections {
-			_text, _data, _bss := sectionSize(s.SectionHeader)
-			sztext += _text
-			szdata += _data
-			szbss += _bss
+// infoSizes holds the text, data and bss totals, as reported by 'size'
+type infoSizes struct {
+	Text  int `json:"text"`
+	Data  int `json:"data"`
+	BSS   int `json:"bss"`
+	Total int `json:"total"`
+}
+
+// infoSegment is a program header, along with the sections it contains
+type infoSegment struct {
+	Index    int      `json:"index"`
+	Type     string   `json:"type"`
+	Offset   uint64   `json:"offset"`
+	VirtAddr uint64   `json:"virt_addr"`
+	PhysAddr uint64   `json:"phys_addr"`
+	FileSize uint64   `json:"file_size"`
+	MemSize  uint64   `json:"mem_size"`
+	Align    uint64   `json:"align"`
+	Flags    string   `json:"flags"`
+	Sections []string `json:"sections"`
+}
+
+// infoSection is a section header
+type infoSection struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Flags   string `json:"flags"`
+	Address uint64 `json:"address"`
+	Size    uint64 `json:"size"`
+}
+
+// infoResult holds everything reported by the info command. Segments and
+// sections are only included with --full.
+type infoResult struct {
+	Header     infoHeader      `json:"header"`
+	Attributes []infoAttribute `json:"attributes,omitempty"`
+	Sizes      infoSizes       `json:"sizes"`
+	Segments   []infoSegment   `json:"segments,omitempty"`
+	Sections   []infoSection   `json:"sections,omitempty"`
+}
+
+// readInfo collects the file details reported by the info command.
+func readInfo(_elf *elf.File, img *elf2sql.Image, full bool) infoResult {
+	var info infoResult
+
+	var arch string
+	switch _elf.Class.String() {
+	case "ELFCLASS64":
+		arch = "64 bits"
+	case "ELFCLASS32":
+		arch = "32 bits"
+	}
+
+	info.Header = infoHeader{
+		Machine:    elf2sql.MachineName(_elf.Machine),
+		Class:      arch,
+		Type:       _elf.Type.String(),
+		Data:       _elf.Data.String(),
+		OSABI:      elf2sql.OSABIName(_elf.OSABI),
+		ABIVersion: _elf.ABIVersion,
+		Entry:      _elf.Entry,
+		Flags:      img.FlagsString(),
+	}
+
+	// Decode the build attributes
+	attrs, _ := img.BuildAttributes()
+	for _, a := range attrs {
+		info.Attributes = append(info.Attributes, infoAttribute{a.Section, a.Name, a.Value})
+	}
+
+	// Calculate size data across each section
+	for _, s := range _elf.Sections {
+		_text, _data, _bss := sectionSize(s.SectionHeader, true)
+		info.Sizes.Text += _text
+		info.Sizes.Data += _data
+		info.Sizes.BSS += _bss
+	}
+	info.Sizes.Total = info.Sizes.Text + info.Sizes.Data + info.Sizes.BSS
+
+	if !full {
+		return info
+	}
+
+	// Program headers (comparable to 'readelf -Wl'), and the sections that
+	// fall within each segment
+	info.Segments = []infoSegment{}
+	for i, p := range _elf.Progs {
+		seg := infoSegment{
+			Index:    i,
+			Type:     p.Type.String(),
+			Offset:   p.Off,
+			VirtAddr: p.Vaddr,
+			PhysAddr: p.Paddr,
+			FileSize: p.Filesz,
+			MemSize:  p.Memsz,
+			Align:    p.Align,
+			Flags:    p.Flags.String(),
+			Sections: []string{},
 		}
-		fmt.Printf("Text size: %d\n", sztext)
-		fmt.Printf("Data size: %d\n", szdata)
-		fmt.Printf("BSS size: %d\n", szbss)
-		fmt.Printf("Total size: %d\n", sztext+szdata+szbss)
-
-		if full {
-			// List program headers (comparable to 'readelf -Wl')
-			fmt.Printf("Program Headers (%d):\n", len(_elf.Progs))
-			fmt.Printf("     Type          Offset   VirtAddr   PhysAddr   FileSz  MemSz   Align Flags\n")
-			segnum := 0
-			for _, p := range _elf.Progs {
-				fmt.Printf("  %02d %-13s 0x%06X 0x%08X 0x%08X 0x%05X 0x%05X    %02d %s\n",
-					segnum, p.Type.String(), p.Off, p.Vaddr, p.Paddr, p.Filesz, p.Memsz, p.Align, p.Flags.String())
-				segnum++
+		for _, s := range _elf.Sections {
+			if (s.Size > 0) && (s.Addr >= p.Vaddr) && (s.Addr < p.Vaddr+p.Memsz) {
+				seg.Sections = append(seg.Sections, s.Name)
 			}
+		}
+		info.Segments = append(info.Segments, seg)
+	}
 
-			// List sections
-			fmt.Printf("Sections (%d):\n", len(_elf.Sections))
-			fmt.Printf("  Address        Size Name\n")
-			for _, s := range _elf.Sections {
-				fmt.Printf("  0x%08X %8d %s\n", s.Addr, s.Size, s.Name)
-			}
+	// Sections
+	info.Sections = []infoSection{}
+	for i, s := range _elf.Sections {
+		info.Sections = append(info.Sections, infoSection{
+			Index:   i,
+			Name:    s.Name,
+			Type:    elf2sql.SectionTypeName(s.Type, _elf.Machine),
+			Flags:   elf2sql.SectionFlagsString(s.Flags),
+			Address: s.Addr,
+			Size:    s.Size,
+		})
+	}
+
+	return info
+}
+
+// printInfo displays the file details in the default, human-readable form.
+func printInfo(info infoResult, full bool) {
+	fmt.Printf("Machine: %s\n", info.Header.Machine)
+	fmt.Printf("ELF Class: %s\n", info.Header.Class)
+	fmt.Printf("ELF Type: %s\n", info.Header.Type)
+	fmt.Printf("ELF Data: %s\n", info.Header.Data)
+	fmt.Printf("OS ABI: %s\n", info.Header.OSABI)
+	fmt.Printf("OS ABI Version: 0x%X\n", info.Header.ABIVersion)
+	fmt.Printf("Entry Point: 0x%08X\n", info.Header.Entry)
+	fmt.Printf("Flags: %s\n", info.Header.Flags)
+	if len(info.Attributes) > 0 {
+		fmt.Printf("Attributes (%s):\n", info.Attributes[0].Section)
+		for _, a := range info.Attributes {
+			fmt.Printf("  %s: %s\n", a.Name, a.Value)
+		}
+	}
 
-			// Map sections to program headers
-			fmt.Printf("Section to Program Headers Map:\n")
-			segnum = 0
-			for _, p := range _elf.Progs {
-				fmt.Printf("  %02d ", segnum)
-				// Check is sections match current segment
-				for _, s := range _elf.Sections {
-					if (s.Size > 0) && (s.Addr >= p.Vaddr) && (s.Addr < p.Vaddr+p.Memsz) {
-						fmt.Printf("%s ", s.Name)
-					}
-				}
-				fmt.Printf("\n")
-				segnum++
+	fmt.Printf("Text size: %d\n", info.Sizes.Text)
+	fmt.Printf("Data size: %d\n", info.Sizes.Data)
+	fmt.Printf("BSS size: %d\n", info.Sizes.BSS)
+	fmt.Printf("Total size: %d\n", info.Sizes.Total)
+
+	if full {
+		// List program headers (comparable to 'readelf -Wl')
+		fmt.Printf("Program Headers (%d):\n", len(info.Segments))
+		fmt.Printf("     Type          Offset   VirtAddr   PhysAddr   FileSz  MemSz   Align Flags\n")
+		for _, p := range info.Segments {
+			fmt.Printf("  %02d %-13s 0x%06X 0x%08X 0x%08X 0x%05X 0x%05X    %02d %s\n",
+				p.Index, p.Type, p.Offset, p.VirtAddr, p.PhysAddr, p.FileSize, p.MemSize, p.Align, p.Flags)
+		}
+
+		// List sections
+		fmt.Printf("Sections (%d):\n", len(info.Sections))
+		fmt.Printf("  Address        Size Name\n")
+		for _, s := range info.Sections {
+			fmt.Printf("  0x%08X %8d %s\n", s.Address, s.Size, s.Name)
+		}
+
+		// Map sections to program headers
+		fmt.Printf("Section to Program Headers Map:\n")
+		for _, p := range info.Segments {
+			fmt.Printf("  %02d ", p.Index)
+			for _, s := range p.Sections {
+				fmt.Printf("%s ", s)
 			}
+			fmt.Printf("\n")
 		}
-	},
+	}
+}
+
+// renderInfo displays the file details as a series of tables, using one of
+// the table output formats shared with the sql command.
+func renderInfo(info infoResult, full bool, df elf2sql.DisplayFormat) string {
+	render := func(title string, header []string, rows [][]interface{}) string {
+		if df != elf2sql.DFCSV {
+			return elf2sql.RenderTable(title, header, rows, df)
+		}
+		// CSV has no titles, so the table is named in the first column
+		header = append([]string{"Table"}, header...)
+		for i, r := range rows {
+			rows[i] = append([]interface{}{title}, r...)
+		}
+		return elf2sql.RenderTable("", header, rows, df)
+	}
+
+	h := info.Header
+	header := [][]interface{}{
+		{"Machine", h.Machine},
+		{"Class", h.Class},
+		{"Type", h.Type},
+		{"Data", h.Data},
+		{"OSABI", h.OSABI},
+		{"ABIVersion", fmt.Sprintf("0x%X", h.ABIVersion)},
+		{"Entry", fmt.Sprintf("0x%08X", h.Entry)},
+		{"Flags", h.Flags},
+	}
+	for _, a := range info.Attributes {
+		header = append(header, []interface{}{a.Name, a.Value})
+	}
+	s := render("Header", []string{"Field", "Value"}, header)
+
+	sizes := [][]interface{}{{info.Sizes.Text, info.Sizes.Data, info.Sizes.BSS, info.Sizes.Total}}
+	s += render("Sizes", []string{"Text", "Data", "BSS", "Total"}, sizes)
+
+	if !full {
+		return s
+	}
+
+	var segs [][]interface{}
+	for _, p := range info.Segments {
+		segs = append(segs, []interface{}{p.Index, p.Type, fmt.Sprintf("0x%06X", p.Offset),
+			fmt.Sprintf("0x%08X", p.VirtAddr), fmt.Sprintf("0x%08X", p.PhysAddr),
+			p.FileSize, p.MemSize, p.Align, p.Flags, strings.Join(p.Sections, " ")})
+	}
+	s += render("Segments", []string{"Index", "Type", "Offset", "VirtAddr",
+		"PhysAddr", "FileSize", "MemSize", "Align", "Flags", "Sections"}, segs)
+
+	var secs [][]interface{}
+	for _, sec := range info.Sections {
+		secs = append(secs, []interface{}{sec.Index, sec.Name, sec.Type, sec.Flags,
+			fmt.Sprintf("0x%08X", sec.Address), sec.Size})
+	}
+	s += render("Sections", []string{"Index", "Name", "Type", "Flags", "Address", "Size"}, secs)
+
+	return s
 }
 
 // sectionSize determine the text, data and bss size for the supplied ELF
 // section header using the same algorithm as GNU binutils 'size' tool.
-func sectionSize(sec elf.SectionHeader) (int, int, int) {
+// Read-only data counts as text in the Berkeley format ('size -B'), and as
+// data in the GNU format ('size -G').
+func sectionSize(sec elf.SectionHeader, berkeley bool) (int, int, int) {
 	text, data, bss := 0, 0, 0
 
 	// Only count allocated memory
 	if strings.Contains(sec.Flags.String(), "SHF_ALLOC") {
 		// Text consists of executable instructions, or not writable
 		if strings.Contains(sec.Flags.String(), "SHF_EXECINSTR") ||
-			!strings.Contains(sec.Flags.String(), "SHF_WRITE") {
+			(berkeley && !strings.Contains(sec.Flags.String(), "SHF_WRITE")) {
 			text = int(sec.Size)
 		} else {
 			// No data means bss
@@ -152,4 +361,5 @@ func init() {
 	rootCmd.AddCommand(infoCmd)
 
 	infoCmd.Flags().BoolP("full", "f", false, "Display full result set")
+	infoCmd.Flags().StringP("output", "o", "", "output format (text, pretty, color, csv, md, html, json)")
 }
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// mcubootResult is the JSON form of the 'mcuboot' report
+type mcubootResult struct {
+	Magic            uint32       `json:"magic"`
+	LoadAddress      uint32       `json:"load_address"`
+	HeaderSize       uint16       `json:"header_size"`
+	ImageSize        uint32       `json:"image_size"`
+	ProtectedTLVSize uint16       `json:"protected_tlv_size"`
+	Flags            []string     `json:"flags"`
+	Version          string       `json:"version"`
+	HashType         string       `json:"hash_type"`
+	Hash             string       `json:"hash"`
+	HashValid        bool         `json:"hash_valid"`
+	TLVs             []mcubootTLV `json:"tlvs"`
+}
+
+// mcubootTLV is the JSON form of a TLV entry
+type mcubootTLV struct {
+	Area   string `json:"area"`
+	Type   uint16 `json:"type"`
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Value  string `json:"value"`
+}
+
+// mcubootCmd represents the mcuboot command
+var mcubootCmd = &cobra.Command{
+	Use:   "mcuboot image",
+	Short: "Show the header and TLVs of a signed MCUboot image",
+	Long: `Parses a signed MCUboot image, as written by imgtool or Zephyr's
+'zephyr.signed.bin', and shows its header (magic, load address, header and
+image sizes, flags and version) and the entries of the protected and
+unprotected TLV areas, such as the image hash, key hash, signature,
+dependencies and security counter.
+
+The image can be a raw binary, Intel HEX or S-record file. The SHA-256 (or
+SHA-384/SHA-512) hash is recomputed over the header, payload and protected
+TLVs, and the exit status is 1 if it doesn't match the image's hash TLV.
+Signatures are listed but not checked, since that requires the public key.
+
+The same information is available in the 'mcuboot' and 'mcuboot_tlvs' tables
+with 'elfquery sql --mcuboot':
+
+  elfquery mcuboot build/zephyr/zephyr.signed.bin -o json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		img, e := elf2sql.ReadMCUboot(args[0])
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+		h := img.Header
+
+		if df == elf2sql.DFJson {
+			res := mcubootResult{h.Magic, h.LoadAddr, h.HdrSize, h.ImgSize, h.ProtectTLVSize,
+				h.FlagNames(), h.Version.String(), img.HashType, hex.EncodeToString(img.Hash),
+				img.HashOK, []mcubootTLV{}}
+			if res.Flags == nil {
+				res.Flags = []string{}
+			}
+			for _, t := range img.TLVs {
+				res.TLVs = append(res.TLVs, mcubootTLV{t.Area(), t.Type, t.Name(), t.Offset,
+					len(t.Data), t.Value()})
+			}
+			b, e := json.Marshal(res)
+			check(e)
+			fmt.Println(string(b))
+		} else {
+			rows := [][]interface{}{
+				{"Magic", fmt.Sprintf("0x%08X", h.Magic)},
+				{"Load address", fmt.Sprintf("0x%08X", h.LoadAddr)},
+				{"Header size", h.HdrSize},
+				{"Image size", h.ImgSize},
+				{"Protected TLV size", h.ProtectTLVSize},
+				{"Flags", strings.Join(h.FlagNames(), ", ")},
+				{"Version", h.Version.String()},
+			}
+			fmt.Print(elf2sql.RenderTable("Header", []string{"Field", "Value"}, rows, df))
+
+			rows = nil
+			for _, t := range img.TLVs {
+				rows = append(rows, []interface{}{t.Area(), fmt.Sprintf("0x%02X", t.Type),
+					t.Name(), fmt.Sprintf("0x%X", t.Offset), len(t.Data), t.Value()})
+			}
+			fmt.Print(elf2sql.RenderTable("TLVs", []string{"Area", "Type", "Name", "Offset",
+				"Length", "Value"}, rows, df))
+
+			switch {
+			case img.HashType == "":
+				fmt.Println("Hash: no hash TLV found")
+			case img.HashOK:
+				fmt.Printf("Hash: OK (%s %s)\n", img.HashType, hex.EncodeToString(img.Hash))
+			default:
+				fmt.Printf("Hash: MISMATCH (%s computed %s)\n", img.HashType,
+					hex.EncodeToString(img.Hash))
+			}
+		}
+
+		if img.HashType != "" && !img.HashOK {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mcubootCmd)
+
+	mcubootCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
@@ -0,0 +1,505 @@
+package cmd
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// nmOptions holds the settings for the nm command
+type nmOptions struct {
+	format       string
+	radix        string
+	fileName     bool
+	multiFile    bool
+	demangle     bool
+	printSize    bool
+	sizeSort     bool
+	numericSort  bool
+	noSort       bool
+	reverse      bool
+	debugSyms    bool
+	specialSyms  bool
+	externOnly   bool
+	undefOnly    bool
+	definedOnly  bool
+	noWeak       bool
+	quiet        bool
+	dynamic      bool
+	valueWidth   int
+	valueFmt     string
+	machine      elf.Machine
+	fileArchive  string
+	fileMember   string
+	fileFullName string
+}
+
+// nmCmd represents the nm command
+var nmCmd = &cobra.Command{
+	Use:   "nm [filename...]",
+	Short: "List symbols, like GNU nm",
+	Long: `Lists the symbols of each ELF file from the 'symbols' table, with
+output that is identical to GNU binutils 'nm', so that it can be used as a
+drop-in replacement in scripts. Static libraries list each of their members.
+
+Each symbol is shown with its nm type letter, which is lowercase for local
+symbols:
+
+  A  Absolute               N  Debugging section
+  B  Uninitialised data     n  Read-only, non-allocated section
+  C  Common                 R  Read-only data
+  D  Initialised data       T  Code
+  i  Indirect function      U  Undefined
+  u  Unique global          V  Weak object (v if undefined)
+  W  Weak symbol (w if undefined)
+
+Mapping symbols, such as ARM '$t' and '$d', are hidden unless
+--special-syms is given. With --dynamic, the dynamic symbols are listed
+instead of the full symbol table, which stripped files don't have. The bsd (default), sysv, posix and just-symbols
+formats are supported. When no file is given, 'a.out' is used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		o := &nmOptions{}
+		o.format, _ = cmd.Flags().GetString("format")
+		if bsd, _ := cmd.Flags().GetBool("bsd"); bsd {
+			o.format = "bsd"
+		}
+		if posix, _ := cmd.Flags().GetBool("portability"); posix {
+			o.format = "posix"
+		}
+		if just, _ := cmd.Flags().GetBool("just-symbols"); just {
+			o.format = "just-symbols"
+		}
+		switch o.format {
+		case "bsd", "b", "B":
+			o.format = "bsd"
+		case "sysv", "s", "S":
+			o.format = "sysv"
+		case "posix", "p", "P":
+			o.format = "posix"
+		case "just-symbols", "j", "J":
+			o.format = "just-symbols"
+		default:
+			fmt.Printf("invalid format: %s\n", o.format)
+			return
+		}
+
+		o.radix, _ = cmd.Flags().GetString("radix")
+		switch o.radix {
+		case "x", "d", "o":
+		default:
+			fmt.Printf("invalid radix: %s\n", o.radix)
+			return
+		}
+
+		o.fileName, _ = cmd.Flags().GetBool("print-file-name")
+		if oflag, _ := cmd.Flags().GetBool("o"); oflag {
+			o.fileName = true
+		}
+		o.demangle, _ = cmd.Flags().GetBool("demangle")
+		o.printSize, _ = cmd.Flags().GetBool("print-size")
+		o.sizeSort, _ = cmd.Flags().GetBool("size-sort")
+		o.numericSort, _ = cmd.Flags().GetBool("numeric-sort")
+		o.noSort, _ = cmd.Flags().GetBool("no-sort")
+		o.reverse, _ = cmd.Flags().GetBool("reverse-sort")
+		o.debugSyms, _ = cmd.Flags().GetBool("debug-syms")
+		o.specialSyms, _ = cmd.Flags().GetBool("special-syms")
+		o.externOnly, _ = cmd.Flags().GetBool("extern-only")
+		o.undefOnly, _ = cmd.Flags().GetBool("undefined-only")
+		o.definedOnly, _ = cmd.Flags().GetBool("defined-only")
+		o.noWeak, _ = cmd.Flags().GetBool("no-weak")
+		o.quiet, _ = cmd.Flags().GetBool("quiet")
+		o.dynamic, _ = cmd.Flags().GetBool("dynamic")
+
+		if len(args) == 0 {
+			args = []string{"a.out"}
+		}
+		o.multiFile = len(args) > 1
+
+		failed := false
+		for _, name := range args {
+			if !o.file(name) {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// file lists the symbols of a single file or archive, returning false if it
+// couldn't be read.
+func (o *nmOptions) file(name string) bool {
+	objs, e := readObjects("nm", name)
+	if os.IsNotExist(e) {
+		fmt.Fprintf(os.Stderr, "nm: '%s': No such file\n", name)
+		return false
+	}
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "nm: %s: %s\n", name, e)
+		return false
+	}
+
+	elf2sql.DynamicSymbols = o.dynamic
+	e = elf2sql.InitDB(name)
+	defer elf2sql.CloseDB()
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "nm: %s: %s\n", name, e)
+		return false
+	}
+	syms, e := elf2sql.NMSymbols()
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "nm: %s: %s\n", name, e)
+		return false
+	}
+
+	// Group the symbols by archive member
+	members := make(map[string][]elf2sql.NMSymbol)
+	for _, s := range syms {
+		members[s.Member] = append(members[s.Member], s)
+	}
+
+	if len(objs) > 0 && objs[0].Archive != "" && o.multiFile && o.format == "bsd" {
+		fmt.Printf("\n%s:\n", name)
+	}
+	for _, obj := range objs {
+		member := ""
+		if obj.Archive != "" {
+			member = obj.Name
+		}
+		o.object(obj, members[member])
+	}
+	return true
+}
+
+// object lists the symbols of a single ELF object.
+func (o *nmOptions) object(obj elfObject, syms []elf2sql.NMSymbol) {
+	o.machine = obj.File.Machine
+	o.valueWidth = 8
+	if obj.File.Class == elf.ELFCLASS64 {
+		o.valueWidth = 16
+	}
+	o.valueFmt = fmt.Sprintf("%%0%d%s", o.valueWidth, o.radix)
+	if o.format == "posix" || o.format == "just-symbols" {
+		o.valueFmt = "%" + o.radix
+	}
+
+	o.fileArchive = obj.Archive
+	o.fileMember = obj.Name
+	o.fileFullName = obj.Name
+	if obj.Archive != "" {
+		o.fileFullName = fmt.Sprintf("%s[%s]", obj.Archive, obj.Name)
+	}
+	o.printHeader()
+
+	// The 'symbols' table falls back to the dynamic symbols of stripped
+	// files, which nm only lists with -D
+	if o.dynamic {
+		syms = nmVersions(obj.File, syms)
+	} else if obj.File.SectionByType(elf.SHT_SYMTAB) == nil {
+		syms = nil
+	}
+
+	if len(syms) == 0 {
+		if !o.quiet {
+			fmt.Fprintf(os.Stderr, "nm: %s: no symbols\n", obj.Name)
+		}
+		return
+	}
+
+	syms = o.filter(syms)
+	o.sort(syms)
+	for _, s := range syms {
+		o.printSymbol(s)
+	}
+}
+
+// nmVersions sets the version suffix of the dynamic symbols of a shared
+// object or dynamically linked file. As in nm, versions defined by the file
+// are shown as 'name@@VERSION', and versions required from another library,
+// or hidden, as 'name@VERSION'. The symbols naming each version definition
+// have no suffix.
+func nmVersions(f *elf.File, syms []elf2sql.NMSymbol) []elf2sql.NMSymbol {
+	dyn, e := f.DynamicSymbols()
+	if e != nil || len(dyn) != len(syms) {
+		return syms
+	}
+	var versym []byte
+	if sec := f.SectionByType(elf.SHT_GNU_VERSYM); sec != nil {
+		versym, _ = sec.Data()
+	}
+
+	for i := range syms {
+		v := dyn[i].Version
+		if v == "" || v == dyn[i].Name || dyn[i].Name != syms[i].Name {
+			continue
+		}
+
+		// The version table has an entry for each symbol, including the
+		// null symbol that DynamicSymbols leaves out
+		hidden := false
+		if off := 2 * (i + 1); off+2 <= len(versym) {
+			hidden = f.ByteOrder.Uint16(versym[off:])&0x8000 != 0
+		}
+		if syms[i].IsUndefined() || dyn[i].Library != "" || hidden {
+			syms[i].Version = "@" + v
+		} else {
+			syms[i].Version = "@@" + v
+		}
+	}
+	return syms
+}
+
+// printHeader writes the file name heading for the selected format.
+func (o *nmOptions) printHeader() {
+	switch o.format {
+	case "sysv":
+		if o.undefOnly {
+			fmt.Printf("\n\nUndefined symbols from %s:\n\n", o.fileFullName)
+		} else {
+			fmt.Printf("\n\nSymbols from %s:\n\n", o.fileFullName)
+		}
+		if o.valueWidth == 8 {
+			fmt.Printf("Name                  Value   Class        Type         Size     Line  Section\n\n")
+		} else {
+			fmt.Printf("Name                  Value           Class        Type         Size             Line  Section\n\n")
+		}
+	case "posix":
+		if o.fileArchive != "" && !o.fileName {
+			fmt.Printf("%s:\n", o.fileFullName)
+		} else if o.multiFile && !o.fileName {
+			fmt.Printf("%s:\n", o.fileMember)
+		}
+	case "bsd":
+		if (o.fileArchive != "" || o.multiFile) && !o.fileName {
+			fmt.Printf("\n%s:\n", o.fileMember)
+		}
+	}
+}
+
+// filter removes the symbols that weren't selected.
+func (o *nmOptions) filter(syms []elf2sql.NMSymbol) []elf2sql.NMSymbol {
+	var keep []elf2sql.NMSymbol
+	for _, s := range syms {
+		switch {
+		case o.undefOnly && !s.IsUndefined():
+		case o.externOnly && !s.IsExternal():
+		case o.noWeak && s.Binding == "weak":
+		case !o.debugSyms && s.IsDebugging():
+		case o.sizeSort && s.IsDebugging():
+		case o.definedOnly && s.IsUndefined():
+		case !o.specialSyms && s.IsSpecial(o.machine):
+		case o.sizeSort && nmSize(s) == 0:
+		default:
+			keep = append(keep, s)
+		}
+	}
+	return keep
+}
+
+// nmSize returns the size used when sorting by size, which is the alignment
+// for common symbols.
+func nmSize(s elf2sql.NMSymbol) uint64 {
+	if s.IsCommon() {
+		return s.Value
+	}
+	return s.Size
+}
+
+// sort orders the symbols by name, address or size.
+func (o *nmOptions) sort(syms []elf2sql.NMSymbol) {
+	if o.noSort {
+		return
+	}
+
+	compare := func(a, b elf2sql.NMSymbol) int {
+		return strings.Compare(a.Name, b.Name)
+	}
+	switch {
+	case o.sizeSort:
+		compare = func(a, b elf2sql.NMSymbol) int {
+			if nmSize(a) != nmSize(b) {
+				return nmCompare(nmSize(a), nmSize(b))
+			}
+			return strings.Compare(a.Name, b.Name)
+		}
+	case o.numericSort:
+		compare = func(a, b elf2sql.NMSymbol) int {
+			switch {
+			case a.IsUndefined() && !b.IsUndefined():
+				return -1
+			case !a.IsUndefined() && b.IsUndefined():
+				return 1
+			case !a.IsUndefined() && a.Value != b.Value:
+				return nmCompare(a.Value, b.Value)
+			}
+			return strings.Compare(a.Name, b.Name)
+		}
+	}
+
+	sort.SliceStable(syms, func(i, j int) bool {
+		if o.reverse {
+			return compare(syms[j], syms[i]) < 0
+		}
+		return compare(syms[i], syms[j]) < 0
+	})
+}
+
+// nmCompare compares two values, returning -1, 0 or 1.
+func nmCompare(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// name returns the name to display for a symbol, demangled if requested.
+func (o *nmOptions) name(s elf2sql.NMSymbol) string {
+	if o.demangle {
+		return demangle.Filter(s.Name) + s.Version
+	}
+	return s.Name + s.Version
+}
+
+// prefix returns the file name shown before each symbol with -A.
+func (o *nmOptions) prefix() string {
+	if !o.fileName || o.format == "just-symbols" {
+		return ""
+	}
+	switch {
+	case o.format == "posix":
+		return o.fileFullName + ": "
+	case o.fileArchive != "":
+		return fmt.Sprintf("%s:%s:", o.fileArchive, o.fileMember)
+	}
+	return o.fileMember + ":"
+}
+
+// value formats a symbol value or size in the selected radix.
+func (o *nmOptions) value(v uint64) string {
+	return fmt.Sprintf(o.valueFmt, v)
+}
+
+// printSymbol writes a single symbol in the selected format.
+func (o *nmOptions) printSymbol(s elf2sql.NMSymbol) {
+	letter := s.Letter()
+	undef := letter == 'U' || letter == 'w' || letter == 'v'
+	blank := strings.Repeat(" ", o.valueWidth)
+	size := s.Size
+	if o.sizeSort {
+		size = nmSize(s)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(o.prefix())
+	switch o.format {
+	case "just-symbols":
+		sb.WriteString(o.name(s))
+	case "posix":
+		fmt.Fprintf(&sb, "%s %c ", o.name(s), letter)
+		if undef {
+			sb.WriteString("        ")
+		} else {
+			sb.WriteString(o.value(s.Value) + " ")
+			if size != 0 {
+				sb.WriteString(o.value(size))
+			}
+		}
+	case "sysv":
+		fmt.Fprintf(&sb, "%-20s|", o.name(s))
+		if undef {
+			sb.WriteString(blank)
+		} else {
+			sb.WriteString(o.value(s.Value))
+		}
+		// Section symbols have no type or section, as in GNU nm
+		section := s.Type == "section"
+		if section {
+			fmt.Fprintf(&sb, "|   %c  |%18s|", letter, "")
+		} else {
+			fmt.Fprintf(&sb, "|   %c  |%18s|", letter, nmSymbolType(s.Type))
+		}
+		if size != 0 {
+			sb.WriteString(o.value(size))
+		} else {
+			sb.WriteString(blank)
+		}
+		sb.WriteString("|     |")
+		if !section {
+			sb.WriteString(s.SectionName())
+		}
+	default:
+		switch {
+		case undef:
+			sb.WriteString(blank)
+		case o.sizeSort && !o.printSize:
+			sb.WriteString(o.value(size))
+		default:
+			sb.WriteString(o.value(s.Value))
+			if o.printSize && size != 0 {
+				sb.WriteString(" " + o.value(size))
+			}
+		}
+		fmt.Fprintf(&sb, " %c %s", letter, o.name(s))
+	}
+	fmt.Println(sb.String())
+}
+
+// nmSymbolType returns the ELF symbol type name shown in the sysv format.
+func nmSymbolType(t string) string {
+	switch t {
+	case "none":
+		return "NOTYPE"
+	case "data":
+		return "OBJECT"
+	case "code":
+		return "FUNC"
+	case "filename":
+		return "FILE"
+	case "loos":
+		return "<OS specific>: 10"
+	case "hios":
+		return "<OS specific>: 12"
+	case "loproc":
+		return "<processor specific>: 13"
+	case "hiproc":
+		return "<processor specific>: 15"
+	}
+	return strings.ToUpper(t)
+}
+
+func init() {
+	rootCmd.AddCommand(nmCmd)
+
+	nmCmd.Flags().StringP("format", "f", "bsd", "output format (bsd, sysv, posix, just-symbols)")
+	nmCmd.Flags().BoolP("bsd", "B", false, "use the bsd format")
+	nmCmd.Flags().BoolP("portability", "P", false, "use the posix format")
+	nmCmd.Flags().BoolP("just-symbols", "j", false, "only list symbol names")
+	nmCmd.Flags().StringP("radix", "t", "x", "radix for values (x, d or o)")
+	nmCmd.Flags().BoolP("print-file-name", "A", false, "print the file name before every symbol")
+	nmCmd.Flags().BoolP("o", "o", false, "same as -A")
+	nmCmd.Flags().MarkHidden("o")
+	nmCmd.Flags().BoolP("demangle", "C", false, "demangle C++ symbol names")
+	nmCmd.Flags().BoolP("print-size", "S", false, "print the size of defined symbols")
+	nmCmd.Flags().Bool("size-sort", false, "sort symbols by size")
+	nmCmd.Flags().BoolP("numeric-sort", "n", false, "sort symbols by address")
+	nmCmd.Flags().BoolP("no-sort", "p", false, "list symbols in symbol table order")
+	nmCmd.Flags().BoolP("reverse-sort", "r", false, "reverse the sort order")
+	nmCmd.Flags().BoolP("debug-syms", "a", false, "include section and file symbols")
+	nmCmd.Flags().Bool("special-syms", false, "include mapping symbols")
+	nmCmd.Flags().BoolP("extern-only", "g", false, "only list external symbols")
+	nmCmd.Flags().BoolP("undefined-only", "u", false, "only list undefined symbols")
+	nmCmd.Flags().BoolP("defined-only", "U", false, "only list defined symbols")
+	nmCmd.Flags().BoolP("no-weak", "W", false, "ignore weak symbols")
+	nmCmd.Flags().Bool("quiet", false, "don't report files without symbols")
+	nmCmd.Flags().BoolP("dynamic", "D", false, "list the dynamic symbols instead")
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// objcopyCmd represents the objcopy command
+var objcopyCmd = &cobra.Command{
+	Use:   "objcopy filename [output]",
+	Short: "Convert an ELF file to Intel HEX, S-record or raw binary",
+	Long: `Writes the loadable contents of an ELF file in the format selected with
+-O, as 'objcopy -O' does:
+
+  ihex    Intel HEX, with extended address records as needed
+  srec    Motorola S-records, using S1, S2 or S3 records by address range
+  binary  Raw binary, from the lowest load address to the highest
+
+Sections are placed at their physical (load) addresses, as given by the
+PT_LOAD segments that contain them, so initialised data is written at its
+flash address rather than its RAM address. Sections can be selected with
+-j and excluded with -R, which both accept wildcards and can be repeated.
+With --gap-fill, the gaps between sections are filled with the given byte,
+which is always done for binary output (with 0 by default).
+
+The input can also be an Intel HEX, S-record or raw binary file, to convert
+between formats. Raw binary files are loaded at --base.
+
+The output is written to stdout when no output file is given:
+
+  elfquery objcopy zephyr.elf zephyr.hex -O ihex
+  elfquery objcopy zephyr.elf -O binary -R .ARM.exidx > zephyr.bin`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetString("output-target")
+		switch target {
+		case elf2sql.FormatIHex, elf2sql.FormatSRec, elf2sql.FormatBinary:
+		default:
+			fmt.Printf("invalid output target: %s (ihex, srec or binary)\n", target)
+			return
+		}
+
+		fill, hasFill := byte(0), cmd.Flags().Changed("gap-fill")
+		if hasFill {
+			s, _ := cmd.Flags().GetString("gap-fill")
+			v, e := strconv.ParseUint(s, 0, 8)
+			if e != nil {
+				fmt.Printf("invalid gap fill byte: %s\n", s)
+				return
+			}
+			fill = byte(v)
+		}
+
+		m, e := readMemImage(cmd, args[0])
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return
+		}
+		if hasFill {
+			m.FillGaps(fill)
+		}
+
+		var out bytes.Buffer
+		header := filepath.Base(args[0])
+		if len(args) > 1 {
+			header = args[1]
+		}
+		switch target {
+		case elf2sql.FormatIHex:
+			e = elf2sql.WriteIHex(&out, m)
+		case elf2sql.FormatSRec:
+			e = elf2sql.WriteSRec(&out, m, header)
+		default:
+			e = elf2sql.WriteBinary(&out, m, fill)
+		}
+		if e != nil {
+			fmt.Printf("unable to convert %s: %s\n", args[0], e)
+			return
+		}
+
+		if len(args) > 1 {
+			e = os.WriteFile(args[1], out.Bytes(), 0644)
+		} else {
+			_, e = io.Copy(os.Stdout, &out)
+		}
+		if e != nil {
+			fmt.Printf("unable to write output: %s\n", e)
+		}
+	},
+}
+
+// readMemImage loads the memory contents of an ELF file, selecting sections
+// with the -j and -R flags, or of an Intel HEX, S-record or raw binary file
+// loaded at --base.
+func readMemImage(cmd *cobra.Command, filename string) (*elf2sql.MemImage, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	magic := make([]byte, 4)
+	_, e = io.ReadFull(f, magic)
+	f.Close()
+
+	if e == nil && bytes.Equal(magic, []byte("\x7fELF")) {
+		img, e := elf2sql.OpenImage(filename)
+		if e != nil {
+			return nil, fmt.Errorf("unable to read ELF file: %s", e)
+		}
+		only, _ := cmd.Flags().GetStringArray("only-section")
+		remove, _ := cmd.Flags().GetStringArray("remove-section")
+		return img.MemImage(only, remove)
+	}
+
+	base, _ := cmd.Flags().GetString("base")
+	addr, e := parseAddr(base)
+	if e != nil {
+		return nil, fmt.Errorf("invalid base address: %s", base)
+	}
+	m, _, e := elf2sql.ReadMemImage(filename, addr)
+	return m, e
+}
+
+func init() {
+	rootCmd.AddCommand(objcopyCmd)
+
+	objcopyCmd.Flags().StringP("output-target", "O", "ihex", "output format (ihex, srec, binary)")
+	objcopyCmd.Flags().StringArrayP("only-section", "j", nil, "only copy the named section (wildcards allowed)")
+	objcopyCmd.Flags().StringArrayP("remove-section", "R", nil, "don't copy the named section (wildcards allowed)")
+	objcopyCmd.Flags().String("gap-fill", "0", "fill the gaps between sections with this byte")
+	objcopyCmd.Flags().String("base", "0", "load address of raw binary input, in hex")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// readCmd represents the read command
+var readCmd = &cobra.Command{
+	Use:   "read filename symbol [symbol...]",
+	Short: "Decode the initial value of global variables",
+	Long: `Reads the initial contents of one or more symbols from the ELF file
+and decodes them using the requested type (-t).
+
+Supported types are:
+
+  i8, i16, i32, i64   Signed integers
+  u8, u16, u32, u64   Unsigned integers
+  x8, x16, x32, x64   Hexadecimal integers
+  f32, f64            Floating point values
+  bool                Boolean value
+  str                 NUL-terminated string
+  bytes               Hex dump of the symbol's contents
+  auto                Decode using DWARF type information (default)
+
+Integer and float types can be followed by '[n]' to decode an n-element
+array, or by '[]' to decode as many elements as the symbol holds:
+
+  elfquery read firmware.elf gain_table -t i16[]
+
+When the ELF file contains DWARF debug information, 'auto' decodes structs,
+unions, enums and arrays using their declared type. Values in NOBITS
+sections (bss, noinit) are reported as zero.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		typ, _ := cmd.Flags().GetString("type")
+
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			return
+		}
+
+		for _, name := range args[1:] {
+			v, e := img.Value(name, typ)
+			if e != nil {
+				fmt.Printf("%s: %s\n", name, e)
+				continue
+			}
+			fmt.Printf("%s = %s\n", name, v)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(readCmd)
+
+	readCmd.Flags().StringP("type", "t", "auto", "value type (i32, u8[], str, bytes, auto, etc.)")
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// reproCmd represents the repro command
+var reproCmd = &cobra.Command{
+	Use:   "repro a.elf b.elf",
+	Short: "Explain why two builds of the same firmware differ",
+	Long: `Compares two builds of the same firmware and explains why they aren't
+identical, to help track down non-reproducible builds. It lists:
+
+  - the sections that differ, by kind (loadable, debug, build-id, symbols)
+  - the symbols that changed contents while keeping their size, changed
+    size, or were added or removed
+  - shifts in symbol addresses, and the function or object whose change in
+    size moved the symbols after it
+  - embedded paths (from __FILE__ or the DWARF build directory) and
+    timestamps (from __DATE__ and __TIME__) found in only one build
+
+Symbols that differ only in the addresses of the functions and data they
+refer to are reported as 'relocated', and symbols that only moved are
+counted in the shifts; both are listed with --all.
+
+The exit status is 1 if the loadable contents differ, so differences that
+are confined to debug information or the build ID pass:
+
+  elfquery repro build1/zephyr/zephyr.elf build2/zephyr/zephyr.elf`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+		all, _ := cmd.Flags().GetBool("all")
+
+		var imgs [2]*elf2sql.Image
+		for i, filename := range args {
+			img, e := elf2sql.OpenImage(filename)
+			if e != nil {
+				fmt.Printf("unable to read ELF file: %s\n", e)
+				return
+			}
+			imgs[i] = img
+		}
+		r := elf2sql.Repro(imgs[0], imgs[1])
+
+		var secs []elf2sql.SectionDiff
+		for _, s := range r.Sections {
+			if s.Status != "same" {
+				secs = append(secs, s)
+			}
+		}
+		var syms []elf2sql.SymbolDiff
+		for _, s := range r.Symbols {
+			if all || (s.Status != "moved" && s.Status != "relocated") {
+				syms = append(syms, s)
+			}
+		}
+
+		if df == elf2sql.DFJson {
+			b, e := json.Marshal(reproJSON(r, secs, syms))
+			check(e)
+			fmt.Println(string(b))
+		} else {
+			reproTables(r, secs, syms, df)
+		}
+
+		if !r.SameLoad {
+			os.Exit(1)
+		}
+	},
+}
+
+// reproTables prints the differences found by Repro as tables, followed by
+// the summary of causes.
+func reproTables(r *elf2sql.ReproReport, secs []elf2sql.SectionDiff, syms []elf2sql.SymbolDiff,
+	df elf2sql.DisplayFormat) {
+	if len(secs) > 0 {
+		var rows [][]interface{}
+		for _, s := range secs {
+			rows = append(rows, []interface{}{s.Name, s.Kind, s.Status,
+				fmt.Sprintf("0x%08X", s.AddrA), fmt.Sprintf("0x%08X", s.AddrB), s.SizeA, s.SizeB,
+				s.Bytes})
+		}
+		fmt.Print(elf2sql.RenderTable("Sections", []string{"Name", "Kind", "Status", "Address A",
+			"Address B", "Size A", "Size B", "Bytes"}, rows, df))
+	}
+	if len(syms) > 0 {
+		var rows [][]interface{}
+		for _, s := range syms {
+			rows = append(rows, []interface{}{s.Name, s.Section, s.Status,
+				fmt.Sprintf("0x%08X", s.AddrA), fmt.Sprintf("0x%08X", s.AddrB), s.SizeA, s.SizeB})
+		}
+		fmt.Print(elf2sql.RenderTable("Symbols", []string{"Name", "Section", "Status",
+			"Address A", "Address B", "Size A", "Size B"}, rows, df))
+	}
+	if len(r.Shifts) > 0 {
+		var rows [][]interface{}
+		for _, s := range r.Shifts {
+			rows = append(rows, []interface{}{s.Section, s.Cause, s.Delta, s.Moved})
+		}
+		fmt.Print(elf2sql.RenderTable("Shifts", []string{"Section", "Cause", "Delta", "Moved"},
+			rows, df))
+	}
+	if len(r.Strings) > 0 {
+		var rows [][]interface{}
+		for _, s := range r.Strings {
+			rows = append(rows, []interface{}{s.Kind, s.Section, s.A, s.B})
+		}
+		fmt.Print(elf2sql.RenderTable("Strings", []string{"Kind", "Section", "A", "B"}, rows, df))
+	}
+	if r.BuildIDA != r.BuildIDB {
+		fmt.Printf("Build ID: %s\n          %s\n", r.BuildIDA, r.BuildIDB)
+	}
+	for _, c := range r.Causes {
+		fmt.Printf("- %s\n", c)
+	}
+}
+
+// reproJSON returns the JSON form of the report.
+func reproJSON(r *elf2sql.ReproReport, secs []elf2sql.SectionDiff,
+	syms []elf2sql.SymbolDiff) interface{} {
+	type section struct {
+		Name   string `json:"name"`
+		Kind   string `json:"kind"`
+		Status string `json:"status"`
+		AddrA  uint64 `json:"address_a"`
+		AddrB  uint64 `json:"address_b"`
+		SizeA  uint64 `json:"size_a"`
+		SizeB  uint64 `json:"size_b"`
+		Bytes  uint64 `json:"bytes"`
+	}
+	type symbol struct {
+		Name    string `json:"name"`
+		Section string `json:"section"`
+		Status  string `json:"status"`
+		AddrA   uint64 `json:"address_a"`
+		AddrB   uint64 `json:"address_b"`
+		SizeA   uint64 `json:"size_a"`
+		SizeB   uint64 `json:"size_b"`
+	}
+	type shift struct {
+		Section string `json:"section"`
+		Cause   string `json:"cause"`
+		Delta   int64  `json:"delta"`
+		Moved   int    `json:"moved"`
+	}
+	type str struct {
+		Kind    string `json:"kind"`
+		Section string `json:"section"`
+		A       string `json:"a"`
+		B       string `json:"b"`
+	}
+	res := struct {
+		Identical bool      `json:"identical"`
+		SameLoad  bool      `json:"loadable_identical"`
+		BuildIDA  string    `json:"build_id_a"`
+		BuildIDB  string    `json:"build_id_b"`
+		Causes    []string  `json:"causes"`
+		Sections  []section `json:"sections"`
+		Symbols   []symbol  `json:"symbols"`
+		Shifts    []shift   `json:"shifts"`
+		Strings   []str     `json:"strings"`
+	}{r.Identical, r.SameLoad, r.BuildIDA, r.BuildIDB, r.Causes, []section{}, []symbol{},
+		[]shift{}, []str{}}
+
+	for _, s := range secs {
+		res.Sections = append(res.Sections, section{s.Name, s.Kind, s.Status, s.AddrA, s.AddrB,
+			s.SizeA, s.SizeB, s.Bytes})
+	}
+	for _, s := range syms {
+		res.Symbols = append(res.Symbols, symbol{s.Name, s.Section, s.Status, s.AddrA, s.AddrB,
+			s.SizeA, s.SizeB})
+	}
+	for _, s := range r.Shifts {
+		res.Shifts = append(res.Shifts, shift{s.Section, s.Cause, s.Delta, s.Moved})
+	}
+	for _, s := range r.Strings {
+		res.Strings = append(res.Strings, str{s.Kind, s.Section, s.A, s.B})
+	}
+	return res
+}
+
+func init() {
+	rootCmd.AddCommand(reproCmd)
+
+	reproCmd.Flags().Bool("all", false, "also list symbols that only moved or were relocated")
+	reproCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
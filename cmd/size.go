@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// elfObject is a single ELF object to report on, which may be a member of
+// an archive
+type elfObject struct {
+	Name    string
+	Archive string
+	File    *elf.File
+}
+
+// sizePrinter writes 'size' output in the same layout as GNU binutils
+type sizePrinter struct {
+	format string
+	radix  int
+	totals bool
+	seen   int
+	text   int
+	data   int
+	bss    int
+}
+
+// sizeCmd represents the size command
+var sizeCmd = &cobra.Command{
+	Use:   "size [filename...]",
+	Short: "List section sizes and total size, like GNU size",
+	Long: `Lists the text, data and bss sizes of each ELF file, with output that
+is identical to GNU binutils 'size', so that it can be used as a drop-in
+replacement in scripts. Static libraries list each of their members.
+
+Three formats are supported:
+
+  berkeley  One line per file, with read-only data counted as text (default)
+  gnu       One line per file, with read-only data counted as data
+  sysv      The size and address of every section in each file
+
+Sizes are shown in decimal unless another radix is selected with -o, -x or
+--radix. When no file is given, 'a.out' is used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		if sysv, _ := cmd.Flags().GetBool("sysv"); sysv {
+			format = "sysv"
+		}
+		if berkeley, _ := cmd.Flags().GetBool("berkeley"); berkeley {
+			format = "berkeley"
+		}
+		if gnu, _ := cmd.Flags().GetBool("gnu"); gnu {
+			format = "gnu"
+		}
+		switch format {
+		case "berkeley", "bsd", "b":
+			format = "berkeley"
+		case "sysv", "s", "a":
+			format = "sysv"
+		case "gnu", "g":
+			format = "gnu"
+		default:
+			fmt.Printf("invalid format: %s\n", format)
+			return
+		}
+
+		radix, _ := cmd.Flags().GetInt("radix")
+		if dec, _ := cmd.Flags().GetBool("decimal"); dec {
+			radix = 10
+		}
+		if oct, _ := cmd.Flags().GetBool("octal"); oct {
+			radix = 8
+		}
+		if hex, _ := cmd.Flags().GetBool("hex"); hex {
+			radix = 16
+		}
+		if radix != 8 && radix != 10 && radix != 16 {
+			fmt.Printf("invalid radix: %d\n", radix)
+			return
+		}
+
+		totals, _ := cmd.Flags().GetBool("totals")
+		p := &sizePrinter{format: format, radix: radix, totals: totals}
+
+		if len(args) == 0 {
+			args = []string{"a.out"}
+		}
+		failed := false
+		for _, name := range args {
+			objs, err := readObjects("size", name)
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "size: '%s': No such file\n", name)
+				failed = true
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "size: %s: %s\n", name, err)
+				failed = true
+				continue
+			}
+			for _, o := range objs {
+				p.print(o)
+			}
+		}
+		p.printTotals()
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// readObjects opens an ELF file, or every ELF member of an archive. Members
+// that aren't ELF files are reported on stderr as an error from prog.
+func readObjects(prog string, name string) ([]elfObject, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !elf2sql.IsArchive(data) {
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("file format not recognized")
+		}
+		return []elfObject{{Name: name, File: f}}, nil
+	}
+
+	members, err := elf2sql.ReadArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	var objs []elfObject
+	for _, m := range members {
+		f, err := elf.NewFile(bytes.NewReader(m.Data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: file format not recognized\n", prog, m.Name)
+			continue
+		}
+		objs = append(objs, elfObject{Name: m.Name, Archive: name, File: f})
+	}
+	return objs, nil
+}
+
+// number formats n in the selected radix, right-aligned to width. Octal and
+// hex values are prefixed with '0' and '0x'.
+func (p *sizePrinter) number(width int, n int) string {
+	var s string
+	switch p.radix {
+	case 8:
+		s = "0" + strconv.FormatUint(uint64(n), 8)
+	case 16:
+		s = "0x" + strconv.FormatUint(uint64(n), 16)
+	default:
+		s = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%*s", width, s)
+}
+
+// name returns the object's name, along with its archive if any.
+func (o elfObject) name() string {
+	if o.Archive != "" {
+		return fmt.Sprintf("%s (ex %s)", o.Name, o.Archive)
+	}
+	return o.Name
+}
+
+// print writes the sizes for a single object.
+func (p *sizePrinter) print(o elfObject) {
+	if p.format == "sysv" {
+		p.printSysV(o)
+		return
+	}
+
+	text, data, bss := 0, 0, 0
+	for _, s := range o.File.Sections {
+		_text, _data, _bss := sectionSize(s.SectionHeader, p.format == "berkeley")
+		text += _text
+		data += _data
+		bss += _bss
+	}
+	p.text += text
+	p.data += data
+	p.bss += bss
+
+	if p.seen == 0 {
+		p.printHeader()
+	}
+	p.seen++
+	p.printRow(text, data, bss, o.name())
+}
+
+// printHeader writes the column headings of the berkeley and gnu formats.
+func (p *sizePrinter) printHeader() {
+	switch {
+	case p.format == "gnu":
+		fmt.Println("      text       data        bss      total filename")
+	case p.radix == 8:
+		fmt.Println("   text\t   data\t    bss\t    oct\t    hex\tfilename")
+	default:
+		fmt.Println("   text\t   data\t    bss\t    dec\t    hex\tfilename")
+	}
+}
+
+// printRow writes a line of the berkeley or gnu format.
+func (p *sizePrinter) printRow(text, data, bss int, name string) {
+	total := text + data + bss
+	if p.format == "gnu" {
+		fmt.Printf("%s %s %s %s %s\n", p.number(10, text), p.number(10, data),
+			p.number(10, bss), p.number(10, total), name)
+		return
+	}
+
+	// The total is shown in both decimal (or octal) and hex, without prefix
+	dec := strconv.Itoa(total)
+	if p.radix == 8 {
+		dec = strconv.FormatUint(uint64(total), 8)
+	}
+	fmt.Printf("%s\t%s\t%s\t%7s\t%7x\t%s\n", p.number(7, text), p.number(7, data),
+		p.number(7, bss), dec, total, name)
+}
+
+// printTotals writes the '(TOTALS)' line, when enabled. It isn't available
+// in the sysv format.
+func (p *sizePrinter) printTotals() {
+	if p.totals && p.format != "sysv" && p.seen > 0 {
+		p.printRow(p.text, p.data, p.bss, "(TOTALS)")
+	}
+}
+
+// sysvSection indicates if a section is listed in the sysv format. Like GNU
+// size, the symbol and string tables and relocations aren't included.
+func sysvSection(s *elf.Section) bool {
+	switch s.Type {
+	case elf.SHT_NULL, elf.SHT_SYMTAB, elf.SHT_SYMTAB_SHNDX:
+		return false
+	case elf.SHT_STRTAB, elf.SHT_REL, elf.SHT_RELA:
+		return s.Flags&elf.SHF_ALLOC != 0
+	case elf.SHT_NOBITS:
+		return s.Flags != 0
+	}
+	return true
+}
+
+// printSysV writes the size and address of every section in an object.
+func (p *sizePrinter) printSysV(o elfObject) {
+	var sections []*elf.Section
+	nameLen, total, maxAddr := len("section"), 0, uint64(0)
+	for _, s := range o.File.Sections {
+		if !sysvSection(s) {
+			continue
+		}
+		sections = append(sections, s)
+		if len(s.Name) > nameLen {
+			nameLen = len(s.Name)
+		}
+		total += int(s.Size)
+		if s.Addr > maxAddr {
+			maxAddr = s.Addr
+		}
+	}
+	sizeLen := len(p.number(0, total))
+	if sizeLen < len("size") {
+		sizeLen = len("size")
+	}
+	addrLen := len(p.number(0, int(maxAddr)))
+	if addrLen < len("addr") {
+		addrLen = len("addr")
+	}
+
+	fmt.Printf("%s  ", o.Name)
+	if o.Archive != "" {
+		fmt.Printf(" (ex %s)", o.Archive)
+	}
+	fmt.Printf(":\n%-*s   %*s   %*s\n", nameLen, "section", sizeLen, "size", addrLen, "addr")
+	for _, s := range sections {
+		fmt.Printf("%-*s   %s   %s\n", nameLen, s.Name, p.number(sizeLen, int(s.Size)),
+			p.number(addrLen, int(s.Addr)))
+	}
+	fmt.Printf("%-*s   %s\n\n\n", nameLen, "Total", p.number(sizeLen, total))
+}
+
+func init() {
+	rootCmd.AddCommand(sizeCmd)
+
+	sizeCmd.Flags().String("format", "berkeley", "output format (berkeley, sysv, gnu)")
+	sizeCmd.Flags().BoolP("sysv", "A", false, "use the sysv format")
+	sizeCmd.Flags().BoolP("berkeley", "B", false, "use the berkeley format")
+	sizeCmd.Flags().BoolP("gnu", "G", false, "use the gnu format")
+	sizeCmd.Flags().Int("radix", 10, "radix for sizes (8, 10 or 16)")
+	sizeCmd.Flags().BoolP("decimal", "d", false, "show sizes in decimal")
+	sizeCmd.Flags().BoolP("octal", "o", false, "show sizes in octal")
+	sizeCmd.Flags().BoolP("hex", "x", false, "show sizes in hex")
+	sizeCmd.Flags().BoolP("totals", "t", false, "show the total of all files (berkeley and gnu)")
+}
@@ -8,17 +8,64 @@ import (
 	"github.com/spf13/viper"
 )
 
+// outputFormats maps the '-o' flag values to their display formats
+var outputFormats = map[string]elf2sql.DisplayFormat{
+	"text":   elf2sql.DFText,
+	"pretty": elf2sql.DFPretty,
+	"color":  elf2sql.DFPrettyCol,
+	"csv":    elf2sql.DFCSV,
+	"md":     elf2sql.DFMarkdown,
+	"html":   elf2sql.DFHtml,
+	"json":   elf2sql.DFJson,
+}
+
+// loadOptions sets the elf2sql options shared by the 'sql' and 'http'
+// commands, and returns the file to load. With --build-dir, the ELF file is
+// found in the Zephyr build directory and the argument is optional.
+func loadOptions(cmd *cobra.Command, args []string) (string, error) {
+	elf2sql.StackUsageDir, _ = cmd.Flags().GetString("stack-usage")
+	elf2sql.Zephyr, _ = cmd.Flags().GetBool("zephyr")
+	elf2sql.SVDFile, _ = cmd.Flags().GetString("svd")
+	elf2sql.MCUbootFile, _ = cmd.Flags().GetString("mcuboot")
+
+	dir, _ := cmd.Flags().GetString("build-dir")
+	if dir == "" {
+		if len(args) == 0 {
+			return "", fmt.Errorf("requires a filename or --build-dir")
+		}
+		return args[0], nil
+	}
+	b, e := elf2sql.FindZephyrBuild(dir)
+	if e != nil {
+		return "", e
+	}
+	filename := b.Load()
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	if cmd.Flags().Changed("mcuboot") {
+		elf2sql.MCUbootFile, _ = cmd.Flags().GetString("mcuboot")
+	}
+	return filename, nil
+}
+
 // sqlCmd represents the sql command
 var sqlCmd = &cobra.Command{
-	Use:   "sql filename",
+	Use:   "sql [filename]",
 	Short: "Run SQL queries against the ELF file",
 	Long: `Reads all symbolic information from the ELF file and adds it to an
 in-memory SQLite database, which can be queried in the REPL or via a SQL
 query string (-q).
 
-Two tables are available in the SQLite database:
+Static libraries (.a) and relocatable objects (.o) can also be loaded. For a
+library, every ELF object it contains is loaded, and the 'Member' column that
+ends each table holds the object's name (it is NULL for other files). The
+'member_sizes' and 'duplicates' aliases compare code size per object and list
+global symbols defined by more than one object.
 
-  symbols
+Eight tables are available in the SQLite database:
+
+  symbols (from .symtab, or from .dynsym if the file was stripped)
 
   ID            Integer  Internal autoincrementing counter for symbols
   Value         Integer  Value associated with the symbol
@@ -29,13 +76,14 @@ Two tables are available in the SQLite database:
   SectionIndex  Integer  Section index
   Name          Text     Symbol name
   Section       Text     Section name
+  Member        Text     Archive member name
 
   sections
 
   ID            Integer   Numeric ID to distinction sections
   Name          Text      Section name
-  Type          Text      Section type
-  Flags         Text      Section attribute flags
+  Type          Text      Section type (PROGBITS, NOBITS, ARM_EXIDX, etc.)
+  Flags         Text      Section flags as letters, like readelf (AX, WA, etc.)
   Address       Integer   Address of the first byte of this section
   Offset        Integer   Offset from the start of file
   Size          Integer   Section size in bytes
@@ -43,6 +91,138 @@ Two tables are available in the SQLite database:
   Info          Integer   Extra information (usage varies)
   Alignment     Integer   Address alignment constraints
   EntrySize     Integer   Size in bytes of each fixed-size entry
+  IsAlloc       Integer   1 if the section occupies memory at run time
+  IsExec        Integer   1 if the section contains executable code
+  IsWrite       Integer   1 if the section is writable at run time
+  CRC32         Integer   CRC32 of the section's contents (NULL for NOBITS)
+  SHA256        Text      SHA-256 of the section's contents, in hex
+  Member        Text      Archive member name
+
+  strings
+
+  ID            Integer   Internal autoincrementing counter for strings
+  Address       Integer   Address of the first character
+  Section       Text      Section name
+  Symbol        Text      Name of the symbol containing the string, if any
+  Length        Integer   Number of characters, excluding any NUL terminator
+  Value         Text      String contents
+  Member        Text      Archive member name
+
+  calls
+
+  ID            Integer   Internal autoincrementing counter for calls
+  Caller        Text      Calling function
+  Callee        Text      Called function (NULL for indirect calls)
+  Address       Integer   Address of the call instruction
+  Indirect      Integer   1 for calls via a register or memory
+  Member        Text      Archive member name
+
+  layout_gaps (see 'elfquery gaps')
+
+  ID            Integer   Internal autoincrementing counter for entries
+  Section       Text      Section name
+  Kind          Text      padding, gap, overlap or alias
+  Address       Integer   Address of the first byte
+  Size          Integer   Size in bytes
+  Before        Text      Symbol before the entry (NULL at the section start)
+  After         Text      Symbol after the entry (NULL at the section end)
+  Member        Text      Archive member name
+
+  code_hashes (see 'elfquery dupes')
+
+  ID            Integer   Internal autoincrementing counter for functions
+  Name          Text      Function name (the first, if several share the code)
+  Address       Integer   Address of the first byte
+  Size          Integer   Size in bytes
+  Section       Text      Section name
+  Hash          Text      SHA-256 of the code, with references by symbol name
+  Member        Text      Archive member name
+
+  stack_usage (requires --stack-usage)
+
+  ID            Integer   Internal autoincrementing counter for entries
+  File          Text      Source file, as recorded in the .su file
+  Line          Integer   Line number of the function definition
+  Function      Text      Function name
+  Bytes         Integer   Stack frame size in bytes
+  Qualifier     Text      static, dynamic or dynamic,bounded
+  Value         Integer   Value of the matching symbol (NULL if not found)
+
+  attributes
+
+  ID            Integer   Internal autoincrementing counter for attributes
+  Section       Text      e_flags, .ARM.attributes or .riscv.attributes
+  Vendor        Text      Attribute vendor (aeabi, riscv), NULL for e_flags
+  Tag           Integer   Attribute tag number, NULL for e_flags
+  Name          Text      Attribute name, such as Tag_CPU_arch
+  Value         Text      Decoded value, as shown by readelf
+  Raw           Integer   Numeric value (NULL for string attributes)
+  Member        Text      Archive member name
+
+With --zephyr, the devices, init entries, thread stacks, kernel objects,
+shell commands and log sources of a Zephyr image are decoded from the
+kernel's iterable sections, using the debug information when available:
+
+  zephyr_init     Level, Sequence, Priority, Kind (device or SYS_INIT),
+                  Function, Device, Symbol, Address
+  zephyr_devices  Name, Symbol, Address, Level, Init, Config, API, Data
+  zephyr_stacks   Name, Address, Size, Count, StackSize, Section, Thread
+  zephyr_objects  Name, Type (k_sem, k_thread, etc.), Address, Size, Count,
+                  Section
+  zephyr_shell    Command, Help, Handler, Dynamic, Address
+  zephyr_log      Name, Level (none, err, wrn, inf, dbg), Symbol, Address
+
+The init entries are listed in boot order ('zephyr_boot' alias). Priorities
+aren't stored in a linked image, so they're NULL unless a map file is loaded,
+but within each level the entries are sorted by priority.
+
+With --build-dir, the filename can be omitted and 'zephyr/zephyr.elf' is
+loaded from a Zephyr build directory, along with --zephyr and the following
+files when present:
+
+  zephyr.map                    map_memory (Name, Origin, Length,
+                                Attributes) and map_inputs (OutputSection,
+                                Section, Address, Size, Archive, Object,
+                                Discarded), with Archive set for objects
+                                taken from a static library
+  .config                       kconfig (Name, Value, Type, Enabled), where
+                                'is not set' symbols have the value 'n'
+  zephyr.dts                    devicetree (Path, Name, Labels, Compatible,
+                                Status, Address, Size, Ordinal) and
+                                devicetree_props (Path, Name, Value), with
+                                the 'reg' address translated by 'ranges'
+  devicetree_generated.h        node ordinals, as used in device symbols
+  zephyr.signed.bin             the MCUboot tables below
+
+With --svd, the peripherals and registers of a CMSIS-SVD device description
+are loaded, with register arrays and clusters expanded, and the image is
+searched for references to them:
+
+  peripherals      Name, GroupName, Description, Address, Size, DerivedFrom
+  registers        Peripheral, Name, Description, Address, Offset, Size (in
+                   bits), Access, ResetValue
+  peripheral_refs  Symbol, Kind, Address, Target, Peripheral, Register
+
+A 'code' reference is an address loaded from a function's literal pool (as
+used on ARM), and a 'data' reference is a pointer in an initialised object,
+such as the base address in a driver's config. Addresses computed at run
+time aren't found. The 'peripheral_users' alias lists the functions and
+objects using each peripheral.
+
+With --mcuboot, the header and TLVs of a signed MCUboot image (binary, Intel
+HEX or S-record) are loaded, as shown by 'elfquery mcuboot':
+
+  mcuboot       Magic, LoadAddress, HeaderSize, ImageSize, ProtectedTLVSize,
+                Flags, Version, HashType, HashValid
+  mcuboot_tlvs  Area (protected, unprotected), Type, Name, Offset, Length,
+                Value
+
+The following custom SQL functions are also available:
+
+  value(symbol [, type])  Initial value of a symbol (see 'elfquery read')
+  sym_at(addr)            Symbol containing an address, as 'symbol+0xoffset'
+  peripheral_at(addr)     Peripheral register at an address, as 'UART0.CTRL'
+                          or 'UART0+0xoffset' (requires --svd)
 
 To list all sections in the ELF file ('sections' alias):
 
@@ -63,28 +243,51 @@ To do the same query but restrict it to the 10 largest symbols ('bss10' alias):
 To show 'Weak' symbols implemented in the ELF file ('weak' alias):
 
   SELECT * FROM symbols WHERE Binding LIKE 'weak'
+
+To list functions with identical code ('code_dupes' alias):
+
+  SELECT Hash, Size, COUNT(*) AS Count, group_concat(Name, ', ') AS Functions
+  FROM code_hashes GROUP BY Hash HAVING COUNT(DISTINCT Name) > 1
+  ORDER BY Size * (COUNT(*) - 1) DESC
+
+To list the largest stack frames with their code size ('stack_top' alias):
+
+  SELECT s.Function, s.Bytes, s.Qualifier, y.Size, s.File FROM stack_usage s
+  LEFT JOIN symbols y ON y.Name = s.Function AND y.Value = s.Value
+  ORDER BY s.Bytes DESC LIMIT 20
+
+To find the RAM used by each enabled driver in a Zephyr build ('driver_ram'
+alias, with 'memory_usage' and 'archive_sizes' also using the map):
+
+  SELECT k.Name AS Config, SUM(i.Size) AS RAM FROM map_inputs i
+  JOIN map_memory m ON m.Attributes LIKE '%w%'
+    AND i.Address >= m.Origin AND i.Address < m.Origin + m.Length
+  JOIN kconfig k ON k.Enabled AND k.Name = 'CONFIG_' ||
+    upper(replace(substr(i.Archive, instr(i.Archive, 'libdrivers__') + 12), '.a', ''))
+  WHERE NOT i.Discarded AND instr(i.Archive, 'libdrivers__') > 0
+  GROUP BY k.Name ORDER BY RAM DESC
+
+To decode the initial value of every object in the 'rodata' section:
+
+  SELECT Name, value(Name) FROM symbols WHERE Section = 'rodata' AND Type = 'data'
 `,
-	Args: cobra.MinimumNArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// Check display format
 		output, _ := cmd.Flags().GetString("output")
-		outputDict := map[string]elf2sql.DisplayFormat{
-			"text":   elf2sql.DFText,
-			"pretty": elf2sql.DFPretty,
-			"color":  elf2sql.DFPrettyCol,
-			"csv":    elf2sql.DFCSV,
-			"md":     elf2sql.DFMarkdown,
-			"html":   elf2sql.DFHtml,
-			"json":   elf2sql.DFJson,
-		}
-		df, ok := outputDict[output]
+		df, ok := outputFormats[output]
 		if !ok {
 			fmt.Printf("invalid output flag: %s\n", output)
 			return
 		}
 
 		// Populate the database with the ELF data
-		e := elf2sql.InitDB(args[0])
+		filename, e := loadOptions(cmd, args)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return
+		}
+		e = elf2sql.InitDB(filename)
 		defer elf2sql.CloseDB()
 		if e != nil {
 			fmt.Printf("unable to initialise the SQLite3 database in memory\n")
@@ -116,7 +319,7 @@ To show 'Weak' symbols implemented in the ELF file ('weak' alias):
 				fmt.Printf("invalid query: %s\n", query)
 				return
 			}
-			fmt.Printf(s)
+			fmt.Print(s)
 			return
 		}
 
@@ -133,7 +336,7 @@ To show 'Weak' symbols implemented in the ELF file ('weak' alias):
 			fmt.Printf("invalid query: %s\n", query)
 			return
 		}
-		fmt.Printf(s)
+		fmt.Print(s)
 	},
 }
 
@@ -142,5 +345,10 @@ func init() {
 
 	sqlCmd.Flags().StringP("query", "q", "", "SQL query to execute")
 	sqlCmd.Flags().StringP("alias", "a", "", "SQL alias to execute (see .elfquery.toml)")
+	sqlCmd.Flags().StringP("stack-usage", "u", "", "directory of GCC .su files for the 'stack_usage' table")
+	sqlCmd.Flags().BoolP("zephyr", "z", false, "decode Zephyr RTOS devices, init entries, stacks and more into the 'zephyr_*' tables")
+	sqlCmd.Flags().StringP("build-dir", "b", "", "Zephyr build directory to load the ELF, map, .config and devicetree from")
+	sqlCmd.Flags().String("svd", "", "CMSIS-SVD file for the 'peripherals', 'registers' and 'peripheral_refs' tables")
+	sqlCmd.Flags().String("mcuboot", "", "signed MCUboot image for the 'mcuboot' and 'mcuboot_tlvs' tables")
 	sqlCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
 }
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// stringsCmd represents the strings command
+var stringsCmd = &cobra.Command{
+	Use:   "strings filename",
+	Short: "List printable strings with their section and symbol",
+	Long: `Scans the allocated, non-executable sections of the ELF file for
+printable strings, listing the address, section, owning symbol and length of
+each one. Strings that aren't part of a named symbol, such as anonymous
+string literals, have an empty symbol name.
+
+The same data is available in the 'strings' table of 'elfquery sql'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		elf2sql.MinStringLength, _ = cmd.Flags().GetInt("min-len")
+
+		// Populate the database with the ELF data
+		e := elf2sql.InitDB(args[0])
+		defer elf2sql.CloseDB()
+		if e != nil {
+			fmt.Printf("unable to initialise the SQLite3 database in memory\n")
+			return
+		}
+
+		order := "Address ASC"
+		if bySize, _ := cmd.Flags().GetBool("size-sort"); bySize {
+			order = "Length DESC"
+		}
+		where := ""
+		if sec, _ := cmd.Flags().GetString("section"); sec != "" {
+			where = fmt.Sprintf("WHERE Section = '%s'", strings.ReplaceAll(sec, "'", "''"))
+		}
+
+		query := fmt.Sprintf(`SELECT printf('0x%%X', Address) AS Address, Section,
+			ifnull(Symbol, '') AS Symbol, Length,
+			replace(replace(Value, char(13), '\r'), char(10), '\n') AS Value
+			FROM strings %s ORDER BY %s`, where, order)
+		s, e := elf2sql.RunQuery(query, df)
+		if e != nil {
+			fmt.Printf("invalid query: %s\n", query)
+			return
+		}
+		fmt.Print(s)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stringsCmd)
+
+	stringsCmd.Flags().IntP("min-len", "n", 4, "minimum string length")
+	stringsCmd.Flags().StringP("section", "s", "", "only list strings in the named section")
+	stringsCmd.Flags().BoolP("size-sort", "S", false, "sort by length, largest first")
+	stringsCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
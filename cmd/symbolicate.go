@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// hexAddr matches '0x' prefixed hex values, and bare 8 or 16 digit hex words
+// as printed in fault register dumps
+var hexAddr = regexp.MustCompile(`\b(0[xX][0-9a-fA-F]{1,16}|[0-9a-fA-F]{8}|[0-9a-fA-F]{16})\b`)
+
+// symbolicateMatch is an address found in the input, for JSON output
+type symbolicateMatch struct {
+	LogLine   int    `json:"log_line"`
+	LogColumn int    `json:"log_column"`
+	Text      string `json:"text"`
+	elf2sql.AddrInfo
+}
+
+// symbolicateCmd represents the symbolicate command
+var symbolicateCmd = &cobra.Command{
+	Use:   "symbolicate filename [logfile]",
+	Short: "Annotate addresses in crash logs with symbols and source lines",
+	Long: `Scans free text, such as Zephyr fatal error dumps, Cortex-M fault
+register dumps or backtraces, for hex addresses, and rewrites each one that
+falls inside a symbol as 'address <symbol+0xoffset at file:line>'. Other
+values, such as register contents that aren't addresses, are left unchanged.
+
+The text is read from logfile, or from stdin if none is given:
+
+  elfquery symbolicate zephyr.elf < crash.log
+
+With '-o json', a list of the addresses found is written instead, giving the
+log line and column of each one along with its symbol, section and source
+line.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "" && output != "json" {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+		basenames, _ := cmd.Flags().GetBool("basenames")
+
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			return
+		}
+
+		var in io.Reader = os.Stdin
+		if len(args) > 1 {
+			f, e := os.Open(args[1])
+			if e != nil {
+				fmt.Printf("unable to read log file: %s\n", e)
+				return
+			}
+			defer f.Close()
+			in = f
+		}
+
+		matches := []symbolicateMatch{}
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(nil, 1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			found := symbolicateLine(img, line, basenames)
+			if output == "" {
+				fmt.Println(annotateLine(line, found))
+				continue
+			}
+			for i := range found {
+				found[i].LogLine = n
+				matches = append(matches, found[i])
+			}
+		}
+
+		if output == "json" {
+			b, e := json.Marshal(matches)
+			check(e)
+			fmt.Println(string(b))
+		}
+	},
+}
+
+// symbolicateLine returns the addresses in a line of text that are inside a
+// symbol in an allocated section.
+func symbolicateLine(img *elf2sql.Image, line string, basenames bool) []symbolicateMatch {
+	var found []symbolicateMatch
+	for _, loc := range hexAddr.FindAllStringIndex(line, -1) {
+		text := line[loc[0]:loc[1]]
+		addr, e := parseAddr(text)
+		if e != nil {
+			continue
+		}
+		info := img.Addr(addr)
+		if info.Symbol == "" || info.Section == "" {
+			continue
+		}
+		if basenames && info.File != "" {
+			info.File = filepath.Base(info.File)
+		}
+		found = append(found, symbolicateMatch{LogColumn: loc[0] + 1, Text: text, AddrInfo: info})
+	}
+	return found
+}
+
+// annotateLine inserts the symbol and source line after each address found.
+func annotateLine(line string, found []symbolicateMatch) string {
+	out := ""
+	last := 0
+	for _, m := range found {
+		end := m.LogColumn - 1 + len(m.Text)
+		out += line[last:end]
+		note := m.Location()
+		if src := m.Source(); src != "" {
+			note += " at " + src
+		}
+		out += fmt.Sprintf(" <%s>", note)
+		last = end
+	}
+	return out + line[last:]
+}
+
+func init() {
+	rootCmd.AddCommand(symbolicateCmd)
+
+	symbolicateCmd.Flags().StringP("output", "o", "", "output format (json)")
+	symbolicateCmd.Flags().BoolP("basenames", "s", false, "strip directory names from source files")
+}
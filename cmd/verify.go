@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+	"github.com/spf13/cobra"
+)
+
+// verifyResult is the JSON form of the 'verify' report
+type verifyResult struct {
+	Match      bool             `json:"match"`
+	Bytes      uint64           `json:"bytes"`
+	Mismatches []verifyMismatch `json:"mismatches"`
+}
+
+// verifyMismatch is the JSON form of a mismatched range
+type verifyMismatch struct {
+	Address  uint64   `json:"address"`
+	Size     uint64   `json:"size"`
+	Kind     string   `json:"kind"`
+	Section  string   `json:"section"`
+	Symbols  []string `json:"symbols"`
+	Expected string   `json:"expected"`
+	Actual   string   `json:"actual"`
+}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify filename image",
+	Short: "Compare a HEX, S-record or binary image with the ELF file",
+	Long: `Compares the loadable contents of the ELF file, at their load addresses,
+with a programmed image or a flash readback dump, and lists every range of
+bytes that differs or is missing from the image, along with the symbols that
+own them.
+
+The image can be an Intel HEX, S-record or raw binary file. A binary file is
+loaded at --base, which defaults to the lowest load address of the ELF
+file. Data in the image beyond the ELF file's contents, such as erased flash
+in a readback, is ignored. Sections can be selected with -j and excluded
+with -R, as for 'elfquery objcopy'.
+
+The exit status is 1 if the image doesn't match:
+
+  elfquery verify zephyr.elf readback.bin --base 0x10000000`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		df, ok := outputFormats[output]
+		if !ok {
+			fmt.Printf("invalid output flag: %s\n", output)
+			return
+		}
+
+		img, e := elf2sql.OpenImage(args[0])
+		if e != nil {
+			fmt.Printf("unable to read ELF file: %s\n", e)
+			os.Exit(1)
+		}
+		only, _ := cmd.Flags().GetStringArray("only-section")
+		remove, _ := cmd.Flags().GetStringArray("remove-section")
+		expected, e := img.MemImage(only, remove)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+
+		base := expected.Start()
+		if cmd.Flags().Changed("base") {
+			s, _ := cmd.Flags().GetString("base")
+			base, e = parseAddr(s)
+			if e != nil {
+				fmt.Printf("invalid base address: %s\n", s)
+				os.Exit(1)
+			}
+		}
+		actual, _, e := elf2sql.ReadMemImage(args[1], base)
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			os.Exit(1)
+		}
+
+		mismatches := img.Verify(expected, actual)
+		var bad uint64
+		for _, m := range mismatches {
+			bad += m.Size
+		}
+
+		switch {
+		case df == elf2sql.DFJson:
+			res := verifyResult{Match: len(mismatches) == 0, Bytes: expected.Size(),
+				Mismatches: []verifyMismatch{}}
+			for _, m := range mismatches {
+				res.Mismatches = append(res.Mismatches, verifyMismatch{m.Address, m.Size,
+					m.Kind, m.Section, m.Symbols, hex.EncodeToString(m.Expected),
+					hex.EncodeToString(m.Actual)})
+			}
+			b, e := json.Marshal(res)
+			check(e)
+			fmt.Println(string(b))
+		case len(mismatches) == 0:
+			fmt.Printf("OK: %d bytes in %d sections match %s\n", expected.Size(),
+				len(expected.Blocks), args[1])
+		default:
+			var rows [][]interface{}
+			for _, m := range mismatches {
+				rows = append(rows, []interface{}{fmt.Sprintf("0x%08X", m.Address), m.Size,
+					m.Kind, m.Section, verifySymbols(m.Symbols), verifyBytes(m.Expected),
+					verifyBytes(m.Actual)})
+			}
+			fmt.Print(elf2sql.RenderTable("", []string{"Address", "Size", "Kind", "Section",
+				"Symbols", "Expected", "Actual"}, rows, df))
+			fmt.Printf("FAILED: %d of %d bytes in %d ranges don't match %s\n", bad,
+				expected.Size(), len(mismatches), args[1])
+		}
+
+		if len(mismatches) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// verifySymbols lists the first few symbols of a mismatched range.
+func verifySymbols(syms []string) string {
+	if len(syms) > 3 {
+		return fmt.Sprintf("%s (+%d more)", strings.Join(syms[:3], ", "), len(syms)-3)
+	}
+	return strings.Join(syms, ", ")
+}
+
+// verifyBytes shows the first bytes of a mismatched range in hex.
+func verifyBytes(b []byte) string {
+	if len(b) > 8 {
+		return hex.EncodeToString(b[:8]) + "..."
+	}
+	return hex.EncodeToString(b)
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().String("base", "", "load address of a raw binary image, in hex")
+	verifyCmd.Flags().StringArrayP("only-section", "j", nil, "only verify the named section (wildcards allowed)")
+	verifyCmd.Flags().StringArrayP("remove-section", "R", nil, "don't verify the named section (wildcards allowed)")
+	verifyCmd.Flags().StringP("output", "o", "text", "output format (text, pretty, color, csv, md, html, json)")
+}
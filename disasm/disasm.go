@@ -0,0 +1,386 @@
+package disasm // github.com/microbuilder/elfquery/disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/arch/arm/armasm"
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/riscv64/riscv64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Arch identifies the instruction set to decode
+type Arch uint8
+
+// Supported instruction sets
+const (
+	ArchThumb   Arch = 0 // ARM Thumb/Thumb-2 (Cortex-M)
+	ArchARM          = 1 // 32-bit ARM (A32)
+	ArchARM64        = 2 // 64-bit ARM (A64)
+	ArchX86          = 3 // 32-bit x86
+	ArchX86_64       = 4 // 64-bit x86
+	ArchRISCV        = 5 // 64-bit RISC-V (RV64 with compressed instructions)
+	ArchRISCV32      = 6 // 32-bit RISC-V (RV32 with compressed instructions)
+	ArchUnknown      = 255
+)
+
+// String map for Arch values
+var archStrings = map[Arch]string{
+	ArchThumb:   "thumb",
+	ArchARM:     "arm",
+	ArchARM64:   "aarch64",
+	ArchX86:     "x86",
+	ArchX86_64:  "x86_64",
+	ArchRISCV:   "riscv64",
+	ArchRISCV32: "riscv32",
+	ArchUnknown: "unknown",
+}
+
+func (a Arch) String() string {
+	return archStrings[a]
+}
+
+// Flow describes how an instruction affects the flow of execution
+type Flow uint8
+
+// Control flow types
+const (
+	FlowNone       Flow = 0 // Continues with the next instruction
+	FlowBranch          = 1 // Unconditional branch
+	FlowCondBranch      = 2 // Conditional branch
+	FlowCall            = 3 // Function call
+	FlowReturn          = 4 // Return from function
+)
+
+// Inst is a single decoded instruction
+type Inst struct {
+	Addr       uint64 // Address of the instruction
+	Bytes      []byte // Raw instruction encoding
+	Text       string // Mnemonic and operands in GNU syntax
+	Flow       Flow   // Control flow type
+	Target     uint64 // Branch or call target, if HasTarget is set
+	HasTarget  bool   // Set for direct branches and calls
+	Indirect   bool   // Set for branches and calls via a register or memory
+	Literal    uint64 // Address of PC-relative data, if HasLiteral is set
+	HasLiteral bool   // Set when the instruction references PC-relative data
+}
+
+// Decoder decodes a stream of instructions for a single architecture. A
+// decoder keeps state between calls (such as Thumb IT blocks), so a new
+// decoder should be used for each independent block of code.
+type Decoder struct {
+	arch  Arch
+	order binary.ByteOrder
+	thumb thumbDecoder
+}
+
+// NewDecoder returns a decoder for the specified architecture.
+func NewDecoder(arch Arch, order binary.ByteOrder) *Decoder {
+	return &Decoder{arch: arch, order: order}
+}
+
+// Decode decodes the instruction at the start of code, located at addr.
+// Undecodable bytes are returned as a data directive so that decoding can
+// continue with the following bytes.
+func (d *Decoder) Decode(code []byte, addr uint64) Inst {
+	var inst Inst
+	var size int
+
+	switch d.arch {
+	case ArchThumb:
+		inst, size = d.thumb.decode(code, addr, d.order)
+	case ArchARM:
+		inst, size = decodeARM(code, addr)
+	case ArchARM64:
+		inst, size = decodeARM64(code, addr)
+	case ArchX86:
+		inst, size = decodeX86(code, addr, 32)
+	case ArchX86_64:
+		inst, size = decodeX86(code, addr, 64)
+	case ArchRISCV:
+		inst, size = decodeRISCV(code, addr, 64)
+	case ArchRISCV32:
+		inst, size = decodeRISCV(code, addr, 32)
+	default:
+		inst, size = Inst{Addr: addr, Text: fmt.Sprintf(".byte 0x%02x", code[0])}, 1
+	}
+
+	inst.Bytes = code[:size]
+	return inst
+}
+
+// Disassemble decodes all of the instructions in code, which is located at
+// addr.
+func Disassemble(arch Arch, order binary.ByteOrder, code []byte, addr uint64) []Inst {
+	d := NewDecoder(arch, order)
+
+	var insts []Inst
+	for off := 0; off < len(code); {
+		inst := d.Decode(code[off:], addr+uint64(off))
+		insts = append(insts, inst)
+		off += len(inst.Bytes)
+	}
+
+	return insts
+}
+
+// Data returns a data directive covering the start of code, used for
+// literal pools and other data embedded in code sections.
+func Data(code []byte, addr uint64, order binary.ByteOrder) Inst {
+	inst := Inst{Addr: addr}
+	switch {
+	case len(code) >= 4 && addr%4 == 0:
+		inst.Text = fmt.Sprintf(".word 0x%08x", order.Uint32(code))
+		inst.Bytes = code[:4]
+	case len(code) >= 2 && addr%2 == 0:
+		inst.Text = fmt.Sprintf(".short 0x%04x", order.Uint16(code))
+		inst.Bytes = code[:2]
+	default:
+		inst.Text = fmt.Sprintf(".byte 0x%02x", code[0])
+		inst.Bytes = code[:1]
+	}
+	return inst
+}
+
+// decodeARM decodes a 32-bit ARM (A32) instruction.
+func decodeARM(code []byte, addr uint64) (Inst, int) {
+	inst := Inst{Addr: addr}
+	a, e := armasm.Decode(code, armasm.ModeARM)
+	if e != nil {
+		data := Data(code, addr, binary.LittleEndian)
+		return data, len(data.Bytes)
+	}
+
+	inst.Text = armasm.GNUSyntax(a)
+	op := strings.ToLower(a.Op.String())
+	for _, arg := range a.Args {
+		if rel, ok := arg.(armasm.PCRel); ok {
+			inst.Target = uint64(int64(addr) + 8 + int64(rel))
+			inst.HasTarget = true
+			inst.Text = replaceLast(inst.Text, fmt.Sprintf(".%+#x", int32(rel)+4), fmt.Sprintf("0x%x", inst.Target))
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(op, "bl"):
+		inst.Flow = FlowCall
+		inst.Indirect = !inst.HasTarget
+	case strings.HasPrefix(op, "bx"):
+		inst.Flow = FlowBranch
+		inst.Indirect = true
+		if a.Args[0] == armasm.LR {
+			inst.Flow = FlowReturn
+			inst.Indirect = false
+		}
+	case op == "b":
+		inst.Flow = FlowBranch
+	case strings.HasPrefix(op, "b.") || (strings.HasPrefix(op, "b") && inst.HasTarget):
+		inst.Flow = FlowCondBranch
+	}
+
+	return inst, a.Len
+}
+
+// decodeARM64 decodes a 64-bit ARM (A64) instruction.
+func decodeARM64(code []byte, addr uint64) (Inst, int) {
+	inst := Inst{Addr: addr}
+	a, e := arm64asm.Decode(code)
+	if e != nil {
+		data := Data(code, addr, binary.LittleEndian)
+		return data, len(data.Bytes)
+	}
+
+	inst.Text = arm64asm.GNUSyntax(a)
+	op := a.Op.String()
+	for _, arg := range a.Args {
+		rel, ok := arg.(arm64asm.PCRel)
+		if !ok {
+			continue
+		}
+		target := addr + uint64(rel)
+		if op == "ADRP" {
+			target = addr&^0xFFF + uint64(rel)
+		}
+		inst.Text = replaceLast(inst.Text, rel.String(), fmt.Sprintf("0x%x", target))
+		if op == "ADR" || op == "ADRP" || strings.HasPrefix(op, "LDR") {
+			inst.Literal = target
+			inst.HasLiteral = true
+		} else {
+			inst.Target = target
+			inst.HasTarget = true
+		}
+	}
+
+	switch op {
+	case "BL":
+		inst.Flow = FlowCall
+	case "BLR":
+		inst.Flow = FlowCall
+		inst.Indirect = true
+	case "B":
+		inst.Flow = FlowBranch
+	case "BR":
+		inst.Flow = FlowBranch
+		inst.Indirect = true
+	case "RET":
+		inst.Flow = FlowReturn
+	case "CBZ", "CBNZ", "TBZ", "TBNZ":
+		inst.Flow = FlowCondBranch
+	default:
+		if strings.HasPrefix(op, "B.") {
+			inst.Flow = FlowCondBranch
+		}
+	}
+
+	return inst, 4
+}
+
+// decodeX86 decodes a 32 or 64-bit x86 instruction.
+func decodeX86(code []byte, addr uint64, mode int) (Inst, int) {
+	inst := Inst{Addr: addr}
+	a, e := x86asm.Decode(code, mode)
+	if e != nil {
+		return Inst{Addr: addr, Text: fmt.Sprintf(".byte 0x%02x", code[0])}, 1
+	}
+
+	inst.Text = x86asm.GNUSyntax(a, addr, nil)
+	indirect := true
+	for _, arg := range a.Args {
+		if rel, ok := arg.(x86asm.Rel); ok {
+			inst.Target = uint64(int64(addr) + int64(a.Len) + int64(rel))
+			inst.HasTarget = true
+			indirect = false
+		}
+		if m, ok := arg.(x86asm.Mem); ok && (m.Base == x86asm.RIP) {
+			inst.Literal = uint64(int64(addr) + int64(a.Len) + m.Disp)
+			inst.HasLiteral = true
+		}
+	}
+
+	switch a.Op {
+	case x86asm.CALL, x86asm.LCALL:
+		inst.Flow = FlowCall
+		inst.Indirect = indirect
+	case x86asm.JMP, x86asm.LJMP:
+		inst.Flow = FlowBranch
+		inst.Indirect = indirect
+	case x86asm.RET, x86asm.LRET:
+		inst.Flow = FlowReturn
+	case x86asm.JA, x86asm.JAE, x86asm.JB, x86asm.JBE, x86asm.JCXZ, x86asm.JE,
+		x86asm.JECXZ, x86asm.JG, x86asm.JGE, x86asm.JL, x86asm.JLE, x86asm.JNE,
+		x86asm.JNO, x86asm.JNP, x86asm.JNS, x86asm.JO, x86asm.JP, x86asm.JRCXZ,
+		x86asm.JS, x86asm.LOOP, x86asm.LOOPE, x86asm.LOOPNE:
+		inst.Flow = FlowCondBranch
+	}
+
+	return inst, a.Len
+}
+
+// decodeRISCV decodes a RISC-V instruction, including the compressed forms,
+// for a base integer width (xlen) of 32 or 64 bits.
+func decodeRISCV(code []byte, addr uint64, xlen int) (Inst, int) {
+	if len(code) >= 2 && code[0]&3 != 3 {
+		if inst, ok := decodeRVC(binary.LittleEndian.Uint16(code), addr, xlen); ok {
+			return inst, 2
+		}
+	}
+
+	inst := Inst{Addr: addr}
+	a, e := riscv64asm.Decode(code)
+	if e != nil {
+		if len(code) >= 2 {
+			return Inst{Addr: addr, Text: fmt.Sprintf(".short 0x%04x", binary.LittleEndian.Uint16(code))}, 2
+		}
+		return Inst{Addr: addr, Text: fmt.Sprintf(".byte 0x%02x", code[0])}, 1
+	}
+
+	inst.Text = riscv64asm.GNUSyntax(a)
+	switch a.Op {
+	case riscv64asm.JAL:
+		off := a.Args[1].(riscv64asm.Simm)
+		inst.Target = uint64(int64(addr) + int64(off.Imm))
+		inst.HasTarget = true
+		inst.Text = replaceLast(inst.Text, strings.ToLower(off.String()), fmt.Sprintf("0x%x", inst.Target))
+		if a.Args[0].(riscv64asm.Reg) == riscv64asm.X0 {
+			inst.Flow = FlowBranch
+		} else {
+			inst.Flow = FlowCall
+		}
+	case riscv64asm.JALR:
+		rd := a.Args[0].(riscv64asm.Reg)
+		rs, _ := a.Args[1].(riscv64asm.RegOffset)
+		switch {
+		case rd != riscv64asm.X0:
+			inst.Flow = FlowCall
+			inst.Indirect = true
+		case rs.OfsReg == riscv64asm.X1:
+			inst.Flow = FlowReturn
+		default:
+			inst.Flow = FlowBranch
+			inst.Indirect = true
+		}
+	case riscv64asm.BEQ, riscv64asm.BNE, riscv64asm.BLT, riscv64asm.BGE,
+		riscv64asm.BLTU, riscv64asm.BGEU:
+		off := a.Args[2].(riscv64asm.Simm)
+		inst.Target = uint64(int64(addr) + int64(off.Imm))
+		inst.HasTarget = true
+		inst.Flow = FlowCondBranch
+		inst.Text = replaceLast(inst.Text, strings.ToLower(off.String()), fmt.Sprintf("0x%x", inst.Target))
+	}
+
+	return inst, a.Len
+}
+
+// decodeRVC decodes the compressed instructions that riscv64asm doesn't
+// handle: the RV32-only encodings, which RV64 reuses for other instructions,
+// and C.J, whose offset riscv64asm halves.
+func decodeRVC(i uint16, addr uint64, xlen int) (Inst, bool) {
+	inst := Inst{Addr: addr}
+	rv32 := xlen == 32
+	switch {
+	case i&0xE003 == 0xA001, rv32 && i&0xE003 == 0x2001:
+		// C.J and C.JAL
+		off := uint64(i>>12&1)<<11 | uint64(i>>11&1)<<4 | uint64(i>>9&3)<<8 |
+			uint64(i>>8&1)<<10 | uint64(i>>7&1)<<6 | uint64(i>>6&1)<<7 |
+			uint64(i>>3&7)<<1 | uint64(i>>2&1)<<5
+		inst.Target = addr + uint64(int64(off<<52)>>52)
+		inst.HasTarget = true
+		if i&0x8000 != 0 {
+			inst.Text = fmt.Sprintf("j 0x%x", inst.Target)
+			inst.Flow = FlowBranch
+		} else {
+			inst.Text = fmt.Sprintf("jal 0x%x", inst.Target)
+			inst.Flow = FlowCall
+		}
+	case rv32 && (i&0xE003 == 0x6000 || i&0xE003 == 0xE000):
+		// C.FLW and C.FSW
+		imm := i>>10&7<<3 | i>>6&1<<2 | i>>5&1<<6
+		mn := "flw"
+		if i&0x8000 != 0 {
+			mn = "fsw"
+		}
+		inst.Text = fmt.Sprintf("%s f%d,%d(x%d)", mn, 8+i>>2&7, imm, 8+i>>7&7)
+	case rv32 && i&0xE003 == 0x6002:
+		// C.FLWSP
+		imm := i>>12&1<<5 | i>>4&7<<2 | i>>2&3<<6
+		inst.Text = fmt.Sprintf("flw f%d,%d(x2)", i>>7&31, imm)
+	case rv32 && i&0xE003 == 0xE002:
+		// C.FSWSP
+		imm := i>>9&15<<2 | i>>7&3<<6
+		inst.Text = fmt.Sprintf("fsw f%d,%d(x2)", i>>2&31, imm)
+	default:
+		return inst, false
+	}
+	return inst, true
+}
+
+// replaceLast replaces the last occurrence of old in s.
+func replaceLast(s, old, new string) string {
+	i := strings.LastIndex(s, old)
+	if i < 0 {
+		return s
+	}
+	return s[:i] + new + s[i+len(old):]
+}
@@ -0,0 +1,1117 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Thumb condition code suffixes
+var condNames = [16]string{
+	"eq", "ne", "cs", "cc", "mi", "pl", "vs", "vc",
+	"hi", "ls", "ge", "lt", "gt", "le", "", "",
+}
+
+// Special register names used by MRS and MSR on M-profile cores
+var sysRegNames = map[uint16]string{
+	0x00: "apsr",
+	0x01: "iapsr",
+	0x02: "eapsr",
+	0x03: "xpsr",
+	0x05: "ipsr",
+	0x06: "epsr",
+	0x07: "iepsr",
+	0x08: "msp",
+	0x09: "psp",
+	0x0A: "msplim",
+	0x0B: "psplim",
+	0x10: "primask",
+	0x11: "basepri",
+	0x12: "basepri_max",
+	0x13: "faultmask",
+	0x14: "control",
+	0x88: "msp_ns",
+	0x89: "psp_ns",
+	0x8A: "msplim_ns",
+	0x8B: "psplim_ns",
+	0x90: "primask_ns",
+	0x91: "basepri_ns",
+	0x93: "faultmask_ns",
+	0x94: "control_ns",
+	0x98: "sp_ns",
+}
+
+// Shift type names for shifted register operands
+var shiftNames = [4]string{"lsl", "lsr", "asr", "ror"}
+
+// thumbDecoder decodes the Thumb-2 instruction set used by Cortex-M cores,
+// tracking the state of IT blocks between instructions.
+type thumbDecoder struct {
+	it      []uint8 // Conditions for the remaining instructions in an IT block
+	startIT bool    // Set when the current instruction opens an IT block
+}
+
+// reg returns the GNU name of core register r.
+func reg(r uint32) string {
+	switch r {
+	case 10:
+		return "sl"
+	case 11:
+		return "fp"
+	case 12:
+		return "ip"
+	case 13:
+		return "sp"
+	case 14:
+		return "lr"
+	case 15:
+		return "pc"
+	}
+	return fmt.Sprintf("r%d", r)
+}
+
+// regList renders a register bitmask as '{r4, r5, lr}'.
+func regList(mask uint32) string {
+	var regs []string
+	for r := uint32(0); r < 16; r++ {
+		if mask&(1<<r) != 0 {
+			regs = append(regs, reg(r))
+		}
+	}
+	return "{" + strings.Join(regs, ", ") + "}"
+}
+
+// imm renders an immediate operand, using hex for larger values.
+func imm(v uint32) string {
+	if v > 4095 {
+		return fmt.Sprintf("#0x%x", v)
+	}
+	return fmt.Sprintf("#%d", v)
+}
+
+// simm renders a signed immediate offset.
+func simm(v int32) string {
+	if v < 0 {
+		return fmt.Sprintf("#-%d", -v)
+	}
+	return fmt.Sprintf("#%d", v)
+}
+
+// signExtend sign-extends the lowest 'bits' bits of v.
+func signExtend(v uint32, bits uint) int32 {
+	shift := 32 - bits
+	return int32(v<<shift) >> shift
+}
+
+// thumbExpandImm decodes a Thumb-2 modified immediate constant.
+func thumbExpandImm(i uint32) uint32 {
+	if i>>10 == 0 {
+		b := i & 0xFF
+		switch i >> 8 & 3 {
+		case 0:
+			return b
+		case 1:
+			return b<<16 | b
+		case 2:
+			return b<<24 | b<<8
+		default:
+			return b<<24 | b<<16 | b<<8 | b
+		}
+	}
+	v := 0x80 | i&0x7F
+	rot := i >> 7
+	return v>>rot | v<<(32-rot)
+}
+
+// cond returns the condition suffix of the current instruction, which is
+// only set inside an IT block.
+func (d *thumbDecoder) cond() string {
+	if len(d.it) == 0 {
+		return ""
+	}
+	return condNames[d.it[0]]
+}
+
+// flags returns the suffix for a 16-bit data processing instruction, which
+// sets the flags outside of an IT block and is conditional inside one.
+func (d *thumbDecoder) flags() string {
+	if len(d.it) == 0 {
+		return "s"
+	}
+	return condNames[d.it[0]]
+}
+
+// decode decodes the instruction at the start of code, returning the
+// decoded instruction and its size in bytes.
+func (d *thumbDecoder) decode(code []byte, pc uint64, order binary.ByteOrder) (Inst, int) {
+	inst := Inst{Addr: pc}
+	d.startIT = false
+	if len(code) < 2 {
+		inst.Text = fmt.Sprintf(".byte 0x%02x", code[0])
+		return inst, 1
+	}
+
+	hw1 := uint32(order.Uint16(code))
+	size := 2
+	if hw1>>11 >= 0x1D {
+		if len(code) < 4 {
+			inst.Text = fmt.Sprintf(".short 0x%04x", hw1)
+			return inst, 2
+		}
+		hw2 := uint32(order.Uint16(code[2:]))
+		d.decode32(&inst, hw1, hw2, uint32(pc))
+		size = 4
+	} else {
+		d.decode16(&inst, hw1, uint32(pc))
+	}
+
+	// Advance the IT block, unless this was the IT instruction itself
+	if !d.startIT && len(d.it) > 0 {
+		d.it = d.it[1:]
+	}
+
+	return inst, size
+}
+
+// branch records a direct branch or call target.
+func branch(inst *Inst, target uint32, flow Flow) {
+	inst.Target = uint64(target)
+	inst.HasTarget = true
+	inst.Flow = flow
+}
+
+// literal records a PC-relative data reference.
+func literal(inst *Inst, addr uint32) {
+	inst.Literal = uint64(addr)
+	inst.HasLiteral = true
+}
+
+// decode16 decodes a 16-bit Thumb instruction.
+func (d *thumbDecoder) decode16(inst *Inst, h uint32, pc uint32) {
+	rd := h & 7
+	rn := h >> 3 & 7
+	rm := h >> 6 & 7
+
+	switch {
+	case h>>13 == 0:
+		// Shift (immediate), add, subtract, move and compare
+		op := h >> 11 & 3
+		i5 := h >> 6 & 0x1F
+		switch {
+		case op == 0 && i5 == 0:
+			inst.Text = fmt.Sprintf("mov%s %s, %s", d.flags(), reg(rd), reg(rn))
+		case op < 3:
+			if op > 0 && i5 == 0 {
+				i5 = 32
+			}
+			inst.Text = fmt.Sprintf("%s%s %s, %s, #%d", shiftNames[op], d.flags(), reg(rd), reg(rn), i5)
+		default:
+			mn := "add"
+			if h>>9&1 != 0 {
+				mn = "sub"
+			}
+			if h>>10&1 != 0 {
+				inst.Text = fmt.Sprintf("%s%s %s, %s, #%d", mn, d.flags(), reg(rd), reg(rn), rm)
+			} else {
+				inst.Text = fmt.Sprintf("%s%s %s, %s, %s", mn, d.flags(), reg(rd), reg(rn), reg(rm))
+			}
+		}
+
+	case h>>13 == 1:
+		// Move, compare, add, subtract 8-bit immediate
+		r := h >> 8 & 7
+		i8 := h & 0xFF
+		switch h >> 11 & 3 {
+		case 0:
+			inst.Text = fmt.Sprintf("mov%s %s, #%d", d.flags(), reg(r), i8)
+		case 1:
+			inst.Text = fmt.Sprintf("cmp%s %s, #%d", d.cond(), reg(r), i8)
+		case 2:
+			inst.Text = fmt.Sprintf("add%s %s, #%d", d.flags(), reg(r), i8)
+		case 3:
+			inst.Text = fmt.Sprintf("sub%s %s, #%d", d.flags(), reg(r), i8)
+		}
+
+	case h>>10 == 0x10:
+		// Data processing
+		ops := [16]string{"and", "eor", "lsl", "lsr", "asr", "adc", "sbc", "ror",
+			"tst", "neg", "cmp", "cmn", "orr", "mul", "bic", "mvn"}
+		op := h >> 6 & 0xF
+		switch op {
+		case 8, 10, 11:
+			inst.Text = fmt.Sprintf("%s%s %s, %s", ops[op], d.cond(), reg(rd), reg(rn))
+		case 13:
+			inst.Text = fmt.Sprintf("mul%s %s, %s, %s", d.flags(), reg(rd), reg(rn), reg(rd))
+		default:
+			inst.Text = fmt.Sprintf("%s%s %s, %s", ops[op], d.flags(), reg(rd), reg(rn))
+		}
+
+	case h>>10 == 0x11:
+		// Special data instructions and branch and exchange
+		rdn := h>>4&8 | h&7
+		rm := h >> 3 & 0xF
+		switch h >> 8 & 3 {
+		case 0:
+			inst.Text = fmt.Sprintf("add%s %s, %s", d.cond(), reg(rdn), reg(rm))
+		case 1:
+			inst.Text = fmt.Sprintf("cmp%s %s, %s", d.cond(), reg(rdn), reg(rm))
+		case 2:
+			inst.Text = fmt.Sprintf("mov%s %s, %s", d.cond(), reg(rdn), reg(rm))
+			if rdn == 15 {
+				inst.Flow = FlowBranch
+				inst.Indirect = true
+			}
+		case 3:
+			if h>>7&1 != 0 {
+				inst.Text = fmt.Sprintf("blx%s %s", d.cond(), reg(rm))
+				inst.Flow = FlowCall
+				inst.Indirect = true
+			} else {
+				inst.Text = fmt.Sprintf("bx%s %s", d.cond(), reg(rm))
+				if rm == 14 {
+					inst.Flow = FlowReturn
+				} else {
+					inst.Flow = FlowBranch
+					inst.Indirect = true
+				}
+			}
+		}
+
+	case h>>11 == 0x09:
+		// Load from literal pool
+		i8 := (h & 0xFF) << 2
+		inst.Text = fmt.Sprintf("ldr%s %s, [pc, #%d]", d.cond(), reg(h>>8&7), i8)
+		literal(inst, (pc+4)&^3+i8)
+
+	case h>>12 == 0x5:
+		// Load/store register offset
+		ops := [8]string{"str", "strh", "strb", "ldrsb", "ldr", "ldrh", "ldrb", "ldrsh"}
+		inst.Text = fmt.Sprintf("%s%s %s, [%s, %s]", ops[h>>9&7], d.cond(), reg(rd), reg(rn), reg(rm))
+
+	case h>>13 == 0x3:
+		// Load/store word or byte, immediate offset
+		i5 := h >> 6 & 0x1F
+		ops := [4]string{"str", "ldr", "strb", "ldrb"}
+		op := h >> 11 & 3
+		if op < 2 {
+			i5 <<= 2
+		}
+		inst.Text = fmt.Sprintf("%s%s %s, [%s, #%d]", ops[op], d.cond(), reg(rd), reg(rn), i5)
+
+	case h>>12 == 0x8:
+		// Load/store halfword, immediate offset
+		mn := "strh"
+		if h>>11&1 != 0 {
+			mn = "ldrh"
+		}
+		inst.Text = fmt.Sprintf("%s%s %s, [%s, #%d]", mn, d.cond(), reg(rd), reg(rn), (h>>6&0x1F)<<1)
+
+	case h>>12 == 0x9:
+		// Load/store SP-relative
+		mn := "str"
+		if h>>11&1 != 0 {
+			mn = "ldr"
+		}
+		inst.Text = fmt.Sprintf("%s%s %s, [sp, #%d]", mn, d.cond(), reg(h>>8&7), (h&0xFF)<<2)
+
+	case h>>11 == 0x14:
+		// Generate PC-relative address
+		addr := (pc+4)&^3 + (h&0xFF)<<2
+		inst.Text = fmt.Sprintf("adr%s %s, 0x%x", d.cond(), reg(h>>8&7), addr)
+		literal(inst, addr)
+
+	case h>>11 == 0x15:
+		// Generate SP-relative address
+		inst.Text = fmt.Sprintf("add%s %s, sp, #%d", d.cond(), reg(h>>8&7), (h&0xFF)<<2)
+
+	case h>>12 == 0xB:
+		d.decodeMisc16(inst, h, pc)
+
+	case h>>12 == 0xC:
+		// Load/store multiple
+		r := h >> 8 & 7
+		list := h & 0xFF
+		if h>>11&1 != 0 {
+			wb := "!"
+			if list&(1<<r) != 0 {
+				wb = ""
+			}
+			inst.Text = fmt.Sprintf("ldmia%s %s%s, %s", d.cond(), reg(r), wb, regList(list))
+		} else {
+			inst.Text = fmt.Sprintf("stmia%s %s!, %s", d.cond(), reg(r), regList(list))
+		}
+
+	case h>>12 == 0xD:
+		// Conditional branch and supervisor call
+		c := h >> 8 & 0xF
+		switch c {
+		case 0xE:
+			inst.Text = fmt.Sprintf("udf #%d", h&0xFF)
+		case 0xF:
+			inst.Text = fmt.Sprintf("svc %d", h&0xFF)
+		default:
+			target := pc + 4 + uint32(signExtend((h&0xFF)<<1, 9))
+			inst.Text = fmt.Sprintf("b%s.n 0x%x", condNames[c], target)
+			branch(inst, target, FlowCondBranch)
+		}
+
+	case h>>11 == 0x1C:
+		// Unconditional branch
+		target := pc + 4 + uint32(signExtend((h&0x7FF)<<1, 12))
+		inst.Text = fmt.Sprintf("b%s.n 0x%x", d.cond(), target)
+		if d.cond() != "" {
+			branch(inst, target, FlowCondBranch)
+		} else {
+			branch(inst, target, FlowBranch)
+		}
+
+	default:
+		inst.Text = fmt.Sprintf(".inst.n 0x%04x", h)
+	}
+}
+
+// decodeMisc16 decodes the 16-bit miscellaneous instructions (1011xxxx).
+func (d *thumbDecoder) decodeMisc16(inst *Inst, h uint32, pc uint32) {
+	switch {
+	case h&0xFF80 == 0xB000:
+		inst.Text = fmt.Sprintf("add%s sp, #%d", d.cond(), (h&0x7F)<<2)
+	case h&0xFF80 == 0xB080:
+		inst.Text = fmt.Sprintf("sub%s sp, #%d", d.cond(), (h&0x7F)<<2)
+	case h&0xF500 == 0xB100:
+		// Compare and branch on (non-)zero
+		mn := "cbz"
+		if h>>11&1 != 0 {
+			mn = "cbnz"
+		}
+		target := pc + 4 + ((h>>9&1)<<6 | (h>>3&0x1F)<<1)
+		inst.Text = fmt.Sprintf("%s %s, 0x%x", mn, reg(h&7), target)
+		branch(inst, target, FlowCondBranch)
+	case h&0xFF00 == 0xB200:
+		ops := [4]string{"sxth", "sxtb", "uxth", "uxtb"}
+		inst.Text = fmt.Sprintf("%s%s %s, %s", ops[h>>6&3], d.cond(), reg(h&7), reg(h>>3&7))
+	case h&0xFE00 == 0xB400:
+		inst.Text = fmt.Sprintf("push%s %s", d.cond(), regList(h&0xFF|(h>>8&1)<<14))
+	case h&0xFFE8 == 0xB660:
+		mn := "cpsie"
+		if h>>4&1 != 0 {
+			mn = "cpsid"
+		}
+		fl := ""
+		if h&1 != 0 {
+			fl += "f"
+		}
+		if h&2 != 0 {
+			fl += "i"
+		}
+		inst.Text = fmt.Sprintf("%s %s", mn, fl)
+	case h&0xFFC0 == 0xBA00:
+		inst.Text = fmt.Sprintf("rev%s %s, %s", d.cond(), reg(h&7), reg(h>>3&7))
+	case h&0xFFC0 == 0xBA40:
+		inst.Text = fmt.Sprintf("rev16%s %s, %s", d.cond(), reg(h&7), reg(h>>3&7))
+	case h&0xFFC0 == 0xBAC0:
+		inst.Text = fmt.Sprintf("revsh%s %s, %s", d.cond(), reg(h&7), reg(h>>3&7))
+	case h&0xFE00 == 0xBC00:
+		inst.Text = fmt.Sprintf("pop%s %s", d.cond(), regList(h&0xFF|(h>>8&1)<<15))
+		if h&0x100 != 0 {
+			inst.Flow = FlowReturn
+		}
+	case h&0xFF00 == 0xBE00:
+		inst.Text = fmt.Sprintf("bkpt 0x%04x", h&0xFF)
+	case h&0xFF00 == 0xBF00 && h&0xF != 0:
+		d.decodeIT(inst, h)
+	case h&0xFF00 == 0xBF00:
+		hints := [5]string{"nop", "yield", "wfe", "wfi", "sev"}
+		if op := h >> 4 & 0xF; op < 5 {
+			inst.Text = hints[op] + d.cond()
+		} else {
+			inst.Text = fmt.Sprintf(".inst.n 0x%04x", h)
+		}
+	default:
+		inst.Text = fmt.Sprintf(".inst.n 0x%04x", h)
+	}
+}
+
+// decodeIT decodes an If-Then instruction and starts a new IT block.
+func (d *thumbDecoder) decodeIT(inst *Inst, h uint32) {
+	first := uint8(h >> 4 & 0xF)
+	mask := uint8(h & 0xF)
+
+	d.it = []uint8{first}
+	d.startIT = true
+	suffix := ""
+	for i := uint(3); i > 0; i-- {
+		if mask&(1<<(i-1)) == 0 && mask&((1<<(i-1))-1) == 0 {
+			break
+		}
+		bit := mask >> i & 1
+		if bit == first&1 {
+			suffix += "t"
+			d.it = append(d.it, first)
+		} else {
+			suffix += "e"
+			d.it = append(d.it, first^1)
+		}
+	}
+
+	inst.Text = fmt.Sprintf("it%s %s", suffix, condNames[first])
+}
+
+// decode32 decodes a 32-bit Thumb-2 instruction.
+func (d *thumbDecoder) decode32(inst *Inst, hw1, hw2 uint32, pc uint32) {
+	op1 := hw1 >> 11 & 3
+	op2 := hw1 >> 4 & 0x7F
+
+	switch {
+	case op1 == 1 && op2&0x64 == 0x00:
+		d.decodeLdmStm(inst, hw1, hw2)
+	case op1 == 1 && op2&0x64 == 0x04:
+		d.decodeDualExclusive(inst, hw1, hw2, pc)
+	case op1 == 1 && op2&0x60 == 0x20:
+		d.decodeShiftedReg(inst, hw1, hw2)
+	case op1 == 2 && hw2&0x8000 == 0 && op2&0x20 == 0:
+		d.decodeModifiedImm(inst, hw1, hw2)
+	case op1 == 2 && hw2&0x8000 == 0:
+		d.decodePlainImm(inst, hw1, hw2, pc)
+	case op1 == 2:
+		d.decodeBranchMisc(inst, hw1, hw2, pc)
+	case op1 == 3 && op2&0x71 == 0x00, op1 == 3 && op2&0x67 == 0x01,
+		op1 == 3 && op2&0x67 == 0x03, op1 == 3 && op2&0x67 == 0x05:
+		d.decodeLoadStore(inst, hw1, hw2, pc)
+	case op1 == 3 && op2&0x70 == 0x20:
+		d.decodeRegister(inst, hw1, hw2)
+	case op1 == 3 && op2&0x78 == 0x30:
+		d.decodeMultiply(inst, hw1, hw2)
+	case op1 == 3 && op2&0x78 == 0x38:
+		d.decodeLongMultiply(inst, hw1, hw2)
+	case op2&0x40 != 0:
+		d.decodeCoprocessor(inst, hw1, hw2)
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// decodeLdmStm decodes the 32-bit load/store multiple instructions.
+func (d *thumbDecoder) decodeLdmStm(inst *Inst, hw1, hw2 uint32) {
+	rn := hw1 & 0xF
+	wb := hw1>>5&1 != 0
+	load := hw1>>4&1 != 0
+	list := hw2 & 0xDFFF
+	wbs := ""
+	if wb {
+		wbs = "!"
+	}
+
+	switch hw1 >> 7 & 3 {
+	case 1:
+		if load && rn == 13 && wb {
+			inst.Text = fmt.Sprintf("pop%s.w %s", d.cond(), regList(list))
+		} else if load {
+			inst.Text = fmt.Sprintf("ldmia%s.w %s%s, %s", d.cond(), reg(rn), wbs, regList(list))
+		} else {
+			inst.Text = fmt.Sprintf("stmia%s.w %s%s, %s", d.cond(), reg(rn), wbs, regList(list))
+		}
+	case 2:
+		if !load && rn == 13 && wb {
+			inst.Text = fmt.Sprintf("push%s.w %s", d.cond(), regList(list))
+		} else if load {
+			inst.Text = fmt.Sprintf("ldmdb%s %s%s, %s", d.cond(), reg(rn), wbs, regList(list))
+		} else {
+			inst.Text = fmt.Sprintf("stmdb%s %s%s, %s", d.cond(), reg(rn), wbs, regList(list))
+		}
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		return
+	}
+
+	if load && list&0x8000 != 0 {
+		inst.Flow = FlowReturn
+	}
+}
+
+// decodeDualExclusive decodes load/store dual, load/store exclusive and
+// table branch instructions.
+func (d *thumbDecoder) decodeDualExclusive(inst *Inst, hw1, hw2 uint32, pc uint32) {
+	rn := hw1 & 0xF
+	rt := hw2 >> 12
+	rt2 := hw2 >> 8 & 0xF
+	i8 := (hw2 & 0xFF) << 2
+
+	switch {
+	case hw1&0xFFF0 == 0xE840 && hw2&0xF000 == 0xF000:
+		// ARMv8-M test target (TT, TTT, TTA, TTAT)
+		mn := [4]string{"tt", "ttt", "tta", "ttat"}[hw2>>6&3]
+		inst.Text = fmt.Sprintf("%s%s %s, %s", mn, d.cond(), reg(rt2), reg(rn))
+	case hw1&0xFFF0 == 0xE840:
+		inst.Text = fmt.Sprintf("strex%s %s, %s, [%s, #%d]", d.cond(), reg(rt2), reg(rt), reg(rn), i8)
+	case hw1&0xFFF0 == 0xE850:
+		inst.Text = fmt.Sprintf("ldrex%s %s, [%s, #%d]", d.cond(), reg(rt), reg(rn), i8)
+	case hw1&0xFFF0 == 0xE8D0 && hw2&0xFFE0 == 0xF000:
+		mn, idx := "tbb", fmt.Sprintf("[%s, %s]", reg(rn), reg(hw2&0xF))
+		if hw2&0x10 != 0 {
+			mn, idx = "tbh", fmt.Sprintf("[%s, %s, lsl #1]", reg(rn), reg(hw2&0xF))
+		}
+		inst.Text = fmt.Sprintf("%s%s %s", mn, d.cond(), idx)
+		inst.Flow = FlowBranch
+		inst.Indirect = true
+	case hw1&0xFFE0 == 0xE8C0:
+		ops := [4]string{"b", "h", "", "d"}
+		sz := hw2 >> 4 & 3
+		if hw1&0x10 != 0 {
+			inst.Text = fmt.Sprintf("ldrex%s%s %s, [%s]", ops[sz], d.cond(), reg(rt), reg(rn))
+		} else {
+			inst.Text = fmt.Sprintf("strex%s%s %s, %s, [%s]", ops[sz], d.cond(), reg(hw2&0xF), reg(rt), reg(rn))
+		}
+	case hw1&0x0120 != 0:
+		// Load/store dual
+		mn := "strd"
+		if hw1&0x10 != 0 {
+			mn = "ldrd"
+		}
+		off := int32(i8)
+		if hw1&0x80 == 0 {
+			off = -off
+		}
+		if rn == 15 {
+			addr := uint32(int32((pc+4)&^3) + off)
+			inst.Text = fmt.Sprintf("%s%s %s, %s, [pc, %s]", mn, d.cond(), reg(rt), reg(rt2), simm(off))
+			literal(inst, addr)
+			return
+		}
+		inst.Text = fmt.Sprintf("%s%s %s, %s, %s", mn, d.cond(), reg(rt), reg(rt2),
+			indexed(reg(rn), off, hw1&0x100 != 0, hw1&0x20 != 0))
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// indexed renders a base register plus immediate offset addressing mode.
+func indexed(rn string, off int32, pre bool, wb bool) string {
+	switch {
+	case pre && wb:
+		return fmt.Sprintf("[%s, %s]!", rn, simm(off))
+	case pre:
+		if off == 0 {
+			return fmt.Sprintf("[%s]", rn)
+		}
+		return fmt.Sprintf("[%s, %s]", rn, simm(off))
+	default:
+		return fmt.Sprintf("[%s], %s", rn, simm(off))
+	}
+}
+
+// Data processing operations shared by the modified immediate and shifted
+// register encodings
+var dpOps = map[uint32]string{
+	0x0: "and", 0x1: "bic", 0x2: "orr", 0x3: "orn", 0x4: "eor",
+	0x8: "add", 0xA: "adc", 0xB: "sbc", 0xD: "sub", 0xE: "rsb",
+}
+
+// Comparison forms of the data processing operations, used when Rd is PC
+var dpTestOps = map[uint32]string{
+	0x0: "tst", 0x4: "teq", 0x8: "cmn", 0xD: "cmp",
+}
+
+// dpName returns the mnemonic, flag suffix and operand layout for a 32-bit
+// data processing instruction. 'kind' is 't' for comparisons with no
+// destination, 'm' for moves with no first operand and 'd' otherwise.
+func (d *thumbDecoder) dpName(op, rn, rd uint32, s bool) (string, byte, bool) {
+	sfx := ""
+	if s {
+		sfx = "s"
+	}
+	if tst, ok := dpTestOps[op]; ok && rd == 15 && s {
+		return tst + d.cond() + ".w", 't', true
+	}
+	if rn == 15 && op == 0x2 {
+		return "mov" + sfx + d.cond() + ".w", 'm', true
+	}
+	if rn == 15 && op == 0x3 {
+		return "mvn" + sfx + d.cond(), 'm', true
+	}
+	name, ok := dpOps[op]
+	if !ok {
+		return "", 0, false
+	}
+	w := ".w"
+	if op == 0x3 || op == 0xA || op == 0xB || op == 0xE {
+		w = ""
+	}
+	return name + sfx + d.cond() + w, 'd', true
+}
+
+// decodeModifiedImm decodes data processing with a modified immediate.
+func (d *thumbDecoder) decodeModifiedImm(inst *Inst, hw1, hw2 uint32) {
+	op := hw1 >> 5 & 0xF
+	rn := hw1 & 0xF
+	rd := hw2 >> 8 & 0xF
+	v := thumbExpandImm((hw1>>10&1)<<11 | (hw2>>12&7)<<8 | hw2&0xFF)
+
+	name, kind, ok := d.dpName(op, rn, rd, hw1&0x10 != 0)
+	switch {
+	case !ok:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	case kind == 't':
+		inst.Text = fmt.Sprintf("%s %s, %s", name, reg(rn), imm(v))
+	case kind == 'm':
+		inst.Text = fmt.Sprintf("%s %s, %s", name, reg(rd), imm(v))
+	default:
+		inst.Text = fmt.Sprintf("%s %s, %s, %s", name, reg(rd), reg(rn), imm(v))
+	}
+}
+
+// decodePlainImm decodes data processing with a plain binary immediate.
+func (d *thumbDecoder) decodePlainImm(inst *Inst, hw1, hw2 uint32, pc uint32) {
+	rn := hw1 & 0xF
+	rd := hw2 >> 8 & 0xF
+	i12 := (hw1>>10&1)<<11 | (hw2>>12&7)<<8 | hw2&0xFF
+	lsb := (hw2>>12&7)<<2 | hw2>>6&3
+	c := d.cond()
+
+	switch hw1 >> 4 & 0x1F {
+	case 0x00:
+		if rn == 15 {
+			addr := (pc+4)&^3 + i12
+			inst.Text = fmt.Sprintf("adr%s.w %s, 0x%x", c, reg(rd), addr)
+			literal(inst, addr)
+		} else {
+			inst.Text = fmt.Sprintf("addw%s %s, %s, #%d", c, reg(rd), reg(rn), i12)
+		}
+	case 0x0A:
+		if rn == 15 {
+			addr := (pc+4)&^3 - i12
+			inst.Text = fmt.Sprintf("adr%s.w %s, 0x%x", c, reg(rd), addr)
+			literal(inst, addr)
+		} else {
+			inst.Text = fmt.Sprintf("subw%s %s, %s, #%d", c, reg(rd), reg(rn), i12)
+		}
+	case 0x04:
+		inst.Text = fmt.Sprintf("movw%s %s, #%d", c, reg(rd), rn<<12|i12)
+	case 0x0C:
+		inst.Text = fmt.Sprintf("movt%s %s, #%d", c, reg(rd), rn<<12|i12)
+	case 0x14:
+		inst.Text = fmt.Sprintf("sbfx%s %s, %s, #%d, #%d", c, reg(rd), reg(rn), lsb, hw2&0x1F+1)
+	case 0x1C:
+		inst.Text = fmt.Sprintf("ubfx%s %s, %s, #%d, #%d", c, reg(rd), reg(rn), lsb, hw2&0x1F+1)
+	case 0x16:
+		width := hw2&0x1F + 1 - lsb
+		if rn == 15 {
+			inst.Text = fmt.Sprintf("bfc%s %s, #%d, #%d", c, reg(rd), lsb, width)
+		} else {
+			inst.Text = fmt.Sprintf("bfi%s %s, %s, #%d, #%d", c, reg(rd), reg(rn), lsb, width)
+		}
+	case 0x10, 0x12:
+		inst.Text = fmt.Sprintf("ssat%s %s, #%d, %s", c, reg(rd), hw2&0x1F+1, reg(rn))
+	case 0x18, 0x1A:
+		inst.Text = fmt.Sprintf("usat%s %s, #%d, %s", c, reg(rd), hw2&0x1F, reg(rn))
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// decodeShiftedReg decodes data processing with a shifted register operand.
+func (d *thumbDecoder) decodeShiftedReg(inst *Inst, hw1, hw2 uint32) {
+	op := hw1 >> 5 & 0xF
+	rn := hw1 & 0xF
+	rd := hw2 >> 8 & 0xF
+	rm := hw2 & 0xF
+	typ := hw2 >> 4 & 3
+	amount := (hw2>>12&7)<<2 | hw2>>6&3
+	s := hw1&0x10 != 0
+
+	// Shift suffix for the second operand
+	shift := ""
+	switch {
+	case typ == 3 && amount == 0:
+		shift = ", rrx"
+	case typ == 0 && amount == 0:
+	case amount == 0:
+		shift = fmt.Sprintf(", %s #32", shiftNames[typ])
+	default:
+		shift = fmt.Sprintf(", %s #%d", shiftNames[typ], amount)
+	}
+
+	// MOV with a shift is shown as the shift instruction itself
+	if op == 0x2 && rn == 15 && shift != "" {
+		sfx := ""
+		if s {
+			sfx = "s"
+		}
+		if typ == 3 && amount == 0 {
+			inst.Text = fmt.Sprintf("rrx%s%s %s, %s", sfx, d.cond(), reg(rd), reg(rm))
+		} else {
+			inst.Text = fmt.Sprintf("%s%s%s.w %s, %s, #%d", shiftNames[typ], sfx, d.cond(), reg(rd), reg(rm), amount)
+		}
+		return
+	}
+
+	name, kind, ok := d.dpName(op, rn, rd, s)
+	switch {
+	case !ok:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	case kind == 't':
+		inst.Text = fmt.Sprintf("%s %s, %s%s", name, reg(rn), reg(rm), shift)
+	case kind == 'm':
+		inst.Text = fmt.Sprintf("%s %s, %s%s", name, reg(rd), reg(rm), shift)
+	default:
+		inst.Text = fmt.Sprintf("%s %s, %s, %s%s", name, reg(rd), reg(rn), reg(rm), shift)
+	}
+}
+
+// decodeBranchMisc decodes branches and miscellaneous control instructions.
+func (d *thumbDecoder) decodeBranchMisc(inst *Inst, hw1, hw2 uint32, pc uint32) {
+	s := hw1 >> 10 & 1
+	j1 := hw2 >> 13 & 1
+	j2 := hw2 >> 11 & 1
+
+	// Offset used by BL, BLX and B.W (encoding T4)
+	i1 := ^(j1 ^ s) & 1
+	i2 := ^(j2 ^ s) & 1
+	off := signExtend(s<<24|i1<<23|i2<<22|(hw1&0x3FF)<<12|(hw2&0x7FF)<<1, 25)
+
+	switch hw2 & 0xD000 {
+	case 0xD000:
+		target := uint32(int32(pc+4) + off)
+		inst.Text = fmt.Sprintf("bl%s 0x%x", d.cond(), target)
+		branch(inst, target, FlowCall)
+		return
+	case 0xC000:
+		target := uint32(int32((pc+4)&^3) + off)
+		inst.Text = fmt.Sprintf("blx%s 0x%x", d.cond(), target)
+		branch(inst, target, FlowCall)
+		return
+	case 0x9000:
+		target := uint32(int32(pc+4) + off)
+		inst.Text = fmt.Sprintf("b%s.w 0x%x", d.cond(), target)
+		if d.cond() != "" {
+			branch(inst, target, FlowCondBranch)
+		} else {
+			branch(inst, target, FlowBranch)
+		}
+		return
+	}
+
+	if hw2&0xD000 != 0x8000 {
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		return
+	}
+
+	// Conditional branch (encoding T3)
+	if c := hw1 >> 6 & 0xF; c < 0xE {
+		off := signExtend(s<<20|j2<<19|j1<<18|(hw1&0x3F)<<12|(hw2&0x7FF)<<1, 21)
+		target := uint32(int32(pc+4) + off)
+		inst.Text = fmt.Sprintf("b%s.w 0x%x", condNames[c], target)
+		branch(inst, target, FlowCondBranch)
+		return
+	}
+
+	switch {
+	case hw1&0xFFE0 == 0xF380:
+		name, ok := sysRegNames[uint16(hw2&0xFF)]
+		if !ok {
+			name = fmt.Sprintf("%d", hw2&0xFF)
+		}
+		if hw2&0xFF < 4 {
+			name += "_nzcvq"
+		}
+		inst.Text = fmt.Sprintf("msr%s %s, %s", d.cond(), name, reg(hw1&0xF))
+	case hw1&0xFFE0 == 0xF3E0:
+		name, ok := sysRegNames[uint16(hw2&0xFF)]
+		if !ok {
+			name = fmt.Sprintf("%d", hw2&0xFF)
+		}
+		inst.Text = fmt.Sprintf("mrs%s %s, %s", d.cond(), reg(hw2>>8&0xF), name)
+	case hw1 == 0xF3AF:
+		hints := [5]string{"nop", "yield", "wfe", "wfi", "sev"}
+		if op := hw2 & 0xFF; op < 5 && hw2&0x700 == 0 {
+			inst.Text = hints[op] + d.cond() + ".w"
+		} else {
+			inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		}
+	case hw1 == 0xF3BF:
+		opt := "sy"
+		if hw2&0xF != 0xF {
+			opt = fmt.Sprintf("#%d", hw2&0xF)
+		}
+		switch hw2 >> 4 & 0xF {
+		case 0x4:
+			inst.Text = "dsb " + opt
+		case 0x5:
+			inst.Text = "dmb " + opt
+		case 0x6:
+			inst.Text = "isb " + opt
+		default:
+			inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		}
+	case hw1&0xFFF0 == 0xF7F0 && hw2&0xF000 == 0xA000:
+		inst.Text = fmt.Sprintf("udf.w #%d", (hw1&0xF)<<12|hw2&0xFFF)
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// decodeLoadStore decodes the 32-bit single register load and store
+// instructions.
+func (d *thumbDecoder) decodeLoadStore(inst *Inst, hw1, hw2 uint32, pc uint32) {
+	rn := hw1 & 0xF
+	rt := hw2 >> 12
+	size := hw1 >> 5 & 3
+	signed := hw1>>8&1 != 0
+	load := hw1>>4&1 != 0
+
+	if size == 3 {
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		return
+	}
+
+	mn := "str"
+	if load {
+		mn = "ldr"
+		if signed {
+			mn += "s"
+		}
+	}
+	mn += [3]string{"b", "h", ""}[size] + d.cond()
+
+	// Preload hints use the load byte/halfword encodings with Rt = PC
+	if load && rt == 15 && size < 2 {
+		mn = "pld"
+		if signed {
+			mn = "pli"
+		}
+		rt = 16
+	}
+	dst := ""
+	if rt < 16 {
+		dst = reg(rt) + ", "
+	}
+
+	switch {
+	case rn == 15 && load:
+		off := int32(hw2 & 0xFFF)
+		if hw1&0x80 == 0 {
+			off = -off
+		}
+		inst.Text = fmt.Sprintf("%s.w %s[pc, %s]", mn, dst, simm(off))
+		literal(inst, uint32(int32((pc+4)&^3)+off))
+	case hw1&0x80 != 0:
+		inst.Text = fmt.Sprintf("%s.w %s[%s, #%d]", mn, dst, reg(rn), hw2&0xFFF)
+	case hw2&0x800 != 0:
+		off := int32(hw2 & 0xFF)
+		if hw2&0x200 == 0 {
+			off = -off
+		}
+		pre := hw2&0x400 != 0
+		wb := hw2&0x100 != 0
+		if pre && !wb && hw2&0x200 != 0 {
+			mn += "t"
+		}
+		inst.Text = fmt.Sprintf("%s %s%s", mn, dst, indexed(reg(rn), off, pre, wb))
+	case hw2&0xFC0 == 0:
+		shift := ""
+		if sh := hw2 >> 4 & 3; sh != 0 {
+			shift = fmt.Sprintf(", lsl #%d", sh)
+		}
+		inst.Text = fmt.Sprintf("%s.w %s[%s, %s%s]", mn, dst, reg(rn), reg(hw2&0xF), shift)
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		return
+	}
+
+	if load && rt == 15 {
+		if rn == 13 {
+			inst.Flow = FlowReturn
+		} else {
+			inst.Flow = FlowBranch
+			inst.Indirect = true
+		}
+	}
+}
+
+// decodeRegister decodes data processing (register) instructions, covering
+// register-controlled shifts, extends and the miscellaneous operations.
+func (d *thumbDecoder) decodeRegister(inst *Inst, hw1, hw2 uint32) {
+	op1 := hw1 >> 4 & 0xF
+	op2 := hw2 >> 4 & 0xF
+	rn := hw1 & 0xF
+	rd := hw2 >> 8 & 0xF
+	rm := hw2 & 0xF
+	c := d.cond()
+
+	switch {
+	case op1&0x8 == 0 && op2 == 0:
+		sfx := ""
+		if op1&1 != 0 {
+			sfx = "s"
+		}
+		inst.Text = fmt.Sprintf("%s%s%s.w %s, %s, %s", shiftNames[op1>>1&3], sfx, c, reg(rd), reg(rn), reg(rm))
+	case op1 < 6 && op2&0x8 != 0:
+		ops := [6]string{"sxth", "uxth", "sxtb16", "uxtb16", "sxtb", "uxtb"}
+		rot := ""
+		if r := hw2 >> 4 & 3; r != 0 {
+			rot = fmt.Sprintf(", ror #%d", r*8)
+		}
+		if rn == 15 {
+			inst.Text = fmt.Sprintf("%s%s.w %s, %s%s", ops[op1], c, reg(rd), reg(rm), rot)
+		} else {
+			mn := ops[op1][:1] + "xta" + ops[op1][3:]
+			inst.Text = fmt.Sprintf("%s%s %s, %s, %s%s", mn, c, reg(rd), reg(rn), reg(rm), rot)
+		}
+	case op1&0xC == 0x8 && op2&0xC == 0x8:
+		ops := map[uint32]string{0x4: "rev", 0x5: "rev16", 0x6: "rbit", 0x7: "revsh", 0xC: "clz"}
+		mn, ok := ops[(op1&3)<<2|op2&3]
+		if !ok {
+			inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+			return
+		}
+		w := ""
+		if mn != "rbit" && mn != "clz" {
+			w = ".w"
+		}
+		inst.Text = fmt.Sprintf("%s%s%s %s, %s", mn, c, w, reg(rd), reg(rm))
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// decodeMultiply decodes the 32-bit multiply and multiply-accumulate
+// instructions.
+func (d *thumbDecoder) decodeMultiply(inst *Inst, hw1, hw2 uint32) {
+	rn := hw1 & 0xF
+	ra := hw2 >> 12
+	rd := hw2 >> 8 & 0xF
+	rm := hw2 & 0xF
+	c := d.cond()
+
+	switch {
+	case hw1>>4&7 == 0 && hw2>>4&3 == 0 && ra == 15:
+		inst.Text = fmt.Sprintf("mul%s.w %s, %s, %s", c, reg(rd), reg(rn), reg(rm))
+	case hw1>>4&7 == 0 && hw2>>4&3 == 0:
+		inst.Text = fmt.Sprintf("mla%s %s, %s, %s, %s", c, reg(rd), reg(rn), reg(rm), reg(ra))
+	case hw1>>4&7 == 0 && hw2>>4&3 == 1:
+		inst.Text = fmt.Sprintf("mls%s %s, %s, %s, %s", c, reg(rd), reg(rn), reg(rm), reg(ra))
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// decodeLongMultiply decodes long multiply and divide instructions.
+func (d *thumbDecoder) decodeLongMultiply(inst *Inst, hw1, hw2 uint32) {
+	rn := hw1 & 0xF
+	rlo := hw2 >> 12
+	rhi := hw2 >> 8 & 0xF
+	rm := hw2 & 0xF
+	c := d.cond()
+
+	switch (hw1>>4&7)<<4 | hw2>>4&0xF {
+	case 0x00:
+		inst.Text = fmt.Sprintf("smull%s %s, %s, %s, %s", c, reg(rlo), reg(rhi), reg(rn), reg(rm))
+	case 0x1F:
+		inst.Text = fmt.Sprintf("sdiv%s %s, %s, %s", c, reg(rhi), reg(rn), reg(rm))
+	case 0x20:
+		inst.Text = fmt.Sprintf("umull%s %s, %s, %s, %s", c, reg(rlo), reg(rhi), reg(rn), reg(rm))
+	case 0x3F:
+		inst.Text = fmt.Sprintf("udiv%s %s, %s, %s", c, reg(rhi), reg(rn), reg(rm))
+	case 0x40:
+		inst.Text = fmt.Sprintf("smlal%s %s, %s, %s, %s", c, reg(rlo), reg(rhi), reg(rn), reg(rm))
+	case 0x60:
+		inst.Text = fmt.Sprintf("umlal%s %s, %s, %s, %s", c, reg(rlo), reg(rhi), reg(rn), reg(rm))
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
+
+// fpReg returns the name of a single or double precision FP register.
+func fpReg(v, bit uint32, double bool) string {
+	if double {
+		return fmt.Sprintf("d%d", bit<<4|v)
+	}
+	return fmt.Sprintf("s%d", v<<1|bit)
+}
+
+// decodeCoprocessor decodes the commonly used single and double precision
+// floating point instructions. Other coprocessor instructions are shown as
+// raw encodings.
+func (d *thumbDecoder) decodeCoprocessor(inst *Inst, hw1, hw2 uint32) {
+	c := d.cond()
+	double := hw2>>8&1 != 0
+	vd := fpReg(hw2>>12, hw1>>6&1, double)
+	i8 := (hw2 & 0xFF) << 2
+
+	if hw2&0x0E00 != 0x0A00 {
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+		return
+	}
+
+	switch {
+	case hw1&0xFFBF == 0xED2D:
+		n := hw2 & 0xFF
+		if double {
+			n /= 2
+		}
+		first := fpReg(hw2>>12, hw1>>6&1, double)
+		last := first
+		if n > 1 {
+			if double {
+				last = fmt.Sprintf("d%d", (hw1>>6&1)<<4|hw2>>12+n-1)
+			} else {
+				last = fmt.Sprintf("s%d", (hw2>>12<<1|hw1>>6&1)+n-1)
+			}
+		}
+		inst.Text = fmt.Sprintf("vpush%s {%s-%s}", c, first, last)
+	case hw1&0xFFBF == 0xECBD:
+		n := hw2 & 0xFF
+		if double {
+			n /= 2
+		}
+		first := fpReg(hw2>>12, hw1>>6&1, double)
+		last := first
+		if n > 1 {
+			if double {
+				last = fmt.Sprintf("d%d", (hw1>>6&1)<<4|hw2>>12+n-1)
+			} else {
+				last = fmt.Sprintf("s%d", (hw2>>12<<1|hw1>>6&1)+n-1)
+			}
+		}
+		inst.Text = fmt.Sprintf("vpop%s {%s-%s}", c, first, last)
+	case hw1&0xFF30 == 0xED10, hw1&0xFF30 == 0xED00:
+		mn := "vstr"
+		if hw1&0x10 != 0 {
+			mn = "vldr"
+		}
+		off := int32(i8)
+		if hw1&0x80 == 0 {
+			off = -off
+		}
+		inst.Text = fmt.Sprintf("%s%s %s, [%s, %s]", mn, c, vd, reg(hw1&0xF), simm(off))
+	case hw1&0xFFE0 == 0xEE00 && hw2&0x0F7F == 0x0A10:
+		sn := fpReg(hw1&0xF, hw2>>7&1, false)
+		if hw1&0x10 != 0 {
+			inst.Text = fmt.Sprintf("vmov%s %s, %s", c, reg(hw2>>12), sn)
+		} else {
+			inst.Text = fmt.Sprintf("vmov%s %s, %s", c, sn, reg(hw2>>12))
+		}
+	case hw1 == 0xEEF1 && hw2&0x0FFF == 0x0A10:
+		if hw2>>12 == 15 {
+			inst.Text = "vmrs" + c + " APSR_nzcv, fpscr"
+		} else {
+			inst.Text = fmt.Sprintf("vmrs%s %s, fpscr", c, reg(hw2>>12))
+		}
+	case hw1&0xFFB0 == 0xEE30, hw1&0xFFB0 == 0xEE20, hw1&0xFFB0 == 0xEE80:
+		mn := map[uint32]string{0xEE30: "vadd", 0xEE20: "vmul", 0xEE80: "vdiv"}[hw1&0xFFB0]
+		if mn == "vadd" && hw2&0x40 != 0 {
+			mn = "vsub"
+		} else if hw2&0x40 != 0 {
+			inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+			return
+		}
+		typ := ".f32"
+		if double {
+			typ = ".f64"
+		}
+		vn := fpReg(hw1&0xF, hw2>>7&1, double)
+		vm := fpReg(hw2&0xF, hw2>>5&1, double)
+		inst.Text = fmt.Sprintf("%s%s%s %s, %s, %s", mn, c, typ, vd, vn, vm)
+	default:
+		inst.Text = fmt.Sprintf(".inst.w 0x%04x%04x", hw1, hw2)
+	}
+}
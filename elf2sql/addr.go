@@ -0,0 +1,97 @@
+package elf2sql
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// AddrInfo describes the symbol, section and source line containing an
+// address
+type AddrInfo struct {
+	Addr    uint64 `json:"addr"`
+	Symbol  string `json:"symbol"`
+	Offset  uint64 `json:"offset"`
+	Section string `json:"section"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+}
+
+// Location returns the address as 'symbol+0xoffset', or an empty string if
+// no symbol contains it.
+func (a AddrInfo) Location() string {
+	switch {
+	case a.Symbol == "":
+		return ""
+	case a.Offset == 0:
+		return a.Symbol
+	}
+	return fmt.Sprintf("%s+0x%x", a.Symbol, a.Offset)
+}
+
+// Source returns the address' source location as 'file:line', or an empty
+// string when there is no line information.
+func (a AddrInfo) Source() string {
+	if a.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", a.File, a.Line)
+}
+
+// String describes the address as 'symbol+0xoffset (section) at file:line',
+// leaving out anything that isn't known.
+func (a AddrInfo) String() string {
+	s := a.Location()
+	if s == "" {
+		s = "??"
+	}
+	if a.Section != "" {
+		s += fmt.Sprintf(" (%s)", a.Section)
+	}
+	if src := a.Source(); src != "" {
+		s += " at " + src
+	}
+	return s
+}
+
+// Addr looks up the symbol, section and, when DWARF line information is
+// available, source line containing the specified address. On ARM, bit 0
+// of code addresses (the Thumb bit) is ignored.
+func (img *Image) Addr(addr uint64) AddrInfo {
+	addr = img.CodeAddr(addr)
+	info := AddrInfo{Addr: addr}
+
+	if sym, off, ok := img.SymbolAt(addr); ok {
+		info.Symbol = sym.Name
+		info.Offset = off
+	}
+	if sec := img.SectionAt(addr); sec != nil {
+		info.Section = sec.Name
+	}
+	info.File, info.Line = img.sourceLine(addr)
+
+	return info
+}
+
+// sourceLine returns the source file and line number for a code address,
+// using the DWARF line table.
+func (img *Image) sourceLine(addr uint64) (string, int) {
+	if img.dwarf == nil {
+		return "", 0
+	}
+
+	r := img.dwarf.Reader()
+	cu, e := r.SeekPC(addr)
+	if e != nil {
+		return "", 0
+	}
+	lr, e := img.dwarf.LineReader(cu)
+	if e != nil || lr == nil {
+		return "", 0
+	}
+
+	var entry dwarf.LineEntry
+	if lr.SeekPC(addr, &entry) != nil || entry.File == nil {
+		return "", 0
+	}
+	return entry.File.Name, entry.Line
+}
@@ -0,0 +1,99 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Archive file signatures
+const (
+	arMagic     = "!<arch>\n"
+	arThinMagic = "!<thin>\n"
+	arHeaderLen = 60
+)
+
+// ArchiveMember is a single file stored in an ar archive (static library)
+type ArchiveMember struct {
+	Name string
+	Data []byte
+}
+
+// IsArchive indicates if data holds an ar archive.
+func IsArchive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(arMagic)) || bytes.HasPrefix(data, []byte(arThinMagic))
+}
+
+// ReadArchive returns the members of an ar archive, supporting both the GNU
+// ('//' long name table) and BSD ('#1/len' inline name) variants. Symbol
+// index members are skipped.
+func ReadArchive(data []byte) ([]ArchiveMember, error) {
+	if bytes.HasPrefix(data, []byte(arThinMagic)) {
+		return nil, errors.New("thin archives are not supported")
+	}
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		return nil, errors.New("not an ar archive")
+	}
+
+	var members []ArchiveMember
+	var longNames []byte
+	for off := len(arMagic); off+arHeaderLen <= len(data); {
+		hdr := data[off : off+arHeaderLen]
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("bad archive member header at offset %d", off)
+		}
+		size, e := strconv.ParseUint(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if e != nil {
+			return nil, fmt.Errorf("bad archive member size at offset %d", off)
+		}
+		start := off + arHeaderLen
+		end := start + int(size)
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated archive member at offset %d", off)
+		}
+		body := data[start:end]
+
+		// Members are aligned to two bytes
+		off = end + end%2
+
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		switch {
+		case name == "/" || name == "/SYM64/" || strings.HasPrefix(name, "__.SYMDEF"):
+			// Symbol index
+			continue
+		case name == "//":
+			// GNU long name table
+			longNames = body
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD name stored at the start of the member data
+			n, e := strconv.Atoi(name[3:])
+			if e != nil || n > len(body) {
+				return nil, fmt.Errorf("bad BSD member name '%s'", name)
+			}
+			name = strings.TrimRight(string(body[:n]), "\x00")
+			body = body[n:]
+			if strings.HasPrefix(name, "__.SYMDEF") {
+				continue
+			}
+		case strings.HasPrefix(name, "/"):
+			// GNU long name, as an offset into the name table
+			n, e := strconv.Atoi(name[1:])
+			if e != nil || n >= len(longNames) {
+				return nil, fmt.Errorf("bad GNU member name '%s'", name)
+			}
+			name = string(longNames[n:])
+			if i := strings.Index(name, "/\n"); i >= 0 {
+				name = name[:i]
+			}
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		members = append(members, ArchiveMember{Name: name, Data: body})
+	}
+
+	return members, nil
+}
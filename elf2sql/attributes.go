@@ -0,0 +1,405 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const createAttributeTable string = `CREATE TABLE attributes (
+	ID      integer primary key autoincrement,
+	Section text,
+	Vendor  text,
+	Tag     integer,
+	Name    text,
+	Value   text,
+	Raw     integer,
+	Member  text
+	)`
+
+// Attribute is a decoded ELF header flag or build attribute
+type Attribute struct {
+	Section string      // 'e_flags', '.ARM.attributes' or '.riscv.attributes'
+	Vendor  string      // Attribute vendor ('aeabi', 'riscv'), empty for e_flags
+	Tag     uint64      // Attribute tag number
+	Name    string      // Tag name, as shown by readelf
+	Value   string      // Decoded value
+	Raw     interface{} // Numeric value, or nil for string attributes
+}
+
+// ARM e_flags values
+const (
+	efARMEABIMask  = 0xFF000000
+	efARMBE8       = 0x00800000
+	efARMFloatHard = 0x00000400
+	efARMFloatSoft = 0x00000200
+)
+
+// RISC-V e_flags values
+const (
+	efRISCVRVC      = 0x0001
+	efRISCVFloatABI = 0x0006
+	efRISCVRVE      = 0x0008
+	efRISCVTSO      = 0x0010
+)
+
+// armAttr describes an ARM EABI build attribute tag
+type armAttr struct {
+	name   string
+	values []string
+}
+
+// ARM EABI build attribute tags, with value names matching readelf
+var armAttrs = map[uint64]armAttr{
+	4:  {"Tag_CPU_raw_name", nil},
+	5:  {"Tag_CPU_name", nil},
+	6:  {"Tag_CPU_arch", []string{"Pre-v4", "v4", "v4T", "v5T", "v5TE", "v5TEJ", "v6", "v6KZ", "v6T2", "v6K", "v7", "v6-M", "v6S-M", "v7E-M", "v8", "v8-R", "v8-M.baseline", "v8-M.mainline", "v8.1-A", "v8.2-A", "v8.3-A", "v8.1-M.mainline", "v9"}},
+	7:  {"Tag_CPU_arch_profile", nil},
+	8:  {"Tag_ARM_ISA_use", []string{"No", "Yes"}},
+	9:  {"Tag_THUMB_ISA_use", []string{"No", "Thumb-1", "Thumb-2", "Yes"}},
+	10: {"Tag_FP_arch", []string{"No", "VFPv1", "VFPv2", "VFPv3", "VFPv3-D16", "VFPv4", "VFPv4-D16", "FP for ARMv8", "FPv5/FP-D16 for ARMv8"}},
+	11: {"Tag_WMMX_arch", []string{"No", "WMMXv1", "WMMXv2"}},
+	12: {"Tag_Advanced_SIMD_arch", []string{"No", "NEONv1", "NEONv1 with Fused-MAC", "NEON for ARMv8", "NEON for ARMv8.1"}},
+	13: {"Tag_PCS_config", []string{"None", "Bare platform", "Linux application", "Linux DSO", "PalmOS 2004", "PalmOS (reserved)", "SymbianOS 2004", "SymbianOS (reserved)"}},
+	14: {"Tag_ABI_PCS_R9_use", []string{"V6", "SB", "TLS", "Unused"}},
+	15: {"Tag_ABI_PCS_RW_data", []string{"Absolute", "PC-relative", "SB-relative", "None"}},
+	16: {"Tag_ABI_PCS_RO_data", []string{"Absolute", "PC-relative", "None"}},
+	17: {"Tag_ABI_PCS_GOT_use", []string{"None", "direct", "GOT-indirect"}},
+	18: {"Tag_ABI_PCS_wchar_t", []string{"None", "??? 1", "2", "??? 3", "4"}},
+	19: {"Tag_ABI_FP_rounding", []string{"Unused", "Needed"}},
+	20: {"Tag_ABI_FP_denormal", []string{"Unused", "Needed", "Sign only"}},
+	21: {"Tag_ABI_FP_exceptions", []string{"Unused", "Needed"}},
+	22: {"Tag_ABI_FP_user_exceptions", []string{"Unused", "Needed"}},
+	23: {"Tag_ABI_FP_number_model", []string{"Unused", "Finite", "RTABI", "IEEE 754"}},
+	24: {"Tag_ABI_align_needed", []string{"None", "8-byte", "4-byte", "??? 3"}},
+	25: {"Tag_ABI_align_preserved", []string{"None", "8-byte, except leaf SP", "8-byte", "??? 3"}},
+	26: {"Tag_ABI_enum_size", []string{"Unused", "small", "int", "forced to int"}},
+	27: {"Tag_ABI_HardFP_use", []string{"As Tag_FP_arch", "SP only", "Reserved", "Deprecated"}},
+	28: {"Tag_ABI_VFP_args", []string{"AAPCS", "VFP registers", "custom", "compatible"}},
+	29: {"Tag_ABI_WMMX_args", []string{"AAPCS", "WMMX registers", "custom"}},
+	30: {"Tag_ABI_optimization_goals", []string{"None", "Prefer Speed", "Aggressive Speed", "Prefer Size", "Aggressive Size", "Prefer Debug", "Aggressive Debug"}},
+	31: {"Tag_ABI_FP_optimization_goals", []string{"None", "Prefer Speed", "Aggressive Speed", "Prefer Size", "Aggressive Size", "Prefer Accuracy", "Aggressive Accuracy"}},
+	32: {"Tag_compatibility", nil},
+	34: {"Tag_CPU_unaligned_access", []string{"None", "v6"}},
+	36: {"Tag_FP_HP_extension", []string{"Not Allowed", "Allowed"}},
+	38: {"Tag_ABI_FP_16bit_format", []string{"None", "IEEE 754", "Alternative Format"}},
+	42: {"Tag_MPextension_use", []string{"Not Allowed", "Allowed"}},
+	44: {"Tag_DIV_use", []string{"Allowed in Thumb-ISA, v7-R or v7-M", "Not allowed", "Allowed in v7-A with integer division extension"}},
+	46: {"Tag_DSP_extension", []string{"Follow architecture", "Allowed"}},
+	48: {"Tag_MVE_arch", []string{"No MVE", "MVE Integer only", "MVE Integer and FP"}},
+	64: {"Tag_nodefaults", nil},
+	65: {"Tag_also_compatible_with", nil},
+	66: {"Tag_T2EE_use", []string{"Not Allowed", "Allowed"}},
+	67: {"Tag_conformance", nil},
+	68: {"Tag_Virtualization_use", []string{"Not Allowed", "TrustZone", "Virtualization Extensions", "TrustZone and Virtualization Extensions"}},
+	70: {"Tag_MPextension_use_legacy", []string{"Not Allowed", "Allowed"}},
+}
+
+// RISC-V build attribute tags
+var riscvAttrs = map[uint64]string{
+	4:  "Tag_RISCV_stack_align",
+	5:  "Tag_RISCV_arch",
+	6:  "Tag_RISCV_unaligned_access",
+	8:  "Tag_RISCV_priv_spec",
+	10: "Tag_RISCV_priv_spec_minor",
+	12: "Tag_RISCV_priv_spec_revision",
+}
+
+// Flags returns the ELF header e_flags field, which debug/elf doesn't expose.
+func (img *Image) Flags() uint64 {
+	off := 0x24
+	if img.File.Class == elf.ELFCLASS64 {
+		off = 0x30
+	}
+	if len(img.Raw) < off+4 {
+		return 0
+	}
+	return uint64(img.File.ByteOrder.Uint32(img.Raw[off:]))
+}
+
+// FlagAttributes decodes the architecture-specific bits of the ELF header
+// e_flags field.
+func (img *Image) FlagAttributes() []Attribute {
+	flags := img.Flags()
+	attr := func(name, value string, raw uint64) Attribute {
+		return Attribute{Section: "e_flags", Name: name, Value: value, Raw: raw}
+	}
+	attrs := []Attribute{attr("Flags", fmt.Sprintf("0x%X", flags), flags)}
+
+	switch img.File.Machine {
+	case elf.EM_ARM:
+		eabi := flags & efARMEABIMask >> 24
+		if eabi == 0 {
+			attrs = append(attrs, attr("EABI", "GNU EABI", eabi))
+		} else {
+			attrs = append(attrs, attr("EABI", fmt.Sprintf("Version%d EABI", eabi), eabi))
+		}
+		switch {
+		case flags&efARMFloatHard != 0:
+			attrs = append(attrs, attr("Float ABI", "hard-float", efARMFloatHard))
+		case flags&efARMFloatSoft != 0:
+			attrs = append(attrs, attr("Float ABI", "soft-float", efARMFloatSoft))
+		}
+		if flags&efARMBE8 != 0 {
+			attrs = append(attrs, attr("BE8", "Yes", 1))
+		}
+	case elf.EM_RISCV:
+		rvc := "No"
+		if flags&efRISCVRVC != 0 {
+			rvc = "Yes"
+		}
+		attrs = append(attrs, attr("RVC", rvc, flags&efRISCVRVC))
+		abi := []string{"soft-float", "single-float", "double-float", "quad-float"}
+		fabi := flags & efRISCVFloatABI >> 1
+		attrs = append(attrs, attr("Float ABI", abi[fabi], fabi))
+		if flags&efRISCVRVE != 0 {
+			attrs = append(attrs, attr("RVE", "Yes", 1))
+		}
+		if flags&efRISCVTSO != 0 {
+			attrs = append(attrs, attr("TSO", "Yes", 1))
+		}
+	}
+
+	return attrs
+}
+
+// FlagsString summarises the decoded e_flags in the same form as readelf,
+// such as '0x5000200, Version5 EABI, soft-float ABI'.
+func (img *Image) FlagsString() string {
+	var parts []string
+	for _, a := range img.FlagAttributes() {
+		switch a.Name {
+		case "Flags", "EABI":
+			parts = append(parts, a.Value)
+		case "Float ABI":
+			parts = append(parts, a.Value+" ABI")
+		case "RVC":
+			if a.Value == "Yes" {
+				parts = append(parts, "RVC")
+			}
+		default:
+			parts = append(parts, a.Name)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// BuildAttributes decodes the file-scope attributes in the '.ARM.attributes'
+// or '.riscv.attributes' section, if present.
+func (img *Image) BuildAttributes() ([]Attribute, error) {
+	for _, sec := range img.File.Sections {
+		if sec.Name != ".ARM.attributes" && sec.Name != ".riscv.attributes" {
+			continue
+		}
+		data, e := sec.Data()
+		if e != nil {
+			return nil, e
+		}
+		return parseAttributes(sec.Name, data, img.File.ByteOrder)
+	}
+
+	return nil, nil
+}
+
+// Attributes returns the decoded e_flags followed by the build attributes.
+func (img *Image) Attributes() []Attribute {
+	attrs := img.FlagAttributes()
+	build, _ := img.BuildAttributes()
+	return append(attrs, build...)
+}
+
+// parseAttributes decodes a build attributes section, which holds a format
+// version byte ('A') followed by one subsection per vendor. Each subsection
+// contains tagged groups of attributes for the file, or for specific
+// sections or symbols; only file attributes are decoded.
+func parseAttributes(section string, data []byte, order binary.ByteOrder) ([]Attribute, error) {
+	if len(data) == 0 || data[0] != 'A' {
+		return nil, errors.New("unknown attribute format")
+	}
+
+	var attrs []Attribute
+	for off := 1; off+4 <= len(data); {
+		size := int(order.Uint32(data[off:]))
+		if size < 4 || off+size > len(data) {
+			return attrs, errors.New("bad attribute subsection length")
+		}
+		sub := data[off+4 : off+size]
+		off += size
+
+		i := bytes.IndexByte(sub, 0)
+		if i < 0 {
+			return attrs, errors.New("bad attribute vendor name")
+		}
+		vendor := string(sub[:i])
+		sub = sub[i+1:]
+		if vendor != "aeabi" && vendor != "riscv" {
+			continue
+		}
+
+		for len(sub) >= 5 {
+			tag := sub[0]
+			n := int(order.Uint32(sub[1:]))
+			if n < 5 || n > len(sub) {
+				return attrs, errors.New("bad attribute length")
+			}
+			body := sub[5:n]
+			sub = sub[n:]
+
+			// File attributes only
+			if tag != 1 {
+				continue
+			}
+			for len(body) > 0 {
+				var a Attribute
+				a, body = decodeAttribute(vendor, body)
+				a.Section = section
+				attrs = append(attrs, a)
+			}
+		}
+	}
+
+	return attrs, nil
+}
+
+// decodeAttribute decodes a single tag/value pair, returning the remaining
+// data. Values are ULEB128 numbers or NUL-terminated strings depending on
+// the tag: even tags above 32 are numbers and odd tags are strings, with a
+// few exceptions for the low ARM tags.
+func decodeAttribute(vendor string, data []byte) (Attribute, []byte) {
+	tag, data := uleb128(data)
+	a := Attribute{Vendor: vendor, Tag: tag}
+
+	var isString bool
+	switch vendor {
+	case "aeabi":
+		isString = tag == 4 || tag == 5 || tag == 67 || (tag > 32 && tag%2 == 1)
+		if tag == 32 {
+			// Tag_compatibility: a flag followed by a vendor name
+			var flag uint64
+			flag, data = uleb128(data)
+			a.Raw = flag
+			var name string
+			name, data = ntbs(data)
+			a.Name = "Tag_compatibility"
+			a.Value = fmt.Sprintf("flag = %d, vendor = %s", flag, name)
+			return a, data
+		}
+	case "riscv":
+		isString = tag%2 == 1
+	}
+
+	if isString {
+		a.Value, data = ntbs(data)
+	} else {
+		var v uint64
+		v, data = uleb128(data)
+		a.Raw = v
+		a.Value = fmt.Sprint(v)
+	}
+
+	switch vendor {
+	case "aeabi":
+		info, ok := armAttrs[tag]
+		if !ok {
+			a.Name = fmt.Sprintf("Tag_unknown_%d", tag)
+			break
+		}
+		a.Name = info.name
+		if raw, ok := a.Raw.(uint64); ok {
+			switch {
+			case tag == 7:
+				a.Value = armProfile(raw)
+			case raw < uint64(len(info.values)):
+				a.Value = info.values[raw]
+			}
+		}
+	case "riscv":
+		if name, ok := riscvAttrs[tag]; ok {
+			a.Name = name
+		} else {
+			a.Name = fmt.Sprintf("Tag_unknown_%d", tag)
+		}
+		switch tag {
+		case 4:
+			a.Value += "-bytes"
+		case 6:
+			a.Value = "No unaligned access"
+			if a.Raw != uint64(0) {
+				a.Value = "Unaligned access"
+			}
+		}
+	}
+
+	return a, data
+}
+
+// armProfile decodes Tag_CPU_arch_profile
+func armProfile(v uint64) string {
+	switch v {
+	case 0:
+		return "None"
+	case 'A':
+		return "Application"
+	case 'R':
+		return "Realtime"
+	case 'M':
+		return "Microcontroller"
+	case 'S':
+		return "Application or Realtime"
+	}
+	return fmt.Sprintf("??? (%d)", v)
+}
+
+// uleb128 decodes an unsigned LEB128 value, returning the remaining data.
+func uleb128(data []byte) (uint64, []byte) {
+	var v uint64
+	var shift uint
+	for i, b := range data {
+		v |= uint64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return v, data[i+1:]
+		}
+	}
+	return v, nil
+}
+
+// ntbs decodes a NUL-terminated string, returning the remaining data.
+func ntbs(data []byte) (string, []byte) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return string(data), nil
+	}
+	return string(data[:i]), data[i+1:]
+}
+
+// insertAttributes populates the 'attributes' table from the supplied image.
+func insertAttributes(img *Image, member interface{}) error {
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO attributes VALUES (NULL,?,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, a := range img.Attributes() {
+		var vendor, tag interface{}
+		if a.Vendor != "" {
+			vendor = a.Vendor
+			tag = a.Tag
+		}
+		_, e = stmt.Exec(a.Section, vendor, tag, a.Name, a.Value, a.Raw, member)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
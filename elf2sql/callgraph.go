@@ -0,0 +1,430 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microbuilder/elfquery/disasm"
+)
+
+const createCallTable string = `CREATE TABLE calls (
+	ID       integer primary key autoincrement,
+	Caller   text,
+	Callee   text,
+	Address  integer,
+	Indirect integer,
+	Member   text
+	)`
+
+// Call is a single call site found by disassembling a function. Indirect
+// calls (via a register or memory) have an empty Callee. Tail calls (direct
+// branches to another function) are included as calls.
+type Call struct {
+	Caller   string
+	Callee   string
+	Address  uint64
+	Indirect bool
+
+	caller uint64 // Start of the calling function
+	callee uint64 // Call target, for direct calls
+}
+
+// CallGraph is the static call graph of an image
+type CallGraph struct {
+	Calls   []Call
+	Funcs   []string // Every function, sorted by name
+	callees map[string][]Call
+	callers map[string][]Call
+	isrs    map[string]bool
+	byAddr  map[uint64][]Call // Call sites, by the start of the calling function
+	addrs   map[string]uint64 // Function addresses, preferring global symbols
+	names   map[uint64]string // Function names, by address
+}
+
+// Vector table symbols used to identify interrupt handlers
+var vectorTables = []string{"_vector_table", "_irq_vector_table", "_sw_isr_table",
+	"__isr_vector", "__vectors", "vector_table"}
+
+// functions returns the start address of each function in the image, with
+// one entry per address when several symbols alias the same code.
+func (img *Image) functions() []uint64 {
+	seen := make(map[uint64]bool)
+	var addrs []uint64
+	for _, s := range img.Symbols {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Section == elf.SHN_UNDEF ||
+			s.Section >= elf.SHN_LORESERVE {
+			continue
+		}
+		addr := img.SymbolAddr(s)
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs
+}
+
+// Calls disassembles every function in the image and returns its call
+// sites. Images for unsupported architectures have no calls, and neither do
+// relocatable objects, since their call targets aren't resolved until link
+// time.
+func (img *Image) Calls() []Call {
+	var calls []Call
+	for _, fc := range img.functionCode() {
+		sym, start, end, insts := fc.sym, fc.start, fc.end, fc.insts
+		for _, inst := range insts {
+			call := Call{Caller: sym.Name, Address: inst.Addr, caller: start}
+			switch {
+			case inst.Flow == disasm.FlowCall && inst.Indirect:
+				call.Indirect = true
+			case inst.Flow == disasm.FlowCall && inst.HasTarget,
+				inst.Flow == disasm.FlowBranch && inst.HasTarget &&
+					(inst.Target < start || inst.Target >= end):
+				callee, off, ok := img.SymbolAt(inst.Target)
+				if !ok || off != 0 {
+					// Branches into the middle of a symbol aren't calls
+					if inst.Flow == disasm.FlowBranch {
+						continue
+					}
+					call.Callee = fmt.Sprintf("0x%X", inst.Target)
+				} else {
+					call.Callee = callee.Name
+				}
+				call.callee = inst.Target
+			default:
+				continue
+			}
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// CallGraph returns the static call graph of the image.
+func (img *Image) CallGraph() *CallGraph {
+	if img.graph != nil {
+		return img.graph
+	}
+
+	g := &CallGraph{
+		Calls:   img.Calls(),
+		callees: make(map[string][]Call),
+		callers: make(map[string][]Call),
+		isrs:    make(map[string]bool),
+		byAddr:  make(map[uint64][]Call),
+		addrs:   make(map[string]uint64),
+		names:   make(map[uint64]string),
+	}
+	for _, c := range g.Calls {
+		g.callees[c.Caller] = append(g.callees[c.Caller], c)
+		if c.Callee != "" {
+			g.callers[c.Callee] = append(g.callers[c.Callee], c)
+		}
+		g.byAddr[c.caller] = append(g.byAddr[c.caller], c)
+	}
+	for _, addr := range img.functions() {
+		if sym, _, ok := img.SymbolAt(addr); ok {
+			g.Funcs = append(g.Funcs, sym.Name)
+			g.names[addr] = sym.Name
+			if _, ok := g.addrs[sym.Name]; !ok || elf.ST_BIND(sym.Info) != elf.STB_LOCAL {
+				g.addrs[sym.Name] = addr
+			}
+		}
+	}
+	sort.Strings(g.Funcs)
+
+	// Any function whose address appears in a vector table is an ISR
+	ptrSize := uint64(4)
+	if img.File.Class == elf.ELFCLASS64 {
+		ptrSize = 8
+	}
+	for _, name := range vectorTables {
+		start, end, e := img.SymbolRange(name)
+		if e != nil {
+			continue
+		}
+		data, e := img.ReadAddr(start, end-start)
+		if e != nil {
+			continue
+		}
+		for off := uint64(0); off+ptrSize <= uint64(len(data)); off += ptrSize {
+			ptr := readUint(data[off:off+ptrSize], img.File.ByteOrder)
+			if sym, o, ok := img.SymbolAt(img.CodeAddr(ptr)); ok && o == 0 &&
+				elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+				g.isrs[sym.Name] = true
+			}
+		}
+	}
+
+	img.graph = g
+	return g
+}
+
+// Callees returns the call sites within the named function.
+func (g *CallGraph) Callees(name string) []Call {
+	return g.callees[name]
+}
+
+// Callers returns the call sites that call the named function directly.
+func (g *CallGraph) Callers(name string) []Call {
+	return g.callers[name]
+}
+
+// IsISR reports whether the named function is referenced by a vector table.
+func (g *CallGraph) IsISR(name string) bool {
+	return g.isrs[name]
+}
+
+// EntryPoints returns the functions that are never called directly, such as
+// interrupt handlers, thread entry functions and callbacks, along with any
+// function referenced by a vector table.
+func (g *CallGraph) EntryPoints() []string {
+	var entries []string
+	for _, name := range g.Funcs {
+		if len(g.callers[name]) == 0 || g.isrs[name] {
+			entries = append(entries, name)
+		}
+	}
+	return entries
+}
+
+// DOT renders the call graph in Graphviz DOT format. If roots are given,
+// only the functions reachable from them are included.
+func (g *CallGraph) DOT(roots ...string) string {
+	include := make(map[string]bool)
+	if len(roots) == 0 {
+		for _, name := range g.Funcs {
+			include[name] = true
+		}
+	} else {
+		var visit func(name string)
+		visit = func(name string) {
+			if include[name] {
+				return
+			}
+			include[name] = true
+			for _, c := range g.callees[name] {
+				if c.Callee != "" {
+					visit(c.Callee)
+				}
+			}
+		}
+		for _, r := range roots {
+			visit(r)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph callgraph {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	names := make([]string, 0, len(include))
+	for name := range include {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if g.isrs[name] {
+			sb.WriteString(fmt.Sprintf("\t%q [style=filled, fillcolor=lightblue];\n", name))
+		} else if len(g.callees[name]) == 0 {
+			sb.WriteString(fmt.Sprintf("\t%q;\n", name))
+		}
+
+		// One edge per caller/callee pair
+		edges := make(map[string]bool)
+		for _, c := range g.callees[name] {
+			callee := c.Callee
+			if c.Indirect {
+				callee = "<indirect>"
+			}
+			if edges[callee] {
+				continue
+			}
+			edges[callee] = true
+			if c.Indirect {
+				sb.WriteString(fmt.Sprintf("\t%q -> %q [style=dashed];\n", name, name+" <indirect>"))
+				sb.WriteString(fmt.Sprintf("\t%q [label=\"?\", shape=circle];\n", name+" <indirect>"))
+			} else {
+				sb.WriteString(fmt.Sprintf("\t%q -> %q;\n", name, callee))
+			}
+		}
+	}
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
+
+// StackDepth is the worst-case stack depth of a call tree
+type StackDepth struct {
+	Entry     string
+	Depth     uint64   // Worst-case stack usage in bytes
+	Path      []string // Call chain giving the worst-case depth
+	Recursive bool     // The call tree contains recursion
+	Indirect  bool     // The call tree contains indirect calls
+	Dynamic   bool     // A frame in the call tree has unbounded dynamic size
+	Missing   []string // Functions with no stack usage information
+}
+
+// StackDepth computes the worst-case stack depth of the call tree rooted at
+// entry, using frame sizes from GCC '.su' files indexed by function address.
+// The call tree is followed by address, so static functions with the same
+// name in different files are kept apart. Recursion, indirect calls,
+// dynamic frames and functions without frame information make the result a
+// lower bound, and are flagged in the result. The entry must be a function.
+func (g *CallGraph) StackDepth(entry string, frames map[uint64]StackUsage) (StackDepth, error) {
+	type result struct {
+		depth uint64
+		path  []string
+	}
+	res := StackDepth{Entry: entry}
+	start, ok := g.addrs[entry]
+	if !ok {
+		return res, fmt.Errorf("'%s' is not a function", entry)
+	}
+	memo := make(map[uint64]result)
+	active := make(map[uint64]bool)
+	missing := make(map[string]bool)
+
+	var walk func(addr uint64) result
+	walk = func(addr uint64) result {
+		if r, ok := memo[addr]; ok {
+			return r
+		}
+		name, ok := g.names[addr]
+		if !ok {
+			name = fmt.Sprintf("0x%X", addr)
+		}
+		if active[addr] {
+			res.Recursive = true
+			return result{}
+		}
+		active[addr] = true
+
+		frame, ok := frames[addr]
+		if !ok {
+			missing[name] = true
+		}
+		if strings.Contains(frame.Qualifier, "dynamic") &&
+			!strings.Contains(frame.Qualifier, "bounded") {
+			res.Dynamic = true
+		}
+
+		var worst result
+		for _, c := range g.byAddr[addr] {
+			if c.Indirect {
+				res.Indirect = true
+				continue
+			}
+			if r := walk(c.callee); r.depth > worst.depth || worst.path == nil {
+				worst = r
+			}
+		}
+		active[addr] = false
+
+		r := result{depth: frame.Bytes + worst.depth, path: append([]string{name}, worst.path...)}
+		memo[addr] = r
+		return r
+	}
+
+	r := walk(start)
+	res.Depth = r.depth
+	res.Path = r.path
+	for name := range missing {
+		res.Missing = append(res.Missing, name)
+	}
+	sort.Strings(res.Missing)
+
+	return res, nil
+}
+
+// insertCalls populates the 'calls' table from the supplied image.
+func insertCalls(img *Image, member interface{}) error {
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO calls VALUES (NULL,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, c := range img.CallGraph().Calls {
+		var callee interface{}
+		if c.Callee != "" {
+			callee = c.Callee
+		}
+		_, e = stmt.Exec(c.Caller, callee, c.Address, c.Indirect, member)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
+
+const createStackDepthTable string = `CREATE TABLE stack_depth (
+	ID        integer primary key autoincrement,
+	Entry     text,
+	ISR       integer,
+	Depth     integer,
+	Path      text,
+	Recursive integer,
+	Indirect  integer,
+	Dynamic   integer,
+	Missing   integer
+	)`
+
+// LoadStackDepths computes the worst-case stack depth of each entry point,
+// using the frame sizes in the 'stack_usage' table, and stores the results
+// in the 'stack_depth' table. Missing is the number of functions in the
+// call tree without stack usage information.
+func LoadStackDepths(entries []string) error {
+	if curImage == nil {
+		return fmt.Errorf("stack depth requires a linked image")
+	}
+	frames, e := stackFrames(curImage)
+	if e != nil {
+		return e
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no stack usage information found in '%s'", StackUsageDir)
+	}
+	g := curImage.CallGraph()
+
+	_, e = DBCon.Exec(createStackDepthTable)
+	if e != nil {
+		return e
+	}
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO stack_depth VALUES (NULL,?,?,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		d, e := g.StackDepth(entry, frames)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+		_, e = stmt.Exec(d.Entry, g.IsISR(entry), d.Depth, strings.Join(d.Path, " > "),
+			d.Recursive, d.Indirect, d.Dynamic, len(d.Missing))
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
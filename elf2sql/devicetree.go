@@ -0,0 +1,339 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DevicetreeFile is a devicetree source file, such as the 'zephyr.dts'
+// written by a Zephyr build, to load into the 'devicetree' and
+// 'devicetree_props' tables. The tables are empty if not set.
+var DevicetreeFile string
+
+// DevicetreeHeader is the 'devicetree_generated.h' header from a Zephyr
+// build, used to find the ordinal of each node. Optional.
+var DevicetreeHeader string
+
+const createDevicetreeTable string = `CREATE TABLE devicetree (
+	ID         integer primary key autoincrement,
+	Path       text,
+	Name       text,
+	Labels     text,
+	Compatible text,
+	Status     text,
+	Address    integer,
+	Size       integer,
+	Ordinal    integer
+	)`
+
+const createDevicetreePropTable string = `CREATE TABLE devicetree_props (
+	ID    integer primary key autoincrement,
+	Path  text,
+	Name  text,
+	Value text
+	)`
+
+// DTProperty is a property of a devicetree node, with its value as written
+// in the source, such as '< 0x86000 0x1000 >' or '"okay"'
+type DTProperty struct {
+	Name  string
+	Value string
+}
+
+// DTNode is a devicetree node. Address and Size are from the first entry of
+// the 'reg' property, with the address translated to the CPU's address
+// space using the 'ranges' of the parent buses.
+type DTNode struct {
+	Path       string
+	Name       string
+	Labels     []string
+	Props      []DTProperty
+	Address    uint64
+	Size       uint64
+	HasReg     bool
+	parent     *DTNode
+	propValues map[string][]string
+}
+
+// Compatible returns the node's compatible strings.
+func (n *DTNode) Compatible() []string {
+	return n.propValues["compatible"]
+}
+
+// Status returns the node's status, which defaults to 'okay'.
+func (n *DTNode) Status() string {
+	if s := n.propValues["status"]; len(s) > 0 {
+		return s[0]
+	}
+	return "okay"
+}
+
+// cells returns the values of a property made of '< >' cell lists.
+func (n *DTNode) cells(name string) ([]uint64, bool) {
+	vals, ok := n.propValues[name]
+	if !ok {
+		return nil, false
+	}
+	var cells []uint64
+	for _, v := range vals {
+		c, e := strconv.ParseUint(strings.TrimPrefix(v, "0x"), hexBase(v), 64)
+		if e != nil {
+			return nil, false
+		}
+		cells = append(cells, c)
+	}
+	return cells, true
+}
+
+// hexBase returns 16 for '0x' prefixed values and 10 otherwise.
+func hexBase(s string) int {
+	if strings.HasPrefix(s, "0x") {
+		return 16
+	}
+	return 10
+}
+
+// addressCells returns the '#address-cells' and '#size-cells' used by the
+// children of the node.
+func (n *DTNode) addressCells() (int, int) {
+	ac, sc := 2, 1
+	if c, ok := n.cells("#address-cells"); ok && len(c) == 1 {
+		ac = int(c[0])
+	}
+	if c, ok := n.cells("#size-cells"); ok && len(c) == 1 {
+		sc = int(c[0])
+	}
+	return ac, sc
+}
+
+// joinCells combines big-endian cells into a single value.
+func joinCells(c []uint64) uint64 {
+	var v uint64
+	for _, x := range c {
+		v = v<<32 | x
+	}
+	return v
+}
+
+// translate maps an address on the node's child bus to the CPU's address
+// space, using the 'ranges' of the node and its ancestors. Nodes without
+// 'ranges', or with an empty one, are treated as a one-to-one mapping.
+func (n *DTNode) translate(addr uint64) uint64 {
+	for bus := n; bus != nil && bus.parent != nil; bus = bus.parent {
+		ranges, ok := bus.cells("ranges")
+		if !ok || len(ranges) == 0 {
+			continue
+		}
+		cac, csc := bus.addressCells()
+		pac, _ := bus.parent.addressCells()
+		width := cac + pac + csc
+		for i := 0; i+width <= len(ranges); i += width {
+			child := joinCells(ranges[i : i+cac])
+			parent := joinCells(ranges[i+cac : i+cac+pac])
+			length := joinCells(ranges[i+cac+pac : i+width])
+			if addr >= child && addr-child < length {
+				addr = addr - child + parent
+				break
+			}
+		}
+	}
+	return addr
+}
+
+// dtToken matches the tokens of devicetree source: strings, cell lists,
+// byte strings, punctuation and names
+var dtToken = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|<[^>]*>|\[[^\]]*\]|[{};=,]|[^\s{};=,"<\[]+`)
+
+// dtValues splits a property value into strings and cells, so that
+// '"a", "b"' gives [a b] and '< 0x1 0x2 >' gives [0x1 0x2].
+func dtValues(tokens []string) []string {
+	var vals []string
+	for _, t := range tokens {
+		switch {
+		case strings.HasPrefix(t, "\""):
+			if s, e := strconv.Unquote(t); e == nil {
+				vals = append(vals, s)
+			} else {
+				vals = append(vals, strings.Trim(t, "\""))
+			}
+		case strings.HasPrefix(t, "<"):
+			vals = append(vals, strings.Fields(strings.Trim(t, "<>"))...)
+		case t != ",":
+			vals = append(vals, t)
+		}
+	}
+	return vals
+}
+
+// ReadDevicetree parses a devicetree source file, returning its nodes in
+// the order they appear. Include directives and overlays aren't supported,
+// so the source should be the final, merged tree.
+func ReadDevicetree(filename string) ([]*DTNode, error) {
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, e
+	}
+
+	// Remove comments and directives
+	src := regexp.MustCompile(`(?s)/\*.*?\*/`).ReplaceAllString(string(b), "")
+	src = regexp.MustCompile(`(?m)//.*$`).ReplaceAllString(src, "")
+	src = regexp.MustCompile(`/dts-v1/\s*;|/memreserve/[^;]*;`).ReplaceAllString(src, "")
+	tokens := dtToken.FindAllString(src, -1)
+
+	var nodes []*DTNode
+	var cur *DTNode
+	var labels []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t == "}":
+			// '};' closes the current node
+			if cur != nil {
+				cur = cur.parent
+			}
+			if i+1 < len(tokens) && tokens[i+1] == ";" {
+				i++
+			}
+		case t == ";":
+		case strings.HasSuffix(t, ":"):
+			labels = append(labels, strings.TrimSuffix(t, ":"))
+		case i+1 < len(tokens) && tokens[i+1] == "{":
+			// A node
+			n := &DTNode{Name: t, Labels: labels, parent: cur,
+				propValues: make(map[string][]string)}
+			switch {
+			case cur == nil || t == "/":
+				n.Path = "/"
+				if t != "/" {
+					n.Path = "/" + t
+				}
+			case cur.Path == "/":
+				n.Path = "/" + t
+			default:
+				n.Path = cur.Path + "/" + t
+			}
+			nodes = append(nodes, n)
+			cur = n
+			labels = nil
+			i++
+		case cur != nil:
+			// A property, either 'name;' or 'name = value;'
+			p := DTProperty{Name: t}
+			var value []string
+			if i+1 < len(tokens) && tokens[i+1] == "=" {
+				j := i + 2
+				for j < len(tokens) && tokens[j] != ";" {
+					value = append(value, tokens[j])
+					j++
+				}
+				i = j
+				p.Value = strings.Join(value, " ")
+				p.Value = strings.ReplaceAll(p.Value, " , ", ", ")
+			}
+			cur.Props = append(cur.Props, p)
+			cur.propValues[p.Name] = dtValues(value)
+			labels = nil
+		}
+	}
+
+	// Find the address and size from 'reg', using the parent's cell sizes
+	for _, n := range nodes {
+		if n.parent == nil {
+			continue
+		}
+		reg, ok := n.cells("reg")
+		ac, sc := n.parent.addressCells()
+		if !ok || ac == 0 || len(reg) < ac+sc {
+			continue
+		}
+		n.Address = n.parent.translate(joinCells(reg[:ac]))
+		n.Size = joinCells(reg[ac : ac+sc])
+		n.HasReg = true
+	}
+
+	return nodes, nil
+}
+
+// dtOrdinal matches the node ordering comment at the start of the
+// generated devicetree header, as in ' *   12  /soc/uart@40086000'
+var dtOrdinal = regexp.MustCompile(`^\s*\*\s+(\d+)\s+(/\S*)$`)
+
+// ReadDevicetreeOrdinals reads the ordinal of each node path from a
+// 'devicetree_generated.h' header.
+func ReadDevicetreeOrdinals(filename string) (map[string]int, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	ords := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			break
+		}
+		if m := dtOrdinal.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			ords[m[2]] = n
+		}
+	}
+
+	return ords, scanner.Err()
+}
+
+// insertDevicetree populates the 'devicetree' and 'devicetree_props' tables
+// from DevicetreeFile.
+func insertDevicetree() error {
+	if DevicetreeFile == "" {
+		return nil
+	}
+	nodes, e := ReadDevicetree(DevicetreeFile)
+	if e != nil {
+		return e
+	}
+	var ords map[string]int
+	if DevicetreeHeader != "" {
+		ords, e = ReadDevicetreeOrdinals(DevicetreeHeader)
+		if e != nil {
+			return e
+		}
+	}
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	for _, n := range nodes {
+		var addr, size, ord interface{}
+		if n.HasReg {
+			addr, size = int64(n.Address), int64(n.Size)
+		}
+		if o, ok := ords[n.Path]; ok {
+			ord = o
+		}
+		_, e = tx.Exec(`INSERT INTO devicetree VALUES (NULL,?,?,?,?,?,?,?,?)`,
+			n.Path, n.Name, nullable(strings.Join(n.Labels, ", ")),
+			nullable(strings.Join(n.Compatible(), ", ")), n.Status(), addr, size, ord)
+		if e != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %s", n.Path, e)
+		}
+		for _, p := range n.Props {
+			_, e = tx.Exec(`INSERT INTO devicetree_props VALUES (NULL,?,?,?)`,
+				n.Path, p.Name, nullable(p.Value))
+			if e != nil {
+				tx.Rollback()
+				return e
+			}
+		}
+	}
+
+	return tx.Commit()
+}
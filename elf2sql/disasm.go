@@ -0,0 +1,284 @@
+package elf2sql
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microbuilder/elfquery/disasm"
+)
+
+// mappingSymbol is an ARM ELF mapping symbol ($a, $t, $d or $x), which marks
+// the start of a region of ARM code, Thumb code, data or A64 code.
+type mappingSymbol struct {
+	addr uint64
+	kind byte
+}
+
+// mappingSymbols returns the image's mapping symbols, sorted by address.
+func (img *Image) mappingSymbols() []mappingSymbol {
+	if img.mapping != nil {
+		return img.mapping
+	}
+
+	img.mapping = []mappingSymbol{}
+	for _, s := range img.Symbols {
+		if len(s.Name) < 2 || s.Name[0] != '$' || s.Section == elf.SHN_UNDEF ||
+			s.Section >= elf.SHN_LORESERVE {
+			continue
+		}
+		if k := s.Name[1]; (k == 'a' || k == 't' || k == 'd' || k == 'x') &&
+			(len(s.Name) == 2 || s.Name[2] == '.') {
+			img.mapping = append(img.mapping, mappingSymbol{addr: s.Value, kind: k})
+		}
+	}
+	sort.SliceStable(img.mapping, func(i, j int) bool {
+		return img.mapping[i].addr < img.mapping[j].addr
+	})
+
+	return img.mapping
+}
+
+// mappingAt returns the kind of the mapping symbol covering addr, and the
+// address of the next mapping symbol (or 0 if there isn't one).
+func (img *Image) mappingAt(addr uint64) (byte, uint64) {
+	m := img.mappingSymbols()
+	i := sort.Search(len(m), func(i int) bool { return m[i].addr > addr })
+	var kind byte
+	var next uint64
+	if i > 0 {
+		kind = m[i-1].kind
+	}
+	if i < len(m) {
+		next = m[i].addr
+	}
+	return kind, next
+}
+
+// Arch returns the instruction set used by code at the specified address.
+func (img *Image) Arch(addr uint64) disasm.Arch {
+	switch img.File.Machine {
+	case elf.EM_ARM:
+		kind, _ := img.mappingAt(addr)
+		if kind == 'a' {
+			return disasm.ArchARM
+		}
+		if kind == 0 {
+			if sym, _, ok := img.SymbolAt(addr); ok && sym.Value&1 == 0 &&
+				elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+				return disasm.ArchARM
+			}
+		}
+		return disasm.ArchThumb
+	case elf.EM_AARCH64:
+		return disasm.ArchARM64
+	case elf.EM_386:
+		return disasm.ArchX86
+	case elf.EM_X86_64:
+		return disasm.ArchX86_64
+	case elf.EM_RISCV:
+		if img.File.Class == elf.ELFCLASS32 {
+			return disasm.ArchRISCV32
+		}
+		return disasm.ArchRISCV
+	}
+
+	return disasm.ArchUnknown
+}
+
+// Disassemble decodes the instructions between start and end. ARM mapping
+// symbols are used to switch between ARM and Thumb code, and to show
+// literal pools as data.
+func (img *Image) Disassemble(start, end uint64) ([]disasm.Inst, error) {
+	if end <= start {
+		return nil, fmt.Errorf("empty address range 0x%X-0x%X", start, end)
+	}
+	if img.Arch(start) == disasm.ArchUnknown {
+		return nil, fmt.Errorf("disassembly not supported for %s", img.File.Machine)
+	}
+	code, e := img.ReadAddr(start, end-start)
+	if e != nil {
+		return nil, e
+	}
+
+	var insts []disasm.Inst
+	order := img.File.ByteOrder
+	for off := uint64(0); off < uint64(len(code)); {
+		addr := start + off
+		kind, next := img.mappingAt(addr)
+		if next == 0 || next > end {
+			next = end
+		}
+
+		// Decode up to the next mapping symbol with a fresh decoder
+		region := code[off : next-start]
+		if kind == 'd' {
+			for o := 0; o < len(region); {
+				inst := disasm.Data(region[o:], addr+uint64(o), order)
+				insts = append(insts, inst)
+				o += len(inst.Bytes)
+			}
+		} else {
+			insts = append(insts, disasm.Disassemble(img.Arch(addr), order, region, addr)...)
+		}
+		off = next - start
+	}
+
+	return insts, nil
+}
+
+// FormatInst renders an instruction, annotating branch targets and
+// PC-relative data references with symbol names.
+func (img *Image) FormatInst(inst disasm.Inst) string {
+	text := inst.Text
+	if inst.HasTarget {
+		if sym, off, ok := img.SymbolAt(inst.Target); ok {
+			text += " <" + symOffset(sym.Name, off) + ">"
+		}
+	}
+
+	if inst.HasLiteral {
+		if v, e := img.ReadAddr(inst.Literal, 4); e == nil && strings.HasPrefix(text, "ldr") {
+			val := uint64(img.File.ByteOrder.Uint32(v))
+			text += fmt.Sprintf("\t; [0x%x] = 0x%x", inst.Literal, val)
+			if sym, off, ok := img.SymbolAt(img.CodeAddr(val)); ok {
+				text += " <" + symOffset(sym.Name, off) + ">"
+			} else if str, ok := img.readString(val); ok && len(str) > 1 {
+				if len(str) > 32 {
+					str = str[:32] + "..."
+				}
+				text += " " + strconv.Quote(str)
+			}
+		} else if sym, off, ok := img.SymbolAt(inst.Literal); ok {
+			text += "\t; <" + symOffset(sym.Name, off) + ">"
+		}
+	}
+
+	return text
+}
+
+// symOffset renders a symbol name with an optional offset, as 'name+0x1c'.
+func symOffset(name string, off uint64) string {
+	if off == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s+0x%x", name, off)
+}
+
+// Listing returns an objdump-style disassembly listing of the specified
+// address range, with a label at the start of each symbol.
+func (img *Image) Listing(start, end uint64) (string, error) {
+	insts, e := img.Disassemble(start, end)
+	if e != nil {
+		return "", e
+	}
+
+	var sb strings.Builder
+	thumb := img.Arch(start) == disasm.ArchThumb
+	for i, inst := range insts {
+		if sym, off, ok := img.SymbolAt(inst.Addr); ok && (off == 0 || i == 0) {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(fmt.Sprintf("%08x <%s>:\n", inst.Addr, symOffset(sym.Name, off)))
+		}
+
+		// Thumb encodings are shown as halfwords, like objdump
+		var raw string
+		if strings.HasPrefix(inst.Text, ".word") {
+			raw = fmt.Sprintf("%08x", img.File.ByteOrder.Uint32(inst.Bytes))
+		} else if thumb && len(inst.Bytes)%2 == 0 {
+			for j := 0; j < len(inst.Bytes); j += 2 {
+				raw += fmt.Sprintf("%04x ", img.File.ByteOrder.Uint16(inst.Bytes[j:]))
+			}
+		} else {
+			for _, b := range inst.Bytes {
+				raw += fmt.Sprintf("%02x ", b)
+			}
+		}
+		sb.WriteString(fmt.Sprintf("  %8x:\t%-15s\t%s\n", inst.Addr, raw, img.FormatInst(inst)))
+	}
+
+	return sb.String(), nil
+}
+
+// SymbolRange returns the address range covered by the named symbol.
+func (img *Image) SymbolRange(name string) (uint64, uint64, error) {
+	sym, ok := img.Lookup(name)
+	if !ok {
+		return 0, 0, fmt.Errorf("symbol '%s' not found", name)
+	}
+
+	return img.SymbolExtent(sym)
+}
+
+// SymbolExtent returns the address range covered by the supplied symbol.
+func (img *Image) SymbolExtent(sym elf.Symbol) (uint64, uint64, error) {
+	name := sym.Name
+	start := img.SymbolAddr(sym)
+	if sym.Size > 0 {
+		return start, start + sym.Size, nil
+	}
+
+	// Symbols without a size (typically assembly functions) extend to the
+	// next symbol in the same section, or the end of the section
+	sec := img.SectionAt(start)
+	if sec == nil {
+		return 0, 0, fmt.Errorf("symbol '%s' has no size", name)
+	}
+	end := sec.Addr + sec.Size
+	for _, s := range img.Symbols {
+		addr := img.SymbolAddr(s)
+		if s.Section == sym.Section && addr > start && addr < end &&
+			!strings.HasPrefix(s.Name, "$") && s.Name != "" {
+			end = addr
+		}
+	}
+
+	return start, end, nil
+}
+
+// funcCode is the disassembled body of a function
+type funcCode struct {
+	sym        elf.Symbol
+	start, end uint64
+	insts      []disasm.Inst
+}
+
+// functionCode disassembles every function in the image, in address order.
+// The result is kept, so the call graph, code hashes and peripheral
+// references share a single decoding pass. Relocatable objects have no
+// code, since their branch targets aren't resolved until link time, and
+// neither do images for unsupported architectures.
+func (img *Image) functionCode() []funcCode {
+	if img.code != nil {
+		return img.code
+	}
+
+	img.code = []funcCode{}
+	if img.File.Type == elf.ET_REL {
+		return img.code
+	}
+	for _, addr := range img.functions() {
+		if img.Arch(addr) == disasm.ArchUnknown {
+			return img.code
+		}
+		sym, _, ok := img.SymbolAt(addr)
+		if !ok {
+			continue
+		}
+		start, end, e := img.SymbolExtent(sym)
+		if e != nil {
+			continue
+		}
+		insts, e := img.Disassemble(start, end)
+		if e != nil {
+			continue
+		}
+		img.code = append(img.code, funcCode{sym, start, end, insts})
+	}
+
+	return img.code
+}
@@ -0,0 +1,355 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microbuilder/elfquery/disasm"
+)
+
+const createCodeHashTable string = `CREATE TABLE code_hashes (
+	ID      integer primary key autoincrement,
+	Name    text,
+	Address integer,
+	Size    integer,
+	Section text,
+	Hash    text,
+	Member  text
+	)`
+
+// CodeHash is the hash of a function body. Functions with the same hash
+// have the same code, apart from the addresses of the functions and data
+// they refer to outside themselves, which are compared by symbol name.
+type CodeHash struct {
+	Name    string
+	Address uint64
+	Size    uint64
+	Section string
+	Hash    string
+	Member  string
+
+	tokens  []uint64 // Instructions without constants, or bytes when code can't be decoded
+	shingle int      // Number of tokens compared at a time by Similarity
+	counts  map[uint64]int
+}
+
+// NearDuplicate is a pair of functions with similar code
+type NearDuplicate struct {
+	A, B       CodeHash
+	Similarity float64
+}
+
+// hexNumber matches the addresses and offsets in disassembled instructions
+var hexNumber = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+
+// immediate matches the immediates, offsets and addresses in disassembled
+// instructions, but not the numbers in register names
+var immediate = regexp.MustCompile(`-?\b(0x[0-9a-fA-F]+|[0-9]+)\b`)
+
+// CodeHashes hashes the body of every function in the image. Only the first
+// of several symbols for the same code is included.
+//
+// In linked images, the code is disassembled and calls, branches and data
+// references to other symbols, including the addresses in literal pools,
+// are hashed by name instead of encoding, so that identical functions at
+// different addresses have the same hash. In
+// relocatable objects, the symbols named by each relocation are hashed
+// along with the code.
+func (img *Image) CodeHashes() []CodeHash {
+	var hashes []CodeHash
+	type funcKey struct {
+		section elf.SectionIndex
+		addr    uint64
+	}
+	seen := make(map[funcKey]bool)
+	data := make(map[elf.SectionIndex][]byte)
+	relocs := make(map[elf.SectionIndex]map[uint64]string)
+
+	// Reuse the code disassembled for the call graph
+	funcs := make(map[uint64]funcCode)
+	for _, fc := range img.functionCode() {
+		funcs[fc.start] = fc
+	}
+
+	for _, s := range img.Symbols {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Size == 0 ||
+			s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE ||
+			int(s.Section) >= len(img.File.Sections) {
+			continue
+		}
+		sec := img.File.Sections[s.Section]
+		start := img.SymbolAddr(s)
+		key := funcKey{s.Section, start}
+		if sec.Type == elf.SHT_NOBITS || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if _, ok := data[s.Section]; !ok {
+			data[s.Section], _ = sec.Data()
+			relocs[s.Section] = img.relocations(s.Section)
+		}
+		code := data[s.Section]
+		off := start - sec.Addr
+		if off+s.Size > uint64(len(code)) {
+			continue
+		}
+
+		h := CodeHash{Name: s.Name, Address: start, Size: s.Size, Section: sec.Name}
+		sum := sha256.New()
+		var insts []disasm.Inst
+		if fc, ok := funcs[start]; ok && fc.end == start+s.Size {
+			insts = fc.insts
+		} else if img.File.Type != elf.ET_REL && img.Arch(start) != disasm.ArchUnknown {
+			insts, _ = img.Disassemble(start, start+s.Size)
+		}
+		if insts != nil {
+			h.shingle = 2
+			for _, inst := range insts {
+				t := img.instToken(inst, start, start+s.Size)
+				sum.Write(t)
+				if _, ok := img.instRef(inst, start, start+s.Size); !ok {
+					// Copies of a function that differ only in constants
+					// and offsets are similar
+					t = []byte(immediate.ReplaceAllString(inst.Text, "?"))
+				}
+				h.tokens = append(h.tokens, tokenHash(t))
+			}
+		} else {
+			h.shingle = 4
+			for i, b := range code[off : off+s.Size] {
+				t := []byte{b}
+				if name, ok := relocs[s.Section][off+uint64(i)]; ok {
+					t = append([]byte(name), b)
+				}
+				sum.Write(t)
+				h.tokens = append(h.tokens, tokenHash(t))
+			}
+		}
+		h.Hash = hex.EncodeToString(sum.Sum(nil))
+		hashes = append(hashes, h)
+	}
+
+	return hashes
+}
+
+// instToken returns the bytes hashed for an instruction in a function
+// between start and end. Instructions that refer to addresses outside the
+// function are replaced by their text, with the addresses replaced by the
+// name of the symbol they refer to.
+func (img *Image) instToken(inst disasm.Inst, start, end uint64) []byte {
+	ref, ok := img.instRef(inst, start, end)
+	if !ok {
+		return append([]byte{byte(len(inst.Bytes))}, inst.Bytes...)
+	}
+
+	name := fmt.Sprintf("0x%x", ref)
+	if sym, off, ok := img.SymbolAt(ref); ok {
+		name = symOffset(sym.Name, off)
+	}
+	return []byte(hexNumber.ReplaceAllString(inst.Text, "?") + " <" + name + ">")
+}
+
+// instRef returns the address outside the function between start and end
+// that an instruction branches to or loads from, or that a literal pool
+// word points to, if any.
+func (img *Image) instRef(inst disasm.Inst, start, end uint64) (uint64, bool) {
+	switch {
+	case inst.HasTarget && (inst.Target < start || inst.Target >= end):
+		return inst.Target, true
+	case inst.HasLiteral && (inst.Literal < start || inst.Literal >= end):
+		return inst.Literal, true
+	case strings.HasPrefix(inst.Text, ".word") && img.ptrSize() == 4:
+		addr := img.CodeAddr(uint64(img.File.ByteOrder.Uint32(inst.Bytes)))
+		if _, _, ok := img.SymbolAt(addr); ok && (addr < start || addr >= end) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// relocations returns the name of the symbol referred to by each relocation
+// in the specified section, indexed by offset. Section symbols are named
+// after their section.
+func (img *Image) relocations(index elf.SectionIndex) map[uint64]string {
+	relocs := make(map[uint64]string)
+	if img.File.Type != elf.ET_REL {
+		return relocs
+	}
+
+	is64 := img.File.Class == elf.ELFCLASS64
+	order := img.File.ByteOrder
+	for _, rs := range img.File.Sections {
+		if (rs.Type != elf.SHT_REL && rs.Type != elf.SHT_RELA) || elf.SectionIndex(rs.Info) != index {
+			continue
+		}
+		d, e := rs.Data()
+		if e != nil {
+			continue
+		}
+
+		var size int
+		switch {
+		case is64 && rs.Type == elf.SHT_RELA:
+			size = 24
+		case is64:
+			size = 16
+		case rs.Type == elf.SHT_RELA:
+			size = 12
+		default:
+			size = 8
+		}
+		for i := 0; i+size <= len(d); i += size {
+			var off, sym uint64
+			if is64 {
+				off = order.Uint64(d[i:])
+				sym = order.Uint64(d[i+8:]) >> 32
+			} else {
+				off = uint64(order.Uint32(d[i:]))
+				sym = uint64(order.Uint32(d[i+4:]) >> 8)
+			}
+			// Symbols() leaves out the null symbol at index 0
+			if sym == 0 || sym > uint64(len(img.Symbols)) {
+				continue
+			}
+			s := img.Symbols[sym-1]
+			name := s.Name
+			if elf.ST_TYPE(s.Info) == elf.STT_SECTION && int(s.Section) < len(img.File.Sections) {
+				name = img.File.Sections[s.Section].Name
+			}
+			relocs[off] = name
+		}
+	}
+
+	return relocs
+}
+
+// tokenHash returns a 64-bit hash of a token.
+func tokenHash(t []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(t)
+	return h.Sum64()
+}
+
+// shingles returns the number of times each run of consecutive tokens
+// appears in the function.
+func (h *CodeHash) shingles() map[uint64]int {
+	if h.counts != nil {
+		return h.counts
+	}
+
+	// Functions shorter than a shingle are compared as a whole
+	k := h.shingle
+	if k > len(h.tokens) {
+		k = len(h.tokens)
+	}
+	h.counts = make(map[uint64]int)
+	var buf [8]byte
+	for i := 0; i+k <= len(h.tokens); i++ {
+		f := fnv.New64a()
+		for _, t := range h.tokens[i : i+k] {
+			binary.LittleEndian.PutUint64(buf[:], t)
+			f.Write(buf[:])
+		}
+		h.counts[f.Sum64()]++
+	}
+	return h.counts
+}
+
+// Similarity compares the code of two functions, returning the proportion
+// of short runs of instructions (or bytes) they have in common. The result
+// is 1 for identical code and 0 when nothing is shared.
+func Similarity(a, b *CodeHash) float64 {
+	if a.shingle != b.shingle {
+		return 0
+	}
+	ca, cb := a.shingles(), b.shingles()
+
+	common, total := 0, 0
+	for k, n := range ca {
+		m := cb[k]
+		if n < m {
+			common += n
+			total += m
+		} else {
+			common += m
+			total += n
+		}
+	}
+	for k, m := range cb {
+		if _, ok := ca[k]; !ok {
+			total += m
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(common) / float64(total)
+}
+
+// NearDuplicates returns the pairs of functions with different names and
+// code whose Similarity is at least threshold, but which aren't identical.
+// Pairs are sorted by decreasing similarity, then by decreasing size.
+func NearDuplicates(hashes []CodeHash, threshold float64) []NearDuplicate {
+	funcs := make([]*CodeHash, len(hashes))
+	for i := range hashes {
+		funcs[i] = &hashes[i]
+	}
+	sort.SliceStable(funcs, func(i, j int) bool {
+		return len(funcs[i].tokens) < len(funcs[j].tokens)
+	})
+
+	var pairs []NearDuplicate
+	for i, a := range funcs {
+		for _, b := range funcs[i+1:] {
+			// The similarity can't exceed the ratio of the lengths
+			if float64(len(a.tokens)) < threshold*float64(len(b.tokens)) {
+				break
+			}
+			if a.Hash == b.Hash || a.Name == b.Name {
+				continue
+			}
+			if s := Similarity(a, b); s >= threshold {
+				pairs = append(pairs, NearDuplicate{*a, *b, s})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Similarity != pairs[j].Similarity {
+			return pairs[i].Similarity > pairs[j].Similarity
+		}
+		return pairs[i].A.Size+pairs[i].B.Size > pairs[j].A.Size+pairs[j].B.Size
+	})
+	return pairs
+}
+
+// insertCodeHashes populates the 'code_hashes' table from the supplied image.
+func insertCodeHashes(img *Image, member interface{}) error {
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO code_hashes VALUES (NULL,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, h := range img.CodeHashes() {
+		_, e = stmt.Exec(h.Name, h.Address, h.Size, h.Section, h.Hash, member)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
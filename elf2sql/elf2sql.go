@@ -1,7 +1,9 @@
 package elf2sql // github.com/microbuilder/elfquery/elf2sql
 
 import (
+	"bytes"
 	"database/sql"
+	"debug/elf"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -15,6 +17,11 @@ import (
 var (
 	// DBCon provides access to the shared database
 	DBCon *sql.DB
+
+	// DynamicSymbols loads the dynamic symbol table (.dynsym) into the
+	// 'symbols' table, as 'nm -D' does. Otherwise the full symbol table
+	// (.symtab) is loaded, or the dynamic symbols if the file was stripped.
+	DynamicSymbols bool
 )
 
 // DisplayFormat is used with the Render function to determine how rows are
@@ -144,7 +151,7 @@ type Symbol struct {
 }
 
 const createSectionTable string = `CREATE TABLE sections (
-	ID          integer primary key,
+	ID          integer,
 	Name        text,
 	Type        text,
 	Flags       text,
@@ -154,7 +161,13 @@ const createSectionTable string = `CREATE TABLE sections (
 	LinkedIndex integer,
 	Info        integer,
 	Alignment   integer,
-	EntrySize   integer
+	EntrySize   integer,
+	IsAlloc     integer,
+	IsExec      integer,
+	IsWrite     integer,
+	CRC32       integer,
+	SHA256      text,
+	Member      text
 	)`
 
 const createSymbolTable string = `CREATE TABLE symbols (
@@ -166,20 +179,26 @@ const createSymbolTable string = `CREATE TABLE symbols (
 	Visibility   text,
 	SectionIndex integer,
 	Name         text,
-	Section      text
+	Section      text,
+	Member       text
 	)`
 
 // InitDB loads the specified ELF file into a memory-based SQLite database.
-// The database contains two tables: 'sections' and 'symbols'.
+// The database contains eight tables: 'sections', 'symbols', 'strings',
+// 'calls', 'layout_gaps', 'code_hashes', 'stack_usage' and 'attributes',
+// along with the 'zephyr_*', 'map_*', 'kconfig', 'devicetree', 'peripherals'
+// and 'registers' tables when the corresponding options are set. Static
+// libraries (ar archives) are also accepted, in which case every ELF object
+// in the archive is loaded and the 'Member' column of each table holds the
+// object's name.
 func InitDB(filename string) error {
 	f, e := ioutil.ReadFile(filename)
-	_elf, e := elf_reader.ParseELFFile(f)
 	if e != nil {
 		return e
 	}
 
 	// Open a new SQLite database in memory
-	db, e := sql.Open("sqlite3", ":memory:")
+	db, e := sql.Open(sqlDriver, ":memory:")
 	if e != nil {
 		return e
 	}
@@ -196,6 +215,158 @@ func InitDB(filename string) error {
 	if e != nil {
 		return e
 	}
+
+	// Create strings table
+	_, e = DBCon.Exec(createStringTable)
+	if e != nil {
+		return e
+	}
+
+	// Create calls table
+	_, e = DBCon.Exec(createCallTable)
+	if e != nil {
+		return e
+	}
+
+	// Create layout gaps table
+	_, e = DBCon.Exec(createLayoutGapTable)
+	if e != nil {
+		return e
+	}
+
+	// Create code hashes table
+	_, e = DBCon.Exec(createCodeHashTable)
+	if e != nil {
+		return e
+	}
+
+	// Create stack usage table
+	_, e = DBCon.Exec(createStackUsageTable)
+	if e != nil {
+		return e
+	}
+
+	// Create attributes table
+	_, e = DBCon.Exec(createAttributeTable)
+	if e != nil {
+		return e
+	}
+
+	// Create the Zephyr, build output, SVD and MCUboot tables
+	for _, t := range []string{createZephyrDeviceTable, createZephyrInitTable,
+		createZephyrStackTable, createZephyrObjectTable, createZephyrShellTable,
+		createZephyrLogTable, createMapMemoryTable, createMapInputTable,
+		createKconfigTable, createDevicetreeTable, createDevicetreePropTable,
+		createPeripheralTable, createRegisterTable, createPeripheralRefTable,
+		createMCUbootTable, createMCUbootTLVTable} {
+		_, e = DBCon.Exec(t)
+		if e != nil {
+			return e
+		}
+	}
+
+	curImage = nil
+	curMembers = nil
+	curDevice = nil
+	if IsArchive(f) {
+		// Load each ELF object in the archive, skipping anything else
+		members, e := ReadArchive(f)
+		if e != nil {
+			return e
+		}
+		for _, m := range members {
+			if !bytes.HasPrefix(m.Data, []byte(elf.ELFMAG)) {
+				continue
+			}
+			img, e := loadELF(m.Data, m.Name)
+			if e != nil {
+				return fmt.Errorf("%s: %s", m.Name, e)
+			}
+			curMembers = append(curMembers, img)
+		}
+	} else {
+		// Keep the image around for the custom SQL functions
+		curImage, e = loadELF(f, "")
+		if e != nil {
+			return e
+		}
+	}
+
+	// Load GCC stack usage files, if requested
+	e = insertStackUsage(curImage)
+	if e != nil {
+		return e
+	}
+
+	// Load the linker map, if requested
+	var lmap *LinkerMap
+	if MapFile != "" {
+		lmap, e = ReadLinkerMap(MapFile)
+		if e != nil {
+			return e
+		}
+	}
+	e = insertLinkerMap(lmap)
+	if e != nil {
+		return e
+	}
+
+	// Load the Kconfig and devicetree, if requested
+	e = insertKconfig()
+	if e != nil {
+		return e
+	}
+	e = insertDevicetree()
+	if e != nil {
+		return e
+	}
+
+	// Decode the Zephyr kernel's iterable sections, if requested
+	e = insertZephyr(curImage, lmap)
+	if e != nil {
+		return e
+	}
+
+	// Load the SVD peripherals and find references to them, if requested
+	e = insertSVD(curImage)
+	if e != nil {
+		return e
+	}
+
+	// Load the signed MCUboot image, if requested
+	e = insertMCUboot()
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// loadELF adds the sections, symbols, strings, calls, layout gaps, code
+// hashes and attributes of an ELF file to the database. member is the archive
+// member name, or empty for a standalone file.
+func loadELF(f []byte, member string) (*Image, error) {
+	var mem interface{}
+	if member != "" {
+		mem = member
+	}
+
+	_elf, e := elf_reader.ParseELFFile(f)
+	if e != nil {
+		return nil, e
+	}
+	img, e := NewImage(f)
+	if e != nil {
+		return nil, e
+	}
+
+	hasSymtab := false
+	for _, s := range img.File.Sections {
+		if s.Type == elf.SHT_SYMTAB {
+			hasSymtab = true
+		}
+	}
+
 	// Iterate over sections to populate the database
 	count := _elf.GetSectionCount()
 	for i := uint16(0); i < count; i++ {
@@ -213,8 +384,8 @@ func InitDB(filename string) error {
 		_sec := Section{
 			id:          int(i),
 			name:        _name,
-			stype:       fmt.Sprint(header.GetType()),
-			flags:       fmt.Sprint(header.GetFlags()),
+			stype:       SectionTypeName(elf.SectionType(header.GetType()), img.File.Machine),
+			flags:       SectionFlagsString(sectionFlags(img, i)),
 			address:     header.GetVirtualAddress(),
 			offset:      header.GetFileOffset(),
 			size:        header.GetSize(),
@@ -224,27 +395,37 @@ func InitDB(filename string) error {
 			entrysize:   header.GetEntrySize(),
 		}
 
+		// Hash the section's contents
+		var crc, sha interface{}
+		if int(i) < len(img.File.Sections) {
+			crc, sha = sectionHashes(img.File.Sections[i])
+		}
+
 		// Insert the section into the DB
 		tx, e := DBCon.Begin()
 		if e != nil {
-			return e
+			return nil, e
 		}
-		stmt, e := tx.Prepare(`INSERT INTO sections VALUES (?,?,?,?,?,?,?,?,?,?,?)`)
+		stmt, e := tx.Prepare(`INSERT INTO sections VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`)
 		if e != nil {
-			return e
+			return nil, e
 		}
 		defer stmt.Close()
 		_, e = stmt.Exec(_sec.id, _sec.name, _sec.stype, _sec.flags,
 			_sec.address, _sec.offset, _sec.size, _sec.linkedindex, _sec.info,
-			_sec.alignment, _sec.entrysize)
+			_sec.alignment, _sec.entrysize, header.GetFlags().Allocated(),
+			header.GetFlags().Executable(), header.GetFlags().Writable(), crc, sha, mem)
 		if e != nil {
-			return e
+			return nil, e
 		}
 		tx.Commit()
 
-		// Get Symbols
+		// Get Symbols. The dynamic symbols repeat entries of the full symbol
+		// table, so they're only used when the file has been stripped, or
+		// when requested.
 		symbols, names, e := _elf.GetSymbols(i)
-		if e == nil {
+		dynamic := elf.SectionType(header.GetType()) == elf.SHT_DYNSYM
+		if e == nil && dynamic == (DynamicSymbols || !hasSymtab) {
 			for j := range symbols {
 				// Assign symbol values
 				_sym := Symbol{
@@ -272,27 +453,65 @@ func InitDB(filename string) error {
 				// Insert symbol into table
 				tx, e := DBCon.Begin()
 				if e != nil {
-					return e
+					return nil, e
 				}
-				stmt, e := tx.Prepare(`INSERT INTO symbols VALUES (NULL,?,?,?,?,?,?,?,?)`)
+				stmt, e := tx.Prepare(`INSERT INTO symbols VALUES (NULL,?,?,?,?,?,?,?,?,?)`)
 				if e != nil {
-					return e
+					return nil, e
 				}
 				defer stmt.Close()
 				_, e = stmt.Exec(_sym.value, _sym.size,
 					symTypeStrings[_sym.symboltype],
 					symBindingStrings[_sym.binding],
 					symVisStrings[_sym.visibility],
-					_sym.sectionindex, _sym.name, _sym.section)
+					_sym.sectionindex, _sym.name, _sym.section, mem)
 				if e != nil {
-					return e
+					return nil, e
 				}
 				tx.Commit()
 			}
 		}
 	}
 
-	return nil
+	// Extract printable strings from the memory image
+	e = insertStrings(img, mem)
+	if e != nil {
+		return nil, e
+	}
+
+	// Build the static call graph from the disassembled functions
+	e = insertCalls(img, mem)
+	if e != nil {
+		return nil, e
+	}
+
+	// Find the gaps, overlaps and aliases between symbols
+	e = insertLayoutGaps(img, mem)
+	if e != nil {
+		return nil, e
+	}
+
+	// Hash the function bodies to find duplicated code
+	e = insertCodeHashes(img, mem)
+	if e != nil {
+		return nil, e
+	}
+
+	// Decode the header flags and build attributes
+	e = insertAttributes(img, mem)
+	if e != nil {
+		return nil, e
+	}
+
+	return img, nil
+}
+
+// sectionFlags returns the raw flags of the section at index i.
+func sectionFlags(img *Image, i uint16) elf.SectionFlag {
+	if int(i) >= len(img.File.Sections) {
+		return 0
+	}
+	return img.File.Sections[i].Flags
 }
 
 // CloseDB closes the shared database connection
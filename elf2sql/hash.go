@@ -0,0 +1,71 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+)
+
+// sectionHashes returns the CRC32 and SHA-256 of a section's contents, or
+// NULLs for sections with no contents in the file.
+func sectionHashes(s *elf.Section) (interface{}, interface{}) {
+	if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL {
+		return nil, nil
+	}
+	data, e := s.Data()
+	if e != nil {
+		return nil, nil
+	}
+	sum := sha256.Sum256(data)
+	return crc32.ChecksumIEEE(data), hex.EncodeToString(sum[:])
+}
+
+// BlockHash is the checksum of a block of loadable content
+type BlockHash struct {
+	Address uint64
+	Size    uint64
+	Section string
+	CRC32   uint32
+	SHA256  string
+}
+
+// ContentHash identifies the loadable contents of an image, independent of
+// its debug information, symbols and container format.
+type ContentHash struct {
+	Blocks []BlockHash
+	Size   uint64
+	SHA256 string
+}
+
+// HashMemImage hashes the contents of a memory image, along with the load
+// address of each contiguous run of bytes. Adjacent blocks are hashed as
+// one, so the same firmware gives the same hash whether it's read from an
+// ELF file or a HEX, S-record or binary file.
+func HashMemImage(m *MemImage) *ContentHash {
+	h := &ContentHash{}
+	sum := sha256.New()
+	var run uint64
+	for i, b := range m.Blocks {
+		sb := sha256.Sum256(b.Data)
+		h.Blocks = append(h.Blocks, BlockHash{b.Address, uint64(len(b.Data)), b.Section,
+			crc32.ChecksumIEEE(b.Data), hex.EncodeToString(sb[:])})
+		h.Size += uint64(len(b.Data))
+
+		// Write the address and length of each run before its data
+		if i == 0 || b.Address != m.Blocks[i-1].End() {
+			run = uint64(len(b.Data))
+			for j := i + 1; j < len(m.Blocks) && m.Blocks[j].Address == m.Blocks[j-1].End(); j++ {
+				run += uint64(len(m.Blocks[j].Data))
+			}
+			var hdr [16]byte
+			binary.LittleEndian.PutUint64(hdr[:], b.Address)
+			binary.LittleEndian.PutUint64(hdr[8:], run)
+			sum.Write(hdr[:])
+		}
+		sum.Write(b.Data)
+	}
+	h.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return h
+}
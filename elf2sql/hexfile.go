@@ -0,0 +1,254 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Bytes per data record, as written by objcopy. Records end with CRLF, as
+// objcopy writes them.
+const hexRecordLen = 16
+
+// writeHexRecord writes an Intel HEX record, with its checksum.
+func writeHexRecord(w *bufio.Writer, typ byte, addr uint16, data []byte) {
+	rec := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, -sum)
+	fmt.Fprintf(w, ":%s\r\n", strings.ToUpper(hex.EncodeToString(rec)))
+}
+
+// WriteIHex writes the image as an Intel HEX file. Like objcopy, addresses
+// below 1MB use extended segment address records and higher addresses use
+// extended linear address records, and records don't cross a 64K boundary.
+func WriteIHex(w io.Writer, m *MemImage) error {
+	out := bufio.NewWriter(w)
+	var base uint64
+	for _, b := range m.Blocks {
+		if b.End() > 0x100000000 {
+			return fmt.Errorf("address 0x%X is out of range for Intel HEX", b.End()-1)
+		}
+		for off := uint64(0); off < uint64(len(b.Data)); {
+			addr := b.Address + off
+			if addr > base+0xFFFF {
+				if addr <= 0xFFFFF {
+					base = addr & 0xF0000
+					writeHexRecord(out, 2, 0, []byte{byte(base >> 12), 0})
+				} else {
+					base = addr & 0xFFFF0000
+					writeHexRecord(out, 4, 0, []byte{byte(base >> 24), byte(base >> 16)})
+				}
+			}
+			n := uint64(len(b.Data)) - off
+			if n > hexRecordLen {
+				n = hexRecordLen
+			}
+			if rel := addr - base; rel+n > 0x10000 {
+				n = 0x10000 - rel
+			}
+			writeHexRecord(out, 0, uint16(addr-base), b.Data[off:off+n])
+			off += n
+		}
+	}
+
+	if m.HasEntry && m.Entry != 0 {
+		e := m.Entry
+		if e <= 0xFFFFF {
+			cs := (e & 0xF0000) >> 4
+			writeHexRecord(out, 3, 0, []byte{byte(cs >> 8), byte(cs), byte(e >> 8), byte(e)})
+		} else {
+			writeHexRecord(out, 5, 0, []byte{byte(e >> 24), byte(e >> 16), byte(e >> 8), byte(e)})
+		}
+	}
+	writeHexRecord(out, 1, 0, nil)
+
+	return out.Flush()
+}
+
+// parseRecord decodes the hex digits of a record, checking its length.
+func parseRecord(line string) ([]byte, error) {
+	rec, e := hex.DecodeString(line)
+	if e != nil {
+		return nil, fmt.Errorf("invalid hex digits")
+	}
+	if len(rec) == 0 {
+		return nil, fmt.Errorf("empty record")
+	}
+	return rec, nil
+}
+
+// ReadIHex parses an Intel HEX file.
+func ReadIHex(data []byte) (*MemImage, error) {
+	m := &MemImage{}
+	var base uint64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return nil, fmt.Errorf("line %d: missing ':'", n)
+		}
+		rec, e := parseRecord(line[1:])
+		if e == nil && (len(rec) < 5 || int(rec[0])+5 != len(rec)) {
+			e = fmt.Errorf("invalid length")
+		}
+		if e != nil {
+			return nil, fmt.Errorf("line %d: %s", n, e)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", n)
+		}
+
+		addr := uint64(rec[1])<<8 | uint64(rec[2])
+		payload := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0:
+			m.add(base+addr, payload)
+		case 1:
+			return m, m.finish()
+		case 2, 4:
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("line %d: invalid address record", n)
+			}
+			base = uint64(payload[0])<<8 | uint64(payload[1])
+			if rec[3] == 2 {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case 3, 5:
+			if len(payload) != 4 {
+				return nil, fmt.Errorf("line %d: invalid start address record", n)
+			}
+			if rec[3] == 3 {
+				m.Entry = (uint64(payload[0])<<8|uint64(payload[1]))<<4 +
+					(uint64(payload[2])<<8 | uint64(payload[3]))
+			} else {
+				m.Entry = readUint(payload, binary.BigEndian)
+			}
+			m.HasEntry = true
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %02X", n, rec[3])
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		return nil, e
+	}
+
+	return m, m.finish()
+}
+
+// writeSRecord writes a Motorola S-record, with its checksum.
+func writeSRecord(w *bufio.Writer, typ int, addrLen int, addr uint64, data []byte) {
+	rec := []byte{byte(addrLen + len(data) + 1)}
+	for i := addrLen - 1; i >= 0; i-- {
+		rec = append(rec, byte(addr>>(8*i)))
+	}
+	rec = append(rec, data...)
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, ^sum)
+	fmt.Fprintf(w, "S%d%s\r\n", typ, strings.ToUpper(hex.EncodeToString(rec)))
+}
+
+// WriteSRec writes the image as a Motorola S-record file, with header in
+// the S0 record. Like objcopy, the smallest of S1, S2 or S3 records that
+// can hold every address is used.
+func WriteSRec(w io.Writer, m *MemImage, header string) error {
+	out := bufio.NewWriter(w)
+	typ := 1
+	for _, b := range m.Blocks {
+		switch last := b.End() - 1; {
+		case len(b.Data) == 0:
+		case last > 0xFFFFFFFF:
+			return fmt.Errorf("address 0x%X is out of range for S-records", last)
+		case last > 0xFFFFFF:
+			typ = 3
+		case last > 0xFFFF && typ < 2:
+			typ = 2
+		}
+	}
+
+	if len(header) > 40 {
+		header = header[:40]
+	}
+	writeSRecord(out, 0, 2, 0, []byte(header))
+	for _, b := range m.Blocks {
+		for off := 0; off < len(b.Data); off += hexRecordLen {
+			end := off + hexRecordLen
+			if end > len(b.Data) {
+				end = len(b.Data)
+			}
+			writeSRecord(out, typ, typ+1, b.Address+uint64(off), b.Data[off:end])
+		}
+	}
+	writeSRecord(out, 10-typ, typ+1, m.Entry, nil)
+
+	return out.Flush()
+}
+
+// ReadSRec parses a Motorola S-record file.
+func ReadSRec(data []byte) (*MemImage, error) {
+	m := &MemImage{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] != 'S' || line[1] < '0' || line[1] > '9' {
+			return nil, fmt.Errorf("line %d: not an S-record", n)
+		}
+		typ := int(line[1] - '0')
+		rec, e := parseRecord(line[2:])
+		if e == nil && int(rec[0])+1 != len(rec) {
+			e = fmt.Errorf("invalid length")
+		}
+		if e != nil {
+			return nil, fmt.Errorf("line %d: %s", n, e)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0xFF {
+			return nil, fmt.Errorf("line %d: checksum mismatch", n)
+		}
+
+		// Data and start address records have 2, 3 or 4 address bytes
+		addrLen := map[int]int{1: 2, 2: 3, 3: 4, 7: 4, 8: 3, 9: 2}[typ]
+		if addrLen == 0 {
+			// S0 header and S5/S6 record counts
+			continue
+		}
+		if len(rec) < addrLen+2 {
+			return nil, fmt.Errorf("line %d: invalid length", n)
+		}
+		addr := readUint(rec[1:1+addrLen], binary.BigEndian)
+		if typ <= 3 {
+			m.add(addr, rec[1+addrLen:len(rec)-1])
+		} else {
+			m.Entry, m.HasEntry = addr, true
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		return nil, e
+	}
+
+	return m, m.finish()
+}
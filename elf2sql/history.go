@@ -0,0 +1,453 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"database/sql"
+	"debug/elf"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The history database records the section and symbol sizes of each build,
+// keyed by git commit, so that sizes can be tracked over time.
+const createHistoryTables string = `
+	CREATE TABLE IF NOT EXISTS builds (
+	ID        integer primary key autoincrement,
+	CommitID  text not null,
+	Branch    text,
+	Timestamp text,
+	File      text,
+	Flash     integer,
+	RAM       integer
+	);
+	CREATE TABLE IF NOT EXISTS history_sections (
+	Build     integer references builds(ID),
+	Name      text,
+	Address   integer,
+	Size      integer,
+	Flash     integer,
+	RAM       integer
+	);
+	CREATE TABLE IF NOT EXISTS history_symbols (
+	Build     integer references builds(ID),
+	Name      text,
+	Type      text,
+	Section   text,
+	Size      integer
+	);
+	CREATE INDEX IF NOT EXISTS history_sections_name ON history_sections (Name, Build);
+	CREATE INDEX IF NOT EXISTS history_symbols_name ON history_symbols (Name, Build);`
+
+// History is a persistent database of build sizes
+type History struct {
+	DB *sql.DB
+}
+
+// HistoryBuild is a build recorded in the history database
+type HistoryBuild struct {
+	ID        int64
+	Commit    string
+	Branch    string
+	Timestamp string
+	File      string
+	Flash     int64
+	RAM       int64
+}
+
+// HistoryPoint is the size of a section, symbol or memory type in a build
+type HistoryPoint struct {
+	Build HistoryBuild
+	Size  int64
+}
+
+// OpenHistory opens an existing history database.
+func OpenHistory(filename string) (*History, error) {
+	if _, e := os.Stat(filename); e != nil {
+		return nil, e
+	}
+	db, e := sql.Open("sqlite3", filename)
+	if e != nil {
+		return nil, e
+	}
+	return &History{DB: db}, nil
+}
+
+// CreateHistory opens the history database, creating it if necessary.
+func CreateHistory(filename string) (*History, error) {
+	db, e := sql.Open("sqlite3", filename)
+	if e != nil {
+		return nil, e
+	}
+	_, e = db.Exec(createHistoryTables)
+	if e != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %s", filename, e)
+	}
+	return &History{DB: db}, nil
+}
+
+// Close closes the history database.
+func (h *History) Close() {
+	h.DB.Close()
+}
+
+// MemoryUsage returns the flash and RAM used by the image. Flash holds
+// every allocated section with contents in the file, including the initial
+// values of data, and RAM holds every writable allocated section.
+func (img *Image) MemoryUsage() (flash uint64, ram uint64) {
+	for _, s := range img.File.Sections {
+		f, r := sectionMemory(s)
+		if f {
+			flash += s.Size
+		}
+		if r {
+			ram += s.Size
+		}
+	}
+	return flash, ram
+}
+
+// sectionMemory indicates if a section uses flash, RAM or both.
+func sectionMemory(s *elf.Section) (flash bool, ram bool) {
+	if s.Flags&elf.SHF_ALLOC == 0 || s.Size == 0 {
+		return false, false
+	}
+	return s.Type != elf.SHT_NOBITS, s.Flags&elf.SHF_WRITE != 0
+}
+
+// Add records the sizes of the image's sections and symbols for a commit.
+// A build already recorded for the same commit and branch is replaced.
+func (h *History) Add(img *Image, b HistoryBuild) (int64, error) {
+	if b.Timestamp == "" {
+		b.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	flash, ram := img.MemoryUsage()
+
+	tx, e := h.DB.Begin()
+	if e != nil {
+		return 0, e
+	}
+	defer tx.Rollback()
+
+	// Replace an existing build in place, so it keeps its position
+	var id int64
+	e = tx.QueryRow(`SELECT ID FROM builds WHERE CommitID = ? AND IFNULL(Branch, '') = ?`,
+		b.Commit, b.Branch).Scan(&id)
+	switch {
+	case e == sql.ErrNoRows:
+		res, e := tx.Exec(`INSERT INTO builds VALUES (NULL,?,?,?,?,?,?)`, b.Commit,
+			nullable(b.Branch), b.Timestamp, b.File, int64(flash), int64(ram))
+		if e != nil {
+			return 0, e
+		}
+		id, _ = res.LastInsertId()
+	case e != nil:
+		return 0, e
+	default:
+		_, e = tx.Exec(`UPDATE builds SET Timestamp = ?, File = ?, Flash = ?, RAM = ? WHERE ID = ?`,
+			b.Timestamp, b.File, int64(flash), int64(ram), id)
+		if e == nil {
+			_, e = tx.Exec(`DELETE FROM history_sections WHERE Build = ?`, id)
+		}
+		if e == nil {
+			_, e = tx.Exec(`DELETE FROM history_symbols WHERE Build = ?`, id)
+		}
+		if e != nil {
+			return 0, e
+		}
+	}
+
+	for _, s := range img.File.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		f, r := sectionMemory(s)
+		_, e = tx.Exec(`INSERT INTO history_sections VALUES (?,?,?,?,?,?)`, id, s.Name,
+			int64(s.Addr), int64(s.Size), f, r)
+		if e != nil {
+			return 0, e
+		}
+	}
+
+	for _, s := range img.Symbols {
+		t := elf.ST_TYPE(s.Info)
+		if s.Name == "" || s.Size == 0 || (t != elf.STT_FUNC && t != elf.STT_OBJECT) ||
+			s.Section == elf.SHN_UNDEF || int(s.Section) >= len(img.File.Sections) {
+			continue
+		}
+		typ := "data"
+		if t == elf.STT_FUNC {
+			typ = "code"
+		}
+		_, e = tx.Exec(`INSERT INTO history_symbols VALUES (?,?,?,?,?)`, id, s.Name, typ,
+			img.File.Sections[s.Section].Name, int64(s.Size))
+		if e != nil {
+			return 0, e
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// Builds returns the recorded builds in the order they were added, limited
+// to a branch if one is given.
+func (h *History) Builds(branch string) ([]HistoryBuild, error) {
+	rows, e := h.DB.Query(`SELECT ID, CommitID, IFNULL(Branch, ''), Timestamp, File, Flash, RAM
+		FROM builds WHERE ? = '' OR Branch = ? ORDER BY ID`, branch, branch)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	var builds []HistoryBuild
+	for rows.Next() {
+		var b HistoryBuild
+		e = rows.Scan(&b.ID, &b.Commit, &b.Branch, &b.Timestamp, &b.File, &b.Flash, &b.RAM)
+		if e != nil {
+			return nil, e
+		}
+		builds = append(builds, b)
+	}
+	return builds, rows.Err()
+}
+
+// Kind returns the kind of a name in the history: 'flash' or 'ram'
+// for the memory totals, or else 'section' or 'symbol', with sections
+// taking priority.
+func (h *History) Kind(name string) (string, error) {
+	for _, k := range []string{"section", "symbol"} {
+		var n int
+		e := h.DB.QueryRow(`SELECT COUNT(*) FROM history_`+k+`s WHERE Name = ?`, name).Scan(&n)
+		if e != nil {
+			return "", e
+		}
+		if n > 0 {
+			return k, nil
+		}
+	}
+	if name == "flash" || name == "ram" {
+		return name, nil
+	}
+	return "", fmt.Errorf("no section or symbol named '%s' in the history", name)
+}
+
+// Trend returns the size of a section or symbol (kind 'section' or
+// 'symbol'), or the flash or RAM usage (kind 'flash' or 'ram'), in each
+// build. Symbols with the same name, such as static functions in different
+// files, are added together, and builds without the name have a size of
+// zero.
+func (h *History) Trend(kind string, name string, branch string) ([]HistoryPoint, error) {
+	builds, e := h.Builds(branch)
+	if e != nil {
+		return nil, e
+	}
+	var points []HistoryPoint
+	switch kind {
+	case "flash", "ram":
+		for _, b := range builds {
+			size := b.Flash
+			if kind == "ram" {
+				size = b.RAM
+			}
+			points = append(points, HistoryPoint{b, size})
+		}
+		return points, nil
+	case "section", "symbol":
+	default:
+		return nil, fmt.Errorf("invalid trend kind: %s", kind)
+	}
+
+	sizes := make(map[int64]int64)
+	rows, e := h.DB.Query(`SELECT Build, SUM(Size) FROM history_`+kind+`s WHERE Name = ?
+		GROUP BY Build`, name)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var id, size int64
+		if e = rows.Scan(&id, &size); e != nil {
+			return nil, e
+		}
+		sizes[id] = size
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no %s named '%s' in the history", kind, name)
+	}
+
+	for _, b := range builds {
+		points = append(points, HistoryPoint{b, sizes[b.ID]})
+	}
+	return points, rows.Err()
+}
+
+// SymbolChange is the change in size of a symbol between two builds
+type SymbolChange struct {
+	Name    string
+	Section string
+	Before  int64
+	After   int64
+}
+
+// SymbolChanges returns the symbols that changed size between two builds,
+// largest growth first. They're limited to a section or symbol (kind
+// 'section' or 'symbol'), or to the sections in flash or RAM (kind 'flash'
+// or 'ram').
+func (h *History) SymbolChanges(prev, cur int64, kind, name string) ([]SymbolChange, error) {
+	var filter string
+	args := []interface{}{prev, cur}
+	switch kind {
+	case "section":
+		filter, args = `Section = ?3`, append(args, name)
+	case "symbol":
+		filter, args = `Name = ?3`, append(args, name)
+	case "flash":
+		filter = `Section IN (SELECT Name FROM history_sections WHERE Build IN (?1, ?2) AND Flash)`
+	case "ram":
+		filter = `Section IN (SELECT Name FROM history_sections WHERE Build IN (?1, ?2) AND RAM)`
+	default:
+		return nil, fmt.Errorf("invalid kind: %s", kind)
+	}
+	rows, e := h.DB.Query(`SELECT Name, Section,
+		SUM(CASE WHEN Build = ?1 THEN Size ELSE 0 END) AS Before,
+		SUM(CASE WHEN Build = ?2 THEN Size ELSE 0 END) AS After
+		FROM history_symbols WHERE Build IN (?1, ?2) AND `+filter+`
+		GROUP BY Name, Section HAVING Before != After
+		ORDER BY After - Before DESC, Name`, args...)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	var changes []SymbolChange
+	for rows.Next() {
+		var c SymbolChange
+		if e = rows.Scan(&c.Name, &c.Section, &c.Before, &c.After); e != nil {
+			return nil, e
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// Blame is the build where a section, symbol or memory type crossed a size
+// limit, first grew by more than a threshold, or had its largest increase,
+// along with the symbols responsible.
+type Blame struct {
+	Kind    string
+	Name    string
+	Reason  string
+	Prev    HistoryBuild
+	Build   HistoryBuild
+	Before  int64
+	After   int64
+	Symbols []SymbolChange
+}
+
+// Blame finds the build where the named section, symbol, or 'flash' or
+// 'ram' usage first grew above limit (when not zero), or else first grew by
+// more than the threshold (when not nil), or else had its largest increase,
+// and lists the symbols that changed size in that build.
+func (h *History) Blame(name string, branch string, limit int64, t *Threshold) (*Blame, error) {
+	kind, e := h.Kind(name)
+	if e != nil {
+		return nil, e
+	}
+	points, e := h.Trend(kind, name, branch)
+	if e != nil {
+		return nil, e
+	}
+
+	found := -1
+	var reason string
+	for i := 1; i < len(points) && found < 0; i++ {
+		prev, cur := points[i-1].Size, points[i].Size
+		switch {
+		case limit > 0:
+			if prev <= limit && cur > limit {
+				found, reason = i, fmt.Sprintf("first exceeded %d bytes", limit)
+			}
+		case t != nil:
+			if t.Exceeded(prev, cur) {
+				found, reason = i, fmt.Sprintf("first grew by more than %s", t)
+			}
+		}
+	}
+	if limit == 0 && t == nil {
+		var largest int64
+		for i := 1; i < len(points); i++ {
+			if d := points[i].Size - points[i-1].Size; d > largest {
+				found, largest = i, d
+			}
+		}
+		reason = "largest increase"
+	}
+	if found < 0 {
+		switch {
+		case limit > 0 && len(points) > 0 && points[0].Size > limit:
+			return nil, fmt.Errorf("'%s' already exceeded %d bytes in the first recorded build",
+				name, limit)
+		case limit > 0:
+			return nil, fmt.Errorf("'%s' never exceeded %d bytes", name, limit)
+		case t != nil:
+			return nil, fmt.Errorf("'%s' never grew by more than %s", name, t)
+		}
+		return nil, fmt.Errorf("'%s' never grew", name)
+	}
+
+	b := &Blame{Kind: kind, Name: name, Reason: reason, Prev: points[found-1].Build,
+		Build: points[found].Build, Before: points[found-1].Size, After: points[found].Size}
+	b.Symbols, e = h.SymbolChanges(b.Prev.ID, b.Build.ID, kind, name)
+	if e != nil {
+		return nil, e
+	}
+	return b, nil
+}
+
+// Threshold is the growth in size between builds that counts as a
+// regression, either in bytes or as a percentage of the previous size.
+type Threshold struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseThreshold parses a threshold in bytes ('512') or percent ('1%').
+func ParseThreshold(s string) (Threshold, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		p, e := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if e != nil || p < 0 {
+			return Threshold{}, fmt.Errorf("invalid threshold: %s", s)
+		}
+		return Threshold{Percent: p}, nil
+	}
+	n, e := strconv.ParseInt(s, 0, 64)
+	if e != nil || n < 0 {
+		return Threshold{}, fmt.Errorf("invalid threshold: %s", s)
+	}
+	return Threshold{Bytes: n}, nil
+}
+
+func (t Threshold) String() string {
+	if t.Percent != 0 || t.Bytes == 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatInt(t.Bytes, 10)
+}
+
+// Exceeded indicates if growing from prev to cur is a regression. Any
+// growth counts for a zero threshold.
+func (t Threshold) Exceeded(prev, cur int64) bool {
+	delta := cur - prev
+	if delta <= 0 {
+		return false
+	}
+	if t.Percent != 0 {
+		return prev == 0 || float64(delta)*100 > t.Percent*float64(prev)
+	}
+	return delta > t.Bytes
+}
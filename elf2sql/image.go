@@ -0,0 +1,238 @@
+package elf2sql
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Image provides direct access to the section contents, symbols and debug
+// information of an ELF file, for analysis that needs more than the symbolic
+// data held in the SQL tables.
+type Image struct {
+	File    *elf.File
+	Symbols []elf.Symbol
+	Raw     []byte
+
+	dwarf     *dwarf.Data
+	variables map[string]dwarf.Offset
+	addrIndex []elf.Symbol
+	maxSize   uint64
+	mapping   []mappingSymbol
+	graph     *CallGraph
+	code      []funcCode
+	funcNames map[string][]fileSymbol
+}
+
+// curImage is the image loaded by InitDB, used by the custom SQL functions
+var curImage *Image
+
+// curMembers holds the images of the ELF objects in an archive loaded by
+// InitDB, which has no single image
+var curMembers []*Image
+
+// CurrentImage returns the image loaded by the last call to InitDB, or nil.
+func CurrentImage() *Image {
+	return curImage
+}
+
+// OpenImage reads and parses the specified ELF file.
+func OpenImage(filename string) (*Image, error) {
+	f, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, e
+	}
+
+	return NewImage(f)
+}
+
+// NewImage parses an ELF file that has already been read into memory.
+func NewImage(data []byte) (*Image, error) {
+	_elf, e := elf.NewFile(bytes.NewReader(data))
+	if e != nil {
+		return nil, e
+	}
+
+	syms, e := _elf.Symbols()
+	if e != nil && e != elf.ErrNoSymbols {
+		return nil, e
+	}
+
+	img := &Image{
+		File:    _elf,
+		Symbols: syms,
+		Raw:     data,
+	}
+
+	// Debug information is optional, and only used when present
+	img.dwarf, _ = _elf.DWARF()
+
+	return img, nil
+}
+
+// HasDWARF indicates if the image contains usable debug information.
+func (img *Image) HasDWARF() bool {
+	return img.dwarf != nil
+}
+
+// IsThumb indicates if code addresses may have bit 0 set to flag Thumb mode.
+func (img *Image) IsThumb() bool {
+	return img.File.Machine == elf.EM_ARM
+}
+
+// SymbolAddr returns the address of the first byte of the supplied symbol,
+// dropping the Thumb bit from ARM function addresses.
+func (img *Image) SymbolAddr(sym elf.Symbol) uint64 {
+	if img.IsThumb() && elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+		return sym.Value &^ 1
+	}
+	return sym.Value
+}
+
+// Lookup returns the first defined symbol matching the specified name.
+func (img *Image) Lookup(name string) (elf.Symbol, bool) {
+	for _, s := range img.Symbols {
+		if s.Name == name && s.Section != elf.SHN_UNDEF {
+			return s, true
+		}
+	}
+	return elf.Symbol{}, false
+}
+
+// SectionAt returns the allocated section containing the specified address,
+// or nil if the address isn't part of the memory image. Every section of a
+// relocatable object starts at address 0, so symbols in those are read by
+// section instead (see ReadSymbol).
+func (img *Image) SectionAt(addr uint64) *elf.Section {
+	for _, s := range img.File.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Size == 0 {
+			continue
+		}
+		if addr >= s.Addr && addr < s.Addr+s.Size {
+			return s
+		}
+	}
+	return nil
+}
+
+// ReadAddr returns 'size' bytes of initial memory content starting at the
+// specified address. Content in NOBITS sections (bss, noinit) reads as zero.
+func (img *Image) ReadAddr(addr uint64, size uint64) ([]byte, error) {
+	sec := img.SectionAt(addr)
+	if sec == nil {
+		return nil, fmt.Errorf("address 0x%X is not in an allocated section", addr)
+	}
+	if addr+size > sec.Addr+sec.Size {
+		return nil, fmt.Errorf("0x%X+%d extends beyond section '%s'", addr, size, sec.Name)
+	}
+
+	buf := make([]byte, size)
+	if sec.Type == elf.SHT_NOBITS {
+		return buf, nil
+	}
+	_, e := sec.ReadAt(buf, int64(addr-sec.Addr))
+	if e != nil {
+		return nil, e
+	}
+
+	return buf, nil
+}
+
+// ReadSymbol returns the initial contents of the named symbol.
+func (img *Image) ReadSymbol(name string) ([]byte, error) {
+	sym, ok := img.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("symbol '%s' not found", name)
+	}
+
+	if img.File.Type == elf.ET_REL {
+		return img.readSectionData(sym)
+	}
+	return img.ReadAddr(img.SymbolAddr(sym), sym.Size)
+}
+
+// readSectionData returns the contents of a symbol in a relocatable object,
+// where the symbol's value is an offset into its own section.
+func (img *Image) readSectionData(sym elf.Symbol) ([]byte, error) {
+	if sym.Section == elf.SHN_UNDEF || sym.Section >= elf.SHN_LORESERVE ||
+		int(sym.Section) >= len(img.File.Sections) {
+		return nil, fmt.Errorf("symbol '%s' is not in a section", sym.Name)
+	}
+	sec := img.File.Sections[sym.Section]
+	if sym.Value+sym.Size > sec.Size {
+		return nil, fmt.Errorf("'%s' extends beyond section '%s'", sym.Name, sec.Name)
+	}
+
+	buf := make([]byte, sym.Size)
+	if sec.Type == elf.SHT_NOBITS {
+		return buf, nil
+	}
+	_, e := sec.ReadAt(buf, int64(sym.Value))
+	if e != nil {
+		return nil, e
+	}
+
+	return buf, nil
+}
+
+// SymbolAt returns the code or data symbol containing the specified address,
+// along with the offset of the address within the symbol.
+func (img *Image) SymbolAt(addr uint64) (elf.Symbol, uint64, bool) {
+	if img.addrIndex == nil {
+		img.buildAddrIndex()
+	}
+
+	// Find the last symbol starting at or before addr, then walk back over
+	// any earlier symbols that may still overlap it
+	i := sort.Search(len(img.addrIndex), func(i int) bool {
+		return img.SymbolAddr(img.addrIndex[i]) > addr
+	}) - 1
+	for ; i >= 0; i-- {
+		s := img.addrIndex[i]
+		start := img.SymbolAddr(s)
+		if addr-start >= img.maxSize && addr != start {
+			break
+		}
+		if addr == start || addr < start+s.Size {
+			return s, addr - start, true
+		}
+	}
+
+	return elf.Symbol{}, 0, false
+}
+
+// buildAddrIndex sorts the named code and data symbols by address.
+func (img *Image) buildAddrIndex() {
+	img.addrIndex = []elf.Symbol{}
+	for _, s := range img.Symbols {
+		t := elf.ST_TYPE(s.Info)
+		if s.Name == "" || (t != elf.STT_OBJECT && t != elf.STT_FUNC) ||
+			s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE {
+			continue
+		}
+		img.addrIndex = append(img.addrIndex, s)
+		if s.Size > img.maxSize {
+			img.maxSize = s.Size
+		}
+	}
+
+	sort.SliceStable(img.addrIndex, func(i, j int) bool {
+		return img.SymbolAddr(img.addrIndex[i]) < img.SymbolAddr(img.addrIndex[j])
+	})
+}
+
+// CodeAddr drops the Thumb bit from ARM addresses that point into
+// executable sections, such as function pointers and return addresses.
+// Other addresses are returned unchanged.
+func (img *Image) CodeAddr(addr uint64) uint64 {
+	if !img.IsThumb() || addr&1 == 0 {
+		return addr
+	}
+	if sec := img.SectionAt(addr &^ 1); sec != nil && sec.Flags&elf.SHF_EXECINSTR != 0 {
+		return addr &^ 1
+	}
+	return addr
+}
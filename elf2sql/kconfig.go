@@ -0,0 +1,104 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KconfigFile is a Kconfig '.config' file to load into the 'kconfig' table.
+// The table is empty if not set.
+var KconfigFile string
+
+const createKconfigTable string = `CREATE TABLE kconfig (
+	ID      integer primary key autoincrement,
+	Name    text,
+	Value   text,
+	Type    text,
+	Enabled integer
+	)`
+
+// KconfigSymbol is a single setting from a '.config' file
+type KconfigSymbol struct {
+	Name    string // Symbol name, including the 'CONFIG_' prefix
+	Value   string // Value, with the quotes removed from strings
+	Type    string // 'bool', 'string', 'int' or 'hex'
+	Enabled bool   // Set for 'y' and 'm', and for any non-bool value
+}
+
+// ReadKconfig parses a '.config' file. Symbols listed as '# CONFIG_FOO is
+// not set' are included with the value 'n'.
+func ReadKconfig(filename string) ([]KconfigSymbol, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	var syms []KconfigSymbol
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "# CONFIG_") && strings.HasSuffix(line, " is not set") {
+			name := strings.TrimSuffix(strings.TrimPrefix(line, "# "), " is not set")
+			syms = append(syms, KconfigSymbol{name, "n", "bool", false})
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || !strings.HasPrefix(name, "CONFIG_") {
+			continue
+		}
+
+		sym := KconfigSymbol{Name: name, Value: value, Enabled: true}
+		switch {
+		case value == "y" || value == "m" || value == "n":
+			sym.Type = "bool"
+			sym.Enabled = value != "n"
+		case strings.HasPrefix(value, "\""):
+			sym.Type = "string"
+			if s, e := strconv.Unquote(value); e == nil {
+				sym.Value = s
+			}
+		case strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X"):
+			sym.Type = "hex"
+		default:
+			sym.Type = "int"
+		}
+		syms = append(syms, sym)
+	}
+
+	return syms, scanner.Err()
+}
+
+// insertKconfig populates the 'kconfig' table from KconfigFile.
+func insertKconfig() error {
+	if KconfigFile == "" {
+		return nil
+	}
+	syms, e := ReadKconfig(KconfigFile)
+	if e != nil {
+		return e
+	}
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO kconfig VALUES (NULL,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, s := range syms {
+		_, e = stmt.Exec(s.Name, s.Value, s.Type, s.Enabled)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,159 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"sort"
+)
+
+const createLayoutGapTable string = `CREATE TABLE layout_gaps (
+	ID           integer primary key autoincrement,
+	Section      text,
+	SectionIndex integer,
+	Kind         text,
+	Address      integer,
+	Size         integer,
+	Before       text,
+	After        text,
+	Member       text
+	)`
+
+// Kinds of layout gap
+const (
+	GapPadding = "padding" // Alignment padding before a symbol
+	GapUnknown = "gap"     // Bytes not covered by any symbol
+	GapOverlap = "overlap" // Bytes covered by more than one symbol
+	GapAlias   = "alias"   // A symbol at the same address as another
+)
+
+// LayoutGap is a range of a section that isn't covered by exactly one
+// symbol. Before and After are the symbols either side of it, which are
+// empty at the start and end of the section.
+type LayoutGap struct {
+	Section      string
+	SectionIndex int
+	Kind         string
+	Address      uint64
+	Size         uint64
+	Before       string
+	After        string
+}
+
+// LayoutGaps walks the code and data symbols of each allocated section in
+// address order, and returns the gaps, overlaps and aliases between them.
+// Symbols without a size are only reported as aliases of the symbol they
+// label, since they don't cover any bytes. A gap is reported as padding when it ends at the first address with the
+// alignment of the next symbol, taken to be the largest power of two that
+// divides its address (up to the section alignment, or 8 bytes if larger).
+func (img *Image) LayoutGaps() []LayoutGap {
+	var gaps []LayoutGap
+	for i, sec := range img.File.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || sec.Size == 0 {
+			continue
+		}
+
+		var syms []elf.Symbol
+		for _, s := range img.Symbols {
+			t := elf.ST_TYPE(s.Info)
+			if int(s.Section) == i && (t == elf.STT_FUNC || t == elf.STT_OBJECT) {
+				syms = append(syms, s)
+			}
+		}
+		sort.SliceStable(syms, func(a, b int) bool {
+			sa, sb := img.SymbolAddr(syms[a]), img.SymbolAddr(syms[b])
+			if sa != sb {
+				return sa < sb
+			}
+			return syms[a].Size > syms[b].Size
+		})
+
+		maxAlign := sec.Addralign
+		if maxAlign < 8 {
+			maxAlign = 8
+		}
+		gap := func(start, end uint64, before, after string) {
+			kind := GapUnknown
+			if a := addrAlign(end, maxAlign); end-start < a && alignUp(start, a) == end {
+				kind = GapPadding
+			}
+			gaps = append(gaps, LayoutGap{sec.Name, i, kind, start, end - start, before, after})
+		}
+
+		// pos is the end of the symbols seen so far, and owner is the
+		// symbol that ends there
+		pos, owner := sec.Addr, ""
+		var prev elf.Symbol
+		for n, s := range syms {
+			start := img.SymbolAddr(s)
+			end := start + s.Size
+			switch {
+			case n > 0 && start == img.SymbolAddr(prev):
+				gaps = append(gaps, LayoutGap{sec.Name, i, GapAlias, start, s.Size, prev.Name, s.Name})
+			case s.Size == 0:
+				continue
+			case start < pos:
+				size := pos - start
+				if end < pos {
+					size = s.Size
+				}
+				gaps = append(gaps, LayoutGap{sec.Name, i, GapOverlap, start, size, owner, s.Name})
+			case start > pos:
+				gap(pos, start, owner, s.Name)
+			}
+			if end > pos {
+				pos, owner = end, s.Name
+			}
+			if n == 0 || start != img.SymbolAddr(prev) {
+				prev = s
+			}
+		}
+		if end := sec.Addr + sec.Size; pos < end {
+			gap(pos, end, owner, "")
+		}
+	}
+
+	return gaps
+}
+
+// addrAlign returns the largest power of two that divides addr, up to max.
+func addrAlign(addr uint64, max uint64) uint64 {
+	a := uint64(1)
+	for a < max && addr&(a<<1-1) == 0 {
+		a <<= 1
+	}
+	return a
+}
+
+// alignUp rounds addr up to a multiple of align, which is a power of two.
+func alignUp(addr uint64, align uint64) uint64 {
+	return (addr + align - 1) &^ (align - 1)
+}
+
+// insertLayoutGaps populates the 'layout_gaps' table from the supplied image.
+func insertLayoutGaps(img *Image, member interface{}) error {
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO layout_gaps VALUES (NULL,?,?,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, g := range img.LayoutGaps() {
+		var before, after interface{}
+		if g.Before != "" {
+			before = g.Before
+		}
+		if g.After != "" {
+			after = g.After
+		}
+		_, e = stmt.Exec(g.Section, g.SectionIndex, g.Kind, g.Address, g.Size, before, after, member)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
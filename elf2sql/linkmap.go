@@ -0,0 +1,231 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MapFile is a GNU ld map file to load into the 'map_memory' and
+// 'map_inputs' tables. The tables are empty if not set.
+var MapFile string
+
+const createMapMemoryTable string = `CREATE TABLE map_memory (
+	ID         integer primary key autoincrement,
+	Name       text,
+	Origin     integer,
+	Length     integer,
+	Attributes text
+	)`
+
+const createMapInputTable string = `CREATE TABLE map_inputs (
+	ID            integer primary key autoincrement,
+	OutputSection text,
+	Section       text,
+	Address       integer,
+	Size          integer,
+	Archive       text,
+	Object        text,
+	Discarded     integer
+	)`
+
+// MemoryRegion is a region from the 'Memory Configuration' of a map file,
+// other than the '*default*' region covering the whole address space
+type MemoryRegion struct {
+	Name       string
+	Origin     uint64
+	Length     uint64
+	Attributes string
+}
+
+// MapInput is an input section placed in (or discarded from) an output
+// section. Padding inserted by the linker is listed as a '*fill*' section
+// without an object.
+type MapInput struct {
+	OutputSection string
+	Section       string
+	Address       uint64
+	Size          uint64
+	Archive       string // Archive containing the object, if any
+	Object        string
+	Discarded     bool
+}
+
+// LinkerMap is the content of a GNU ld map file
+type LinkerMap struct {
+	Memory []MemoryRegion
+	Inputs []MapInput
+}
+
+// InputAt returns the input section containing the specified address.
+func (m *LinkerMap) InputAt(addr uint64) (MapInput, bool) {
+	for _, in := range m.Inputs {
+		if !in.Discarded && in.Size > 0 && addr >= in.Address && addr < in.Address+in.Size {
+			return in, true
+		}
+	}
+	return MapInput{}, false
+}
+
+// Parts of a map file
+const (
+	mapOther = iota
+	mapDiscarded
+	mapMemory
+	mapLayout
+)
+
+// parseHex reads a '0x' prefixed hex value.
+func parseHex(s string) (uint64, bool) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, false
+	}
+	v, e := strconv.ParseUint(s[2:], 16, 64)
+	return v, e == nil
+}
+
+// splitObject splits 'libfoo.a(bar.o)' into the archive and member names.
+func splitObject(s string) (string, string) {
+	if strings.HasSuffix(s, ")") {
+		if i := strings.LastIndex(s, ".a("); i >= 0 {
+			return s[:i+2], s[i+3 : len(s)-1]
+		}
+	}
+	return "", s
+}
+
+// ReadLinkerMap parses a map file written by GNU ld with '-Map'. Long
+// section names are followed by their address and size on the next line,
+// and these are joined before parsing.
+func ReadLinkerMap(filename string) (*LinkerMap, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), " \t\r"))
+	}
+	if e = scanner.Err(); e != nil {
+		return nil, e
+	}
+
+	m := &LinkerMap{}
+	part := mapOther
+	output := ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch line {
+		case "Discarded input sections":
+			part = mapDiscarded
+			continue
+		case "Memory Configuration":
+			part = mapMemory
+			continue
+		case "Linker script and memory map":
+			part = mapLayout
+			continue
+		case "Cross Reference Table":
+			part = mapOther
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || part == mapOther {
+			continue
+		}
+
+		if part == mapMemory {
+			if len(fields) < 3 || fields[0] == "Name" || fields[0] == "*default*" {
+				continue
+			}
+			origin, ok1 := parseHex(fields[1])
+			length, ok2 := parseHex(fields[2])
+			if ok1 && ok2 {
+				r := MemoryRegion{Name: fields[0], Origin: origin, Length: length}
+				if len(fields) > 3 {
+					r.Attributes = fields[3]
+				}
+				m.Memory = append(m.Memory, r)
+			}
+			continue
+		}
+
+		// Join a section name with the address and size on the next line
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if len(fields) == 1 && indent <= 1 && i+1 < len(lines) {
+			next := strings.Fields(lines[i+1])
+			if len(next) >= 2 {
+				if _, ok := parseHex(next[0]); ok {
+					if _, ok := parseHex(next[1]); ok {
+						fields = append(fields, next...)
+						i++
+					}
+				}
+			}
+		}
+		if len(fields) < 3 {
+			continue
+		}
+		addr, ok1 := parseHex(fields[1])
+		size, ok2 := parseHex(fields[2])
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		switch {
+		case indent == 0 && part == mapLayout:
+			// An output section
+			output = fields[0]
+		case indent == 1:
+			in := MapInput{Section: fields[0], Address: addr, Size: size}
+			if part == mapDiscarded {
+				in.Discarded = true
+			} else {
+				in.OutputSection = output
+			}
+			if len(fields) > 3 && in.Section != "*fill*" {
+				in.Archive, in.Object = splitObject(strings.Join(fields[3:], " "))
+			}
+			m.Inputs = append(m.Inputs, in)
+		}
+	}
+
+	return m, nil
+}
+
+// insertLinkerMap populates the 'map_memory' and 'map_inputs' tables.
+func insertLinkerMap(m *LinkerMap) error {
+	if m == nil {
+		return nil
+	}
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	for _, r := range m.Memory {
+		_, e = tx.Exec(`INSERT INTO map_memory VALUES (NULL,?,?,?,?)`,
+			r.Name, int64(r.Origin), int64(r.Length), nullable(r.Attributes))
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+	for _, in := range m.Inputs {
+		_, e = tx.Exec(`INSERT INTO map_inputs VALUES (NULL,?,?,?,?,?,?,?)`,
+			nullable(in.OutputSection), in.Section, int64(in.Address), int64(in.Size),
+			nullable(in.Archive), nullable(in.Object), in.Discarded)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,324 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// MCUbootFile is a signed MCUboot image to load into the 'mcuboot' and
+// 'mcuboot_tlvs' tables. The tables are empty if not set.
+var MCUbootFile string
+
+const createMCUbootTable string = `CREATE TABLE mcuboot (
+	ID               integer primary key autoincrement,
+	Magic            integer,
+	LoadAddress      integer,
+	HeaderSize       integer,
+	ImageSize        integer,
+	ProtectedTLVSize integer,
+	Flags            text,
+	Version          text,
+	HashType         text,
+	HashValid        integer
+	)`
+
+const createMCUbootTLVTable string = `CREATE TABLE mcuboot_tlvs (
+	ID     integer primary key autoincrement,
+	Area   text,
+	Type   integer,
+	Name   text,
+	Offset integer,
+	Length integer,
+	Value  text
+	)`
+
+// MCUboot image and TLV area magic numbers
+const (
+	MCUbootMagic      = 0x96f3b83d
+	MCUbootMagicV1    = 0x96f3b83c
+	mcubootTLVInfo    = 0x6907
+	mcubootTLVProtect = 0x6908
+)
+
+// mcubootHeaderSize is the size of the fixed image header
+const mcubootHeaderSize = 32
+
+// Names of the TLV types defined by MCUboot's image.h
+var mcubootTLVNames = map[uint16]string{
+	0x01: "KEYHASH",
+	0x02: "PUBKEY",
+	0x10: "SHA256",
+	0x11: "SHA384",
+	0x12: "SHA512",
+	0x20: "RSA2048_PSS",
+	0x21: "ECDSA224",
+	0x22: "ECDSA_SIG",
+	0x23: "RSA3072_PSS",
+	0x24: "ED25519",
+	0x25: "SIG_PURE",
+	0x30: "ENC_RSA2048",
+	0x31: "ENC_KW",
+	0x32: "ENC_EC256",
+	0x33: "ENC_X25519",
+	0x34: "ENC_X25519_SHA512",
+	0x40: "DEPENDENCY",
+	0x50: "SEC_CNT",
+	0x60: "BOOT_RECORD",
+	0x70: "DECOMP_SIZE",
+	0x71: "DECOMP_SHA",
+	0x72: "DECOMP_SIGNATURE",
+}
+
+// Names of the image header flags
+var mcubootFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{0x00000001, "PIC"},
+	{0x00000004, "ENCRYPTED_AES128"},
+	{0x00000008, "ENCRYPTED_AES256"},
+	{0x00000010, "NON_BOOTABLE"},
+	{0x00000020, "RAM_LOAD"},
+	{0x00000100, "ROM_FIXED"},
+	{0x00000200, "COMPRESSED_LZMA1"},
+	{0x00000400, "COMPRESSED_LZMA2"},
+	{0x00000800, "COMPRESSED_ARM_THUMB_FLT"},
+}
+
+// MCUbootVersion is an image version, shown as 'major.minor.revision+build'
+type MCUbootVersion struct {
+	Major    uint8
+	Minor    uint8
+	Revision uint16
+	Build    uint32
+}
+
+func (v MCUbootVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d+%d", v.Major, v.Minor, v.Revision, v.Build)
+}
+
+// MCUbootHeader is the header at the start of an MCUboot image
+type MCUbootHeader struct {
+	Magic          uint32
+	LoadAddr       uint32
+	HdrSize        uint16
+	ProtectTLVSize uint16
+	ImgSize        uint32
+	Flags          uint32
+	Version        MCUbootVersion
+	Pad            uint32
+}
+
+// FlagNames returns the names of the header's flags, with unknown flags in
+// hex.
+func (h MCUbootHeader) FlagNames() []string {
+	var names []string
+	flags := h.Flags
+	for _, f := range mcubootFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("0x%X", flags))
+	}
+	return names
+}
+
+// MCUbootTLV is an entry in the protected or unprotected TLV area
+type MCUbootTLV struct {
+	Protected bool
+	Type      uint16
+	Offset    int // Offset of the entry from the start of the image
+	Data      []byte
+}
+
+// Name returns the name of the TLV type, or its number if unknown.
+func (t MCUbootTLV) Name() string {
+	if n, ok := mcubootTLVNames[t.Type]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%02X", t.Type)
+}
+
+// Area returns 'protected' or 'unprotected'.
+func (t MCUbootTLV) Area() string {
+	if t.Protected {
+		return "protected"
+	}
+	return "unprotected"
+}
+
+// Value decodes the TLV's value. Security counters are shown in decimal,
+// dependencies as 'image N >= version', and everything else in hex.
+func (t MCUbootTLV) Value() string {
+	switch {
+	case t.Type == 0x50 && len(t.Data) == 4:
+		return fmt.Sprint(binary.LittleEndian.Uint32(t.Data))
+	case t.Type == 0x70 && len(t.Data) == 4:
+		return fmt.Sprint(binary.LittleEndian.Uint32(t.Data))
+	case t.Type == 0x40 && len(t.Data) >= 12:
+		v := MCUbootVersion{t.Data[4], t.Data[5], binary.LittleEndian.Uint16(t.Data[6:]),
+			binary.LittleEndian.Uint32(t.Data[8:])}
+		return fmt.Sprintf("image %d >= %s", t.Data[0], v)
+	}
+	return hex.EncodeToString(t.Data)
+}
+
+// MCUbootImage is a parsed MCUboot image. The hash is computed over the
+// header, payload and protected TLV area, and checked against the image's
+// SHA TLV.
+type MCUbootImage struct {
+	Header   MCUbootHeader
+	TLVs     []MCUbootTLV
+	HashType string // Name of the hash TLV, empty if there isn't one
+	Hash     []byte // Computed hash
+	HashOK   bool
+}
+
+// parseTLVArea reads the TLV area at off, returning its entries and size.
+func parseTLVArea(data []byte, off int, magic uint16) ([]MCUbootTLV, int, error) {
+	if off+4 > len(data) {
+		return nil, 0, fmt.Errorf("TLV area at 0x%X is past the end of the image", off)
+	}
+	if m := binary.LittleEndian.Uint16(data[off:]); m != magic {
+		return nil, 0, fmt.Errorf("bad TLV magic 0x%04X at 0x%X", m, off)
+	}
+	total := int(binary.LittleEndian.Uint16(data[off+2:]))
+	if total < 4 || off+total > len(data) {
+		return nil, 0, fmt.Errorf("TLV area at 0x%X has an invalid size (%d)", off, total)
+	}
+
+	var tlvs []MCUbootTLV
+	for p := off + 4; p < off+total; {
+		if p+4 > off+total {
+			return nil, 0, fmt.Errorf("truncated TLV at 0x%X", p)
+		}
+		typ := binary.LittleEndian.Uint16(data[p:])
+		n := int(binary.LittleEndian.Uint16(data[p+2:]))
+		if p+4+n > off+total {
+			return nil, 0, fmt.Errorf("TLV 0x%02X at 0x%X overruns its area", typ, p)
+		}
+		tlvs = append(tlvs, MCUbootTLV{Protected: magic == mcubootTLVProtect, Type: typ,
+			Offset: p, Data: data[p+4 : p+4+n]})
+		p += 4 + n
+	}
+	return tlvs, total, nil
+}
+
+// ParseMCUboot parses an MCUboot image, as written by imgtool, and checks
+// its hash. Signatures are listed but not verified, since that needs the
+// signing key.
+func ParseMCUboot(data []byte) (*MCUbootImage, error) {
+	if len(data) < mcubootHeaderSize {
+		return nil, fmt.Errorf("image is too small for an MCUboot header")
+	}
+	img := &MCUbootImage{}
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &img.Header)
+	h := img.Header
+	if h.Magic != MCUbootMagic && h.Magic != MCUbootMagicV1 {
+		return nil, fmt.Errorf("bad image magic 0x%08X", h.Magic)
+	}
+	if h.HdrSize < mcubootHeaderSize {
+		return nil, fmt.Errorf("invalid header size %d", h.HdrSize)
+	}
+
+	// The protected TLVs (if any) follow the payload, then the unprotected
+	off := int(h.HdrSize) + int(h.ImgSize)
+	if h.ProtectTLVSize > 0 {
+		tlvs, size, e := parseTLVArea(data, off, mcubootTLVProtect)
+		if e != nil {
+			return nil, e
+		}
+		if size != int(h.ProtectTLVSize) {
+			return nil, fmt.Errorf("protected TLV area is %d bytes, but the header gives %d",
+				size, h.ProtectTLVSize)
+		}
+		img.TLVs = append(img.TLVs, tlvs...)
+		off += size
+	}
+	tlvs, _, e := parseTLVArea(data, off, mcubootTLVInfo)
+	if e != nil {
+		return nil, e
+	}
+	img.TLVs = append(img.TLVs, tlvs...)
+
+	// Check the hash, which covers everything up to the unprotected TLVs
+	for _, t := range img.TLVs {
+		var hf hash.Hash
+		switch t.Type {
+		case 0x10:
+			hf = sha256.New()
+		case 0x11:
+			hf = sha512.New384()
+		case 0x12:
+			hf = sha512.New()
+		default:
+			continue
+		}
+		hf.Write(data[:off])
+		img.HashType = t.Name()
+		img.Hash = hf.Sum(nil)
+		img.HashOK = bytes.Equal(img.Hash, t.Data)
+		break
+	}
+
+	return img, nil
+}
+
+// ReadMCUboot reads an MCUboot image from a binary, Intel HEX or S-record
+// file.
+func ReadMCUboot(filename string) (*MCUbootImage, error) {
+	m, _, e := ReadMemImage(filename, 0)
+	if e != nil {
+		return nil, e
+	}
+
+	img, e := ParseMCUboot(m.Flatten(0xFF))
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", filename, e)
+	}
+	return img, nil
+}
+
+// insertMCUboot populates the 'mcuboot' and 'mcuboot_tlvs' tables from
+// MCUbootFile.
+func insertMCUboot() error {
+	if MCUbootFile == "" {
+		return nil
+	}
+	img, e := ReadMCUboot(MCUbootFile)
+	if e != nil {
+		return e
+	}
+
+	h := img.Header
+	var hashValid interface{}
+	if img.HashType != "" {
+		hashValid = img.HashOK
+	}
+	_, e = DBCon.Exec(`INSERT INTO mcuboot VALUES (NULL,?,?,?,?,?,?,?,?,?)`,
+		h.Magic, h.LoadAddr, h.HdrSize, h.ImgSize, h.ProtectTLVSize,
+		nullable(strings.Join(h.FlagNames(), ", ")), h.Version.String(),
+		nullable(img.HashType), hashValid)
+	if e != nil {
+		return e
+	}
+
+	for _, t := range img.TLVs {
+		_, e = DBCon.Exec(`INSERT INTO mcuboot_tlvs VALUES (NULL,?,?,?,?,?,?)`,
+			t.Area(), t.Type, t.Name(), t.Offset, len(t.Data), t.Value())
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
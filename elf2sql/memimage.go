@@ -0,0 +1,236 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MemBlock is a contiguous block of memory contents at a load address
+type MemBlock struct {
+	Address uint64
+	Data    []byte
+	Section string // Section the block was taken from, if known
+}
+
+// End returns the address following the last byte of the block.
+func (b MemBlock) End() uint64 {
+	return b.Address + uint64(len(b.Data))
+}
+
+// MemImage is the memory contents to be programmed for an image, as held in
+// an Intel HEX, S-record or raw binary file. Blocks are sorted by address
+// and don't overlap.
+type MemImage struct {
+	Blocks   []MemBlock
+	Entry    uint64
+	HasEntry bool
+}
+
+// matchSection indicates if a section name matches any of the patterns,
+// which can contain shell wildcards as with objcopy's -j and -R.
+func matchSection(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MemImage returns the contents of the image's loadable sections at their
+// physical (load) addresses, as found from the PT_LOAD segments that
+// contain them. Sections are selected with only, when not empty, and
+// excluded with remove. Images without program headers, such as
+// relocatable objects, use the section addresses.
+func (img *Image) MemImage(only []string, remove []string) (*MemImage, error) {
+	var loads []*elf.Prog
+	for _, p := range img.File.Progs {
+		if p.Type == elf.PT_LOAD && p.Filesz > 0 {
+			loads = append(loads, p)
+		}
+	}
+
+	m := &MemImage{Entry: img.File.Entry, HasEntry: img.File.Entry != 0}
+	for _, s := range img.File.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Type == elf.SHT_NOBITS || s.Size == 0 {
+			continue
+		}
+		if len(only) > 0 && !matchSection(s.Name, only) || matchSection(s.Name, remove) {
+			continue
+		}
+
+		lma, ok := s.Addr, len(loads) == 0
+		for _, p := range loads {
+			if s.Offset >= p.Off && s.Offset < p.Off+p.Filesz {
+				lma, ok = p.Paddr+s.Offset-p.Off, true
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		data, e := s.Data()
+		if e != nil {
+			return nil, fmt.Errorf("%s: %s", s.Name, e)
+		}
+		m.Blocks = append(m.Blocks, MemBlock{Address: lma, Data: data, Section: s.Name})
+	}
+	sort.SliceStable(m.Blocks, func(i, j int) bool {
+		return m.Blocks[i].Address < m.Blocks[j].Address
+	})
+
+	for i := 1; i < len(m.Blocks); i++ {
+		if prev := m.Blocks[i-1]; m.Blocks[i].Address < prev.End() {
+			return nil, fmt.Errorf("sections %s and %s overlap at 0x%X", prev.Section,
+				m.Blocks[i].Section, m.Blocks[i].Address)
+		}
+	}
+
+	return m, nil
+}
+
+// Start returns the lowest address of the image.
+func (m *MemImage) Start() uint64 {
+	if len(m.Blocks) == 0 {
+		return 0
+	}
+	return m.Blocks[0].Address
+}
+
+// End returns the address following the highest byte of the image.
+func (m *MemImage) End() uint64 {
+	if len(m.Blocks) == 0 {
+		return 0
+	}
+	return m.Blocks[len(m.Blocks)-1].End()
+}
+
+// Size returns the number of bytes held by the image, excluding gaps.
+func (m *MemImage) Size() uint64 {
+	var n uint64
+	for _, b := range m.Blocks {
+		n += uint64(len(b.Data))
+	}
+	return n
+}
+
+// Flatten returns the contents of the image from Start to End, with gaps
+// between blocks filled with the fill byte.
+func (m *MemImage) Flatten(fill byte) []byte {
+	out := bytes.Repeat([]byte{fill}, int(m.End()-m.Start()))
+	for _, b := range m.Blocks {
+		copy(out[b.Address-m.Start():], b.Data)
+	}
+	return out
+}
+
+// FillGaps extends each block up to the start of the next with the fill
+// byte, as objcopy's --gap-fill does, so the image has no gaps.
+func (m *MemImage) FillGaps(fill byte) {
+	for i := 0; i+1 < len(m.Blocks); i++ {
+		gap := m.Blocks[i+1].Address - m.Blocks[i].End()
+		m.Blocks[i].Data = append(m.Blocks[i].Data, bytes.Repeat([]byte{fill}, int(gap))...)
+	}
+}
+
+// add appends data at the specified address, extending the last block when
+// the data follows it directly. Blocks are sorted and checked for overlaps
+// by finish.
+func (m *MemImage) add(addr uint64, data []byte) {
+	if n := len(m.Blocks); n > 0 && m.Blocks[n-1].End() == addr {
+		m.Blocks[n-1].Data = append(m.Blocks[n-1].Data, data...)
+		return
+	}
+	m.Blocks = append(m.Blocks, MemBlock{Address: addr, Data: append([]byte(nil), data...)})
+}
+
+// finish sorts the blocks read from a file and joins adjacent ones. Records
+// that overlap are reported as an error.
+func (m *MemImage) finish() error {
+	sort.SliceStable(m.Blocks, func(i, j int) bool {
+		return m.Blocks[i].Address < m.Blocks[j].Address
+	})
+	var blocks []MemBlock
+	for _, b := range m.Blocks {
+		n := len(blocks)
+		switch {
+		case n > 0 && b.Address < blocks[n-1].End():
+			return fmt.Errorf("data at 0x%X overlaps data at 0x%X", b.Address,
+				blocks[n-1].Address)
+		case n > 0 && b.Address == blocks[n-1].End():
+			blocks[n-1].Data = append(blocks[n-1].Data, b.Data...)
+		default:
+			blocks = append(blocks, b)
+		}
+	}
+	m.Blocks = blocks
+	return nil
+}
+
+// ReadBinary returns the contents of a raw binary file loaded at base.
+func ReadBinary(data []byte, base uint64) *MemImage {
+	return &MemImage{Blocks: []MemBlock{{Address: base, Data: data}}}
+}
+
+// WriteBinary writes the image as a raw binary file, starting at its lowest
+// address, with gaps filled with the fill byte.
+func WriteBinary(w io.Writer, m *MemImage, fill byte) error {
+	_, e := w.Write(m.Flatten(fill))
+	return e
+}
+
+// Memory image file formats
+const (
+	FormatBinary = "binary"
+	FormatIHex   = "ihex"
+	FormatSRec   = "srec"
+)
+
+// DetectFormat returns the format of a memory image file, from its
+// contents for Intel HEX and S-record files, or else its extension.
+func DetectFormat(filename string, data []byte) string {
+	text := bytes.TrimLeft(data, " \t\r\n")
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".bin", ".img", ".raw":
+		return FormatBinary
+	}
+	switch {
+	case len(text) > 0 && text[0] == ':':
+		return FormatIHex
+	case len(text) > 1 && text[0] == 'S' && text[1] >= '0' && text[1] <= '9':
+		return FormatSRec
+	}
+	return FormatBinary
+}
+
+// ReadMemImage reads an Intel HEX, S-record or raw binary file, detecting
+// the format from its contents. Raw binary files are loaded at base.
+func ReadMemImage(filename string, base uint64) (*MemImage, string, error) {
+	data, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, "", e
+	}
+
+	format := DetectFormat(filename, data)
+	var m *MemImage
+	switch format {
+	case FormatIHex:
+		m, e = ReadIHex(data)
+	case FormatSRec:
+		m, e = ReadSRec(data)
+	default:
+		m = ReadBinary(data, base)
+	}
+	if e != nil {
+		return nil, "", fmt.Errorf("%s: %s", filename, e)
+	}
+
+	return m, format, nil
+}
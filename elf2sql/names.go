@@ -0,0 +1,231 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+)
+
+// Readable names for ELF machine types. Machines not listed here use the
+// debug/elf constant name without its 'EM_' prefix.
+var machineNames = map[elf.Machine]string{
+	elf.EM_NONE:         "None",
+	elf.EM_M32:          "WE32100",
+	elf.EM_SPARC:        "SPARC",
+	elf.EM_386:          "x86",
+	elf.EM_68K:          "MC68000",
+	elf.EM_88K:          "MC88000",
+	elf.EM_486:          "Intel 80486",
+	elf.EM_860:          "Intel 80860",
+	elf.EM_MIPS:         "MIPS",
+	elf.EM_S370:         "IBM System/370",
+	elf.EM_MIPS_RS3_LE:  "MIPS R3000 (little-endian)",
+	elf.EM_PARISC:       "HPPA",
+	elf.EM_960:          "Intel 80960",
+	elf.EM_PPC:          "PowerPC",
+	elf.EM_PPC64:        "PowerPC64",
+	elf.EM_S390:         "IBM S/390",
+	elf.EM_V800:         "NEC V800",
+	elf.EM_FR20:         "Fujitsu FR20",
+	elf.EM_RH32:         "TRW RH-32",
+	elf.EM_RCE:          "Motorola RCE",
+	elf.EM_ARM:          "ARM",
+	elf.EM_SH:           "Renesas SuperH",
+	elf.EM_SPARCV9:      "SPARC v9",
+	elf.EM_TRICORE:      "Infineon TriCore",
+	elf.EM_ARC:          "ARC",
+	elf.EM_H8_300:       "Renesas H8/300",
+	elf.EM_H8_300H:      "Renesas H8/300H",
+	elf.EM_H8S:          "Renesas H8S",
+	elf.EM_H8_500:       "Renesas H8/500",
+	elf.EM_IA_64:        "IA-64",
+	elf.EM_MIPS_X:       "Stanford MIPS-X",
+	elf.EM_COLDFIRE:     "ColdFire",
+	elf.EM_68HC12:       "MC68HC12",
+	elf.EM_X86_64:       "x86_64",
+	elf.EM_PDSP:         "Sony DSP",
+	elf.EM_ST100:        "STMicroelectronics ST100",
+	elf.EM_TINYJ:        "TinyJ",
+	elf.EM_FX66:         "Siemens FX66",
+	elf.EM_ST9PLUS:      "STMicroelectronics ST9+",
+	elf.EM_ST7:          "STMicroelectronics ST7",
+	elf.EM_68HC16:       "MC68HC16",
+	elf.EM_68HC11:       "MC68HC11",
+	elf.EM_68HC08:       "MC68HC08",
+	elf.EM_68HC05:       "MC68HC05",
+	elf.EM_SVX:          "Silicon Graphics SVx",
+	elf.EM_ST19:         "STMicroelectronics ST19",
+	elf.EM_VAX:          "VAX",
+	elf.EM_CRIS:         "Axis CRIS",
+	elf.EM_JAVELIN:      "Infineon Javelin",
+	elf.EM_FIREPATH:     "Element 14 FirePath",
+	elf.EM_ZSP:          "LSI Logic ZSP",
+	elf.EM_MMIX:         "MMIX",
+	elf.EM_HUANY:        "Harvard HUANY",
+	elf.EM_PRISM:        "SiTera Prism",
+	elf.EM_AVR:          "Atmel AVR",
+	elf.EM_FR30:         "Fujitsu FR30",
+	elf.EM_D10V:         "Mitsubishi D10V",
+	elf.EM_D30V:         "Mitsubishi D30V",
+	elf.EM_V850:         "NEC V850",
+	elf.EM_M32R:         "Renesas M32R",
+	elf.EM_MN10300:      "Matsushita MN10300",
+	elf.EM_MN10200:      "Matsushita MN10200",
+	elf.EM_PJ:           "picoJava",
+	elf.EM_OPENRISC:     "OpenRISC",
+	elf.EM_ARC_COMPACT:  "ARCompact",
+	elf.EM_XTENSA:       "Xtensa",
+	elf.EM_VIDEOCORE:    "Alphamosaic VideoCore",
+	elf.EM_TMM_GPP:      "Thompson Multimedia GPP",
+	elf.EM_NS32K:        "NS32000",
+	elf.EM_TPC:          "Tenor Network TPC",
+	elf.EM_SNP1K:        "Trebia SNP 1000",
+	elf.EM_ST200:        "STMicroelectronics ST200",
+	elf.EM_IP2K:         "Ubicom IP2xxx",
+	elf.EM_MAX:          "MAX",
+	elf.EM_CR:           "National Semiconductor CompactRISC",
+	elf.EM_F2MC16:       "Fujitsu F2MC16",
+	elf.EM_MSP430:       "TI MSP430",
+	elf.EM_BLACKFIN:     "Analog Devices Blackfin",
+	elf.EM_SE_C33:       "Seiko Epson S1C33",
+	elf.EM_SEP:          "Sharp SEP",
+	elf.EM_ARCA:         "Arca RISC",
+	elf.EM_UNICORE:      "UniCore",
+	elf.EM_ALTERA_NIOS2: "Altera Nios II",
+	elf.EM_CRX:          "National Semiconductor CRX",
+	elf.EM_TI_C6000:     "TI C6000",
+	elf.EM_TI_C2000:     "TI C2000",
+	elf.EM_TI_C5500:     "TI C5500",
+	elf.EM_NDS32:        "Andes NDS32",
+	elf.EM_AVR32:        "Atmel AVR32",
+	elf.EM_AARCH64:      "ARM64",
+	elf.EM_MICROBLAZE:   "Xilinx MicroBlaze",
+	elf.EM_ARC_COMPACT2: "ARCv2",
+	elf.EM_RISCV:        "RISC-V",
+	elf.EM_BPF:          "BPF",
+	elf.EM_LOONGARCH:    "LoongArch",
+}
+
+// MachineName returns a readable name for an ELF machine type.
+func MachineName(m elf.Machine) string {
+	if name, ok := machineNames[m]; ok {
+		return name
+	}
+	return strings.TrimPrefix(m.String(), "EM_")
+}
+
+// Readable names for ELF OS ABI values, as shown by readelf
+var osABINames = map[elf.OSABI]string{
+	elf.ELFOSABI_NONE:       "UNIX - System V",
+	elf.ELFOSABI_HPUX:       "UNIX - HP-UX",
+	elf.ELFOSABI_NETBSD:     "UNIX - NetBSD",
+	elf.ELFOSABI_LINUX:      "UNIX - GNU",
+	elf.ELFOSABI_HURD:       "GNU/Hurd",
+	elf.ELFOSABI_86OPEN:     "86Open",
+	elf.ELFOSABI_SOLARIS:    "UNIX - Solaris",
+	elf.ELFOSABI_AIX:        "UNIX - AIX",
+	elf.ELFOSABI_IRIX:       "UNIX - IRIX",
+	elf.ELFOSABI_FREEBSD:    "UNIX - FreeBSD",
+	elf.ELFOSABI_TRU64:      "UNIX - TRU64",
+	elf.ELFOSABI_MODESTO:    "Novell - Modesto",
+	elf.ELFOSABI_OPENBSD:    "UNIX - OpenBSD",
+	elf.ELFOSABI_OPENVMS:    "VMS - OpenVMS",
+	elf.ELFOSABI_NSK:        "HP - Non-Stop Kernel",
+	elf.ELFOSABI_AROS:       "AROS",
+	elf.ELFOSABI_FENIXOS:    "FenixOS",
+	elf.ELFOSABI_CLOUDABI:   "Nuxi CloudABI",
+	elf.ELFOSABI_ARM:        "ARM",
+	elf.ELFOSABI_STANDALONE: "Standalone App",
+}
+
+// OSABIName returns a readable name for an ELF OS ABI value.
+func OSABIName(abi elf.OSABI) string {
+	if name, ok := osABINames[abi]; ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown: %d>", uint8(abi))
+}
+
+// Processor-specific section types, which debug/elf doesn't name
+var procSectionTypes = map[elf.Machine]map[elf.SectionType]string{
+	elf.EM_ARM: {
+		0x70000001: "ARM_EXIDX",
+		0x70000002: "ARM_PREEMPTMAP",
+		0x70000003: "ARM_ATTRIBUTES",
+		0x70000004: "ARM_DEBUGOVERLAY",
+		0x70000005: "ARM_OVERLAYSECTION",
+	},
+	elf.EM_AARCH64: {
+		0x70000003: "AARCH64_ATTRIBUTES",
+	},
+	elf.EM_RISCV: {
+		0x70000003: "RISCV_ATTRIBUTES",
+	},
+	elf.EM_MIPS: {
+		0x70000006: "MIPS_REGINFO",
+		0x7000000d: "MIPS_OPTIONS",
+		0x7000001e: "MIPS_DWARF",
+		0x7000002a: "MIPS_ABIFLAGS",
+	},
+}
+
+// SectionTypeName returns the readelf-style name of a section type, such as
+// 'PROGBITS' or 'ARM_EXIDX'.
+func SectionTypeName(t elf.SectionType, m elf.Machine) string {
+	if name, ok := procSectionTypes[m][t]; ok {
+		return name
+	}
+	name := t.String()
+	if !strings.HasPrefix(name, "SHT_") {
+		switch {
+		case t >= elf.SHT_LOPROC && t <= elf.SHT_HIPROC:
+			return fmt.Sprintf("LOPROC+0x%x", uint32(t-elf.SHT_LOPROC))
+		case t >= elf.SHT_LOOS && t <= elf.SHT_HIOS:
+			return fmt.Sprintf("LOOS+0x%x", uint32(t-elf.SHT_LOOS))
+		case t >= elf.SHT_LOUSER && t <= elf.SHT_HIUSER:
+			return fmt.Sprintf("LOUSER+0x%x", uint32(t-elf.SHT_LOUSER))
+		}
+		return fmt.Sprintf("0x%x", uint32(t))
+	}
+	return strings.TrimPrefix(name, "SHT_")
+}
+
+// Section flag letters, in the same order and notation as readelf
+var sectionFlagLetters = []struct {
+	flag   elf.SectionFlag
+	letter byte
+}{
+	{elf.SHF_WRITE, 'W'},
+	{elf.SHF_ALLOC, 'A'},
+	{elf.SHF_EXECINSTR, 'X'},
+	{elf.SHF_MERGE, 'M'},
+	{elf.SHF_STRINGS, 'S'},
+	{elf.SHF_INFO_LINK, 'I'},
+	{elf.SHF_LINK_ORDER, 'L'},
+	{elf.SHF_OS_NONCONFORMING, 'O'},
+	{elf.SHF_GROUP, 'G'},
+	{elf.SHF_TLS, 'T'},
+	{elf.SHF_COMPRESSED, 'C'},
+	{0x80000000, 'E'}, // SHF_EXCLUDE
+}
+
+// SectionFlagsString returns section flags as readelf-style letters, such as
+// 'AX' for code or 'WA' for data. Other OS and processor-specific bits are
+// shown as 'o' and 'p'.
+func SectionFlagsString(f elf.SectionFlag) string {
+	var sb strings.Builder
+	rest := f
+	for _, l := range sectionFlagLetters {
+		if f&l.flag != 0 {
+			sb.WriteByte(l.letter)
+			rest &^= l.flag
+		}
+	}
+	if rest&elf.SHF_MASKOS != 0 {
+		sb.WriteByte('o')
+	}
+	if rest&elf.SHF_MASKPROC != 0 {
+		sb.WriteByte('p')
+	}
+	return sb.String()
+}
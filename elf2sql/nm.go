@@ -0,0 +1,205 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"strings"
+)
+
+// Reserved section indices, as stored in the 'symbols' table
+const (
+	shnUndef     = 0
+	shnLoReserve = 0xFF00
+	shnCommon    = 0xFFF2
+)
+
+// NMSymbol is a row of the 'symbols' table, along with the details of its
+// section that are needed to classify it the same way as GNU nm
+type NMSymbol struct {
+	Value        uint64
+	Size         uint64
+	Type         string
+	Binding      string
+	SectionIndex int
+	Name         string
+	Section      string
+	SectionType  string
+	SectionFlags string
+	Member       string
+	Version      string // Version suffix of a dynamic symbol, such as '@@GLIBC_2.2.5'
+}
+
+// NMSymbols returns every symbol in the database, except the null symbol at
+// the start of each symbol table, in symbol table order. Section symbols are
+// named after their section, as in nm.
+func NMSymbols() ([]NMSymbol, error) {
+	rows, e := DBCon.Query(`SELECT y.Value, y.Size, y.Type, y.Binding,
+		y.SectionIndex, y.Name, y.Section, ifnull(s.Type, ''),
+		ifnull(s.Flags, ''), ifnull(y.Member, '')
+		FROM symbols y LEFT JOIN sections s
+		ON s.ID = y.SectionIndex AND s.Member IS y.Member
+		WHERE NOT (y.Name = '' AND y.Type = 'none' AND y.SectionIndex = 0)
+		ORDER BY y.ID`)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	var syms []NMSymbol
+	for rows.Next() {
+		var s NMSymbol
+		e = rows.Scan(&s.Value, &s.Size, &s.Type, &s.Binding, &s.SectionIndex,
+			&s.Name, &s.Section, &s.SectionType, &s.SectionFlags, &s.Member)
+		if e != nil {
+			return nil, e
+		}
+		if s.Name == "" && s.Type == symTypeStrings[SttSection] {
+			s.Name = s.Section
+		}
+		syms = append(syms, s)
+	}
+	return syms, rows.Err()
+}
+
+// IsUndefined indicates if the symbol is defined in another object.
+func (s NMSymbol) IsUndefined() bool {
+	return s.SectionIndex == shnUndef
+}
+
+// IsCommon indicates if the symbol is an unallocated common block.
+func (s NMSymbol) IsCommon() bool {
+	return s.SectionIndex == shnCommon
+}
+
+// IsDebugging indicates if the symbol is a section or source file name,
+// which nm only lists with '--debug-syms'.
+func (s NMSymbol) IsDebugging() bool {
+	return s.Type == symTypeStrings[SttSection] || s.Type == symTypeStrings[SttFile]
+}
+
+// IsExternal indicates if the symbol is visible to other objects.
+func (s NMSymbol) IsExternal() bool {
+	return s.Binding != symBindingStrings[StbLocal] || s.IsUndefined() || s.IsCommon()
+}
+
+// SectionName returns the symbol's section name, using the same names as GNU
+// nm for undefined, absolute and common symbols.
+func (s NMSymbol) SectionName() string {
+	switch {
+	case s.IsUndefined():
+		return "*UND*"
+	case s.IsCommon():
+		return "*COM*"
+	case s.SectionIndex >= shnLoReserve:
+		return "*ABS*"
+	}
+	return s.Section
+}
+
+// IsSpecial indicates if the symbol is a target-specific marker that nm
+// hides by default, such as the ARM '$t' and '$d' mapping symbols.
+func (s NMSymbol) IsSpecial(m elf.Machine) bool {
+	var prefixes []string
+	switch m {
+	case elf.EM_ARM:
+		prefixes = []string{"$a", "$t", "$d"}
+	case elf.EM_AARCH64:
+		prefixes = []string{"$x", "$d"}
+	case elf.EM_RISCV:
+		// RISC-V mapping symbols may include the ISA string, as in
+		// '$xrv32i2p1'. Local labels are hidden too.
+		return strings.HasPrefix(s.Name, "$x") || strings.HasPrefix(s.Name, "$d") ||
+			strings.HasPrefix(s.Name, ".L")
+	}
+	for _, p := range prefixes {
+		if s.Name == p || strings.HasPrefix(s.Name, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Letter returns the GNU nm symbol type letter, such as 'T' for a global
+// function or 'b' for a local variable in bss. Lowercase letters are used
+// for local symbols.
+func (s NMSymbol) Letter() byte {
+	weak := s.Binding == symBindingStrings[StbWeak]
+	object := s.Type == symTypeStrings[SttObject] || s.Type == symTypeStrings[SttCommon]
+
+	switch {
+	case s.IsCommon():
+		return 'C'
+	case s.IsUndefined():
+		if weak && object {
+			return 'v'
+		} else if weak {
+			return 'w'
+		}
+		return 'U'
+	case s.Type == symTypeStrings[SttLoOs]:
+		// STT_GNU_IFUNC
+		return 'i'
+	case weak && object:
+		return 'V'
+	case weak:
+		return 'W'
+	case s.Binding == symBindingStrings[StbLoOs]:
+		// STB_GNU_UNIQUE
+		return 'u'
+	case s.Binding != symBindingStrings[StbLocal] && s.Binding != symBindingStrings[StbGlobal]:
+		return '?'
+	}
+
+	c := byte('a')
+	if s.SectionIndex < shnLoReserve {
+		c = s.sectionLetter()
+	}
+	if s.Binding == symBindingStrings[StbGlobal] {
+		c = strings.ToUpper(string(c))[0]
+	}
+	return c
+}
+
+// sectionLetter classifies the symbol's section in the same way as the BFD
+// library used by GNU nm.
+func (s NMSymbol) sectionLetter() byte {
+	// Sections recognised by name (from PE files)
+	for _, n := range []struct {
+		prefix string
+		letter byte
+	}{{".drectve", 'i'}, {".edata", 'e'}, {".idata", 'i'}, {".pdata", 'p'}} {
+		if strings.HasPrefix(s.Section, n.prefix) {
+			return n.letter
+		}
+	}
+
+	alloc := strings.Contains(s.SectionFlags, "A")
+	write := strings.Contains(s.SectionFlags, "W")
+	nobits := s.SectionType == "NOBITS"
+	switch {
+	case strings.Contains(s.SectionFlags, "X"):
+		return 't'
+	case alloc && !nobits && write:
+		return 'd'
+	case alloc && !nobits:
+		return 'r'
+	case nobits:
+		return 'b'
+	case !alloc && isDebugSection(s.Section):
+		return 'N'
+	case !write:
+		return 'n'
+	}
+	return '?'
+}
+
+// isDebugSection indicates if a non-allocated section holds debug data,
+// which is only recognised by name.
+func isDebugSection(name string) bool {
+	for _, p := range []string{".debug", ".gnu.debuglto_.debug_", ".gnu.linkonce.wi.",
+		".zdebug", ".line", ".stab"} {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return name == ".gdb_index"
+}
@@ -53,7 +53,10 @@ func renderPretty(rows *sql.Rows, format PrettyFormat) string {
 		// User reflection to determine each row's value type
 		for i := range cols {
 			val := columnPointers[i].(*interface{})
-			if *val != nil {
+			if *val == nil {
+				// Keep NULL values so the remaining columns stay aligned
+				tr = append(tr, "")
+			} else {
 				switch reflect.Indirect(reflect.ValueOf(val)).Elem().Kind() {
 				case reflect.String:
 					tr = append(tr, fmt.Sprintf("%s", *val))
@@ -67,6 +70,11 @@ func renderPretty(rows *sql.Rows, format PrettyFormat) string {
 		t.AppendRow(tr)
 	}
 
+	return renderTable(t, format)
+}
+
+// renderTable renders a populated table in the requested format
+func renderTable(t table.Writer, format PrettyFormat) string {
 	switch format {
 	case PrettyASCII:
 		return fmt.Sprintf("%s\n", t.Render())
@@ -87,3 +95,35 @@ func renderPretty(rows *sql.Rows, format PrettyFormat) string {
 		return fmt.Sprintf("%s\n", t.Render())
 	}
 }
+
+// Table styles used for each DisplayFormat
+var prettyFormats = map[DisplayFormat]PrettyFormat{
+	DFText:      PrettyASCII,
+	DFPretty:    PrettyUnicode,
+	DFPrettyCol: PrettyColor,
+	DFCSV:       PrettyCSV,
+	DFMarkdown:  PrettyMarkdown,
+	DFHtml:      PrettyHTML,
+}
+
+// RenderTable renders rows of values that don't come from the database, such
+// as command output, using the same table styles as RunQuery. The title is
+// omitted if empty. JSON isn't a table format, and should be produced with
+// encoding/json instead.
+func RenderTable(title string, header []string, rows [][]interface{}, format DisplayFormat) string {
+	t := table.NewWriter()
+	if title != "" {
+		t.SetTitle(title)
+	}
+
+	var tr table.Row
+	for _, col := range header {
+		tr = append(tr, col)
+	}
+	t.AppendHeader(tr)
+	for _, row := range rows {
+		t.AppendRow(table.Row(row))
+	}
+
+	return renderTable(t, prettyFormats[format])
+}
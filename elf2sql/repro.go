@@ -0,0 +1,545 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SectionDiff compares a section between two builds. Kind is 'loadable',
+// 'debug', 'build-id', 'symbols' or 'other', and Status is 'same',
+// 'differs' (same size, different bytes), 'moved' (same bytes, different
+// address), 'resized', 'added' or 'removed'.
+type SectionDiff struct {
+	Name   string
+	Kind   string
+	Status string
+	AddrA  uint64
+	AddrB  uint64
+	SizeA  uint64
+	SizeB  uint64
+	Bytes  uint64 // Number of differing bytes, when the size is the same
+}
+
+// SymbolDiff compares a code or data symbol between two builds. Status is
+// 'changed' (same size, different contents), 'relocated' (differs only in
+// the addresses of the symbols it refers to), 'moved', 'resized', 'added'
+// or 'removed'. Symbols that are the same in both builds aren't listed.
+type SymbolDiff struct {
+	Name    string
+	Section string
+	Status  string
+	AddrA   uint64
+	AddrB   uint64
+	SizeA   uint64
+	SizeB   uint64
+}
+
+// Shift is a change in the offset between the addresses of symbols in two
+// builds, typically caused by a function that grew or shrank and moved
+// everything after it.
+type Shift struct {
+	Section string
+	Cause   string // Symbol before the shift that changed size, if known
+	Delta   int64  // Change in offset, in bytes
+	Moved   int    // Number of symbols that moved by the new offset
+}
+
+// StringDiff is an embedded path or timestamp found in only one build
+type StringDiff struct {
+	Kind    string // 'path' or 'timestamp'
+	Section string
+	A       string // Value in the first build, empty if not found
+	B       string // Value in the second build, empty if not found
+}
+
+// ReproReport explains the differences between two builds of the same
+// firmware, for tracking down non-reproducible builds.
+type ReproReport struct {
+	Identical bool // Files are byte-for-byte identical
+	SameLoad  bool // Loadable sections are identical
+	BuildIDA  string
+	BuildIDB  string
+	Sections  []SectionDiff
+	Symbols   []SymbolDiff
+	Shifts    []Shift
+	Strings   []StringDiff
+	Causes    []string
+}
+
+// sectionKind classifies a section for the report.
+func sectionKind(s *elf.Section) string {
+	switch {
+	case s.Type == elf.SHT_NOTE && s.Name == ".note.gnu.build-id":
+		return "build-id"
+	case strings.HasPrefix(s.Name, ".debug") || strings.HasPrefix(s.Name, ".zdebug") ||
+		s.Name == ".comment" || s.Name == ".gnu_debuglink" || s.Name == ".stab" ||
+		s.Name == ".stabstr":
+		return "debug"
+	case s.Flags&elf.SHF_ALLOC != 0:
+		return "loadable"
+	case s.Type == elf.SHT_SYMTAB || s.Type == elf.SHT_STRTAB:
+		return "symbols"
+	}
+	return "other"
+}
+
+// BuildID returns the GNU build ID of the image in hex, or an empty string.
+func (img *Image) BuildID() string {
+	s := img.File.Section(".note.gnu.build-id")
+	if s == nil {
+		return ""
+	}
+	d, e := s.Data()
+	if e != nil || len(d) < 12 {
+		return ""
+	}
+	order := img.File.ByteOrder
+	namesz, descsz := order.Uint32(d), order.Uint32(d[4:])
+	off := 12 + (uint64(namesz)+3)&^3
+	if off+uint64(descsz) > uint64(len(d)) {
+		return ""
+	}
+	return hex.EncodeToString(d[off : off+uint64(descsz)])
+}
+
+// keyedSections returns the image's sections by name, with a '#n' suffix
+// for repeated names.
+func keyedSections(img *Image) ([]string, map[string]*elf.Section) {
+	var keys []string
+	secs := make(map[string]*elf.Section)
+	for _, s := range img.File.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		key := s.Name
+		for n := 2; secs[key] != nil; n++ {
+			key = fmt.Sprintf("%s#%d", s.Name, n)
+		}
+		keys = append(keys, key)
+		secs[key] = s
+	}
+	return keys, secs
+}
+
+// compareSections compares the sections of two images by name.
+func compareSections(a, b *Image) []SectionDiff {
+	keysA, secsA := keyedSections(a)
+	keysB, secsB := keyedSections(b)
+
+	var diffs []SectionDiff
+	for _, k := range keysA {
+		sa, sb := secsA[k], secsB[k]
+		d := SectionDiff{Name: k, Kind: sectionKind(sa), AddrA: sa.Addr, SizeA: sa.Size}
+		if sb == nil {
+			d.Status = "removed"
+			diffs = append(diffs, d)
+			continue
+		}
+		d.AddrB, d.SizeB = sb.Addr, sb.Size
+
+		var da, db []byte
+		if sa.Type != elf.SHT_NOBITS {
+			da, _ = sa.Data()
+		}
+		if sb.Type != elf.SHT_NOBITS {
+			db, _ = sb.Data()
+		}
+		switch {
+		case sa.Size != sb.Size || len(da) != len(db):
+			d.Status = "resized"
+		case !bytes.Equal(da, db):
+			d.Status = "differs"
+			for i := range da {
+				if da[i] != db[i] {
+					d.Bytes++
+				}
+			}
+		case sa.Addr != sb.Addr:
+			d.Status = "moved"
+		default:
+			d.Status = "same"
+		}
+		diffs = append(diffs, d)
+	}
+	for _, k := range keysB {
+		if secsA[k] == nil {
+			sb := secsB[k]
+			diffs = append(diffs, SectionDiff{Name: k, Kind: sectionKind(sb), Status: "added",
+				AddrB: sb.Addr, SizeB: sb.Size})
+		}
+	}
+	return diffs
+}
+
+// reproSymbol is a code or data symbol being compared
+type reproSymbol struct {
+	key     string
+	sym     elf.Symbol
+	addr    uint64
+	section string
+}
+
+// reproSymbols returns the image's sized code and data symbols in
+// allocated sections, keyed by name with a '#n' suffix for repeated
+// (local) names.
+func reproSymbols(img *Image) ([]reproSymbol, map[string]reproSymbol) {
+	var list []reproSymbol
+	byKey := make(map[string]reproSymbol)
+	for _, s := range img.Symbols {
+		t := elf.ST_TYPE(s.Info)
+		if s.Name == "" || s.Size == 0 || (t != elf.STT_FUNC && t != elf.STT_OBJECT) ||
+			s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE ||
+			int(s.Section) >= len(img.File.Sections) {
+			continue
+		}
+		sec := img.File.Sections[s.Section]
+		if sec.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		key := s.Name
+		for n := 2; ; n++ {
+			if _, ok := byKey[key]; !ok {
+				break
+			}
+			key = fmt.Sprintf("%s#%d", s.Name, n)
+		}
+		r := reproSymbol{key, s, img.SymbolAddr(s), sec.Name}
+		list = append(list, r)
+		byKey[key] = r
+	}
+	return list, byKey
+}
+
+// reproHashes returns the code hashes of the image's functions, keyed like
+// the symbols returned by reproSymbols, so that local functions with the
+// same name are told apart.
+func reproHashes(img *Image, list []reproSymbol) map[string]string {
+	type funcKey struct {
+		section string
+		addr    uint64
+	}
+	byAddr := make(map[funcKey]string)
+	for _, h := range img.CodeHashes() {
+		byAddr[funcKey{h.Section, h.Address}] = h.Hash
+	}
+	hashes := make(map[string]string)
+	for _, r := range list {
+		h, ok := byAddr[funcKey{r.section, r.addr}]
+		if ok && elf.ST_TYPE(r.sym.Info) == elf.STT_FUNC {
+			hashes[r.key] = h
+		}
+	}
+	return hashes
+}
+
+// symbolBytes returns the contents of a symbol, or nil for NOBITS data.
+func symbolBytes(img *Image, r reproSymbol) []byte {
+	sec := img.File.Sections[r.sym.Section]
+	if sec.Type == elf.SHT_NOBITS {
+		return nil
+	}
+	buf := make([]byte, r.sym.Size)
+	if _, e := sec.ReadAt(buf, int64(r.addr-sec.Addr)); e != nil {
+		return nil
+	}
+	return buf
+}
+
+// sameReferences indicates if two versions of a data object differ only in
+// pointers to the same symbols at different addresses.
+func sameReferences(a, b *Image, da, db []byte) bool {
+	size := int(a.ptrSize())
+	if len(da)%size != 0 {
+		return false
+	}
+	for i := 0; i < len(da); i += size {
+		wa := readUint(da[i:i+size], a.File.ByteOrder)
+		wb := readUint(db[i:i+size], b.File.ByteOrder)
+		if wa == wb {
+			continue
+		}
+		sa, oa, okA := a.SymbolAt(a.CodeAddr(wa))
+		sb, ob, okB := b.SymbolAt(b.CodeAddr(wb))
+		if !okA || !okB || sa.Name != sb.Name || oa != ob {
+			return false
+		}
+	}
+	return true
+}
+
+// compareSymbols compares the code and data symbols of two images, and
+// finds the shifts in their addresses.
+func compareSymbols(a, b *Image) ([]SymbolDiff, []Shift) {
+	listA, symsA := reproSymbols(a)
+	listB, symsB := reproSymbols(b)
+
+	// Functions that differ only in the addresses they refer to have the
+	// same code hash
+	hashA, hashB := reproHashes(a, listA), reproHashes(b, listB)
+
+	var diffs []SymbolDiff
+	type common struct{ a, b reproSymbol }
+	var both []common
+	for _, ra := range listA {
+		d := SymbolDiff{Name: ra.key, Section: ra.section, AddrA: ra.addr, SizeA: ra.sym.Size}
+		rb, ok := symsB[ra.key]
+		if !ok {
+			d.Status = "removed"
+			diffs = append(diffs, d)
+			continue
+		}
+		both = append(both, common{ra, rb})
+		d.AddrB, d.SizeB = rb.addr, rb.sym.Size
+
+		da, db := symbolBytes(a, ra), symbolBytes(b, rb)
+		switch {
+		case ra.sym.Size != rb.sym.Size:
+			d.Status = "resized"
+		case !bytes.Equal(da, db):
+			if elf.ST_TYPE(ra.sym.Info) == elf.STT_FUNC {
+				if h, ok := hashA[ra.key]; ok && h == hashB[ra.key] {
+					d.Status = "relocated"
+					break
+				}
+			} else if sameReferences(a, b, da, db) {
+				d.Status = "relocated"
+				break
+			}
+			d.Status = "changed"
+		case ra.addr != rb.addr:
+			d.Status = "moved"
+		default:
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+	for _, rb := range listB {
+		if _, ok := symsA[rb.key]; !ok {
+			diffs = append(diffs, SymbolDiff{Name: rb.key, Section: rb.section, Status: "added",
+				AddrB: rb.addr, SizeB: rb.sym.Size})
+		}
+	}
+
+	// Walk the common symbols of each section in address order, and
+	// attribute each change in their offset to the symbol before it, if that
+	// changed size
+	sort.SliceStable(both, func(i, j int) bool {
+		if both[i].a.section != both[j].a.section {
+			return both[i].a.sym.Section < both[j].a.sym.Section
+		}
+		return both[i].a.addr < both[j].a.addr
+	})
+	var shifts []Shift
+	var prev int64
+	for i, c := range both {
+		first := i == 0 || c.a.section != both[i-1].a.section
+		if first {
+			prev = 0
+		}
+		off := int64(c.b.addr - c.a.addr)
+		if off != prev {
+			s := Shift{Section: c.a.section, Delta: off - prev}
+			if !first && both[i-1].a.sym.Size != both[i-1].b.sym.Size {
+				s.Cause = both[i-1].a.key
+			}
+			for j := i; j < len(both) && both[j].a.section == c.a.section &&
+				int64(both[j].b.addr-both[j].a.addr) == off; j++ {
+				s.Moved++
+			}
+			shifts = append(shifts, s)
+		}
+		prev = off
+	}
+
+	return diffs, shifts
+}
+
+// Patterns for embedded paths and timestamps, as from __FILE__, __DATE__
+// and __TIME__ or DW_AT_comp_dir
+var (
+	pathPattern = regexp.MustCompile(`(?:[A-Za-z]:\\|/)[\w.+-]+(?:[/\\][\w.+-]+)+`)
+	timePattern = regexp.MustCompile(`\b(?:(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ 0-3]\d \d{4}|\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}(?::\d{2})?)?|\d{2}:\d{2}:\d{2})\b`)
+)
+
+// embeddedStrings returns the paths or timestamps matching re in each
+// section with contents, in order and without repeats.
+func embeddedStrings(img *Image, re *regexp.Regexp) map[string][]string {
+	found := make(map[string][]string)
+	for _, s := range img.File.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL || s.Type == elf.SHT_SYMTAB ||
+			(s.Type == elf.SHT_STRTAB && s.Flags&elf.SHF_ALLOC == 0) {
+			continue
+		}
+		data, e := s.Data()
+		if e != nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, r := range printableRuns(data, MinStringLength) {
+			for _, m := range re.FindAllString(string(data[r[0]:r[1]]), -1) {
+				if !seen[m] {
+					seen[m] = true
+					found[s.Name] = append(found[s.Name], m)
+				}
+			}
+		}
+	}
+	return found
+}
+
+// compareStrings pairs up the paths or timestamps found in only one of the
+// images, section by section.
+func compareStrings(a, b *Image, kind string, re *regexp.Regexp) []StringDiff {
+	foundA, foundB := embeddedStrings(a, re), embeddedStrings(b, re)
+	var names []string
+	for n := range foundA {
+		names = append(names, n)
+	}
+	for n := range foundB {
+		if _, ok := foundA[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []StringDiff
+	for _, n := range names {
+		inB := make(map[string]bool)
+		for _, s := range foundB[n] {
+			inB[s] = true
+		}
+		inA := make(map[string]bool)
+		var onlyA, onlyB []string
+		for _, s := range foundA[n] {
+			inA[s] = true
+			if !inB[s] {
+				onlyA = append(onlyA, s)
+			}
+		}
+		for _, s := range foundB[n] {
+			if !inA[s] {
+				onlyB = append(onlyB, s)
+			}
+		}
+		for i := 0; i < len(onlyA) || i < len(onlyB); i++ {
+			d := StringDiff{Kind: kind, Section: n}
+			if i < len(onlyA) {
+				d.A = onlyA[i]
+			}
+			if i < len(onlyB) {
+				d.B = onlyB[i]
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// Repro compares two builds of the same firmware and explains why they
+// differ: which sections and symbols differ, whether the loadable contents
+// are affected, and whether the differences come from the build ID,
+// embedded paths and timestamps, or symbols moved by one that changed size.
+func Repro(a, b *Image) *ReproReport {
+	r := &ReproReport{Identical: bytes.Equal(a.Raw, b.Raw), BuildIDA: a.BuildID(),
+		BuildIDB: b.BuildID()}
+	r.Sections = compareSections(a, b)
+	r.Symbols, r.Shifts = compareSymbols(a, b)
+	r.Strings = append(compareStrings(a, b, "path", pathPattern),
+		compareStrings(a, b, "timestamp", timePattern)...)
+
+	// Summarise the kinds of sections that differ
+	r.SameLoad = true
+	differs, loadable := make(map[string]int), 0
+	for _, s := range r.Sections {
+		if s.Kind == "loadable" {
+			loadable++
+		}
+		if s.Status == "same" {
+			continue
+		}
+		differs[s.Kind]++
+		if s.Kind == "loadable" {
+			r.SameLoad = false
+		}
+	}
+	counts := make(map[string]int)
+	for _, s := range r.Symbols {
+		counts[s.Status]++
+	}
+
+	switch {
+	case r.Identical:
+		r.Causes = append(r.Causes, "The files are identical")
+		return r
+	case r.SameLoad:
+		var kinds []string
+		for _, k := range []string{"debug", "build-id", "symbols", "other"} {
+			if differs[k] > 0 {
+				kinds = append(kinds, map[string]string{"debug": "debug information",
+					"build-id": "the build ID", "symbols": "symbol tables",
+					"other": "other non-loadable sections"}[k])
+			}
+		}
+		msg := "The loadable contents are identical"
+		if len(kinds) > 0 {
+			msg += "; only " + strings.Join(kinds, ", ") + " differ"
+		}
+		r.Causes = append(r.Causes, msg)
+	default:
+		r.Causes = append(r.Causes, fmt.Sprintf("The loadable contents differ in %d of %d sections",
+			differs["loadable"], loadable))
+	}
+
+	if r.BuildIDA != r.BuildIDB {
+		r.Causes = append(r.Causes, "The build ID differs, as it's a hash of the rest of the file")
+	}
+	paths, times := make(map[string]bool), make(map[string]bool)
+	for _, s := range r.Strings {
+		if s.Kind == "path" {
+			paths[s.Section] = true
+		} else {
+			times[s.Section] = true
+		}
+	}
+	for _, c := range []struct {
+		what  string
+		where map[string]bool
+	}{{"Embedded paths", paths}, {"Timestamps", times}} {
+		if len(c.where) > 0 {
+			var secs []string
+			for s := range c.where {
+				secs = append(secs, s)
+			}
+			sort.Strings(secs)
+			r.Causes = append(r.Causes, fmt.Sprintf("%s differ in %s", c.what,
+				strings.Join(secs, ", ")))
+		}
+	}
+	for _, s := range r.Shifts {
+		if s.Cause != "" {
+			r.Causes = append(r.Causes, fmt.Sprintf("'%s' changed size, moving the symbols after it in %s by %d bytes",
+				s.Cause, s.Section, s.Delta))
+		}
+	}
+	for _, c := range []struct {
+		status string
+		what   string
+	}{
+		{"changed", "Symbols with different contents but the same size"},
+		{"relocated", "Symbols that differ only in the addresses they refer to"},
+		{"resized", "Symbols that changed size"},
+		{"added", "Symbols added"},
+		{"removed", "Symbols removed"},
+	} {
+		if counts[c.status] > 0 {
+			r.Causes = append(r.Causes, fmt.Sprintf("%s: %d", c.what, counts[c.status]))
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,90 @@
+package elf2sql
+
+import (
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlDriver is the name of the SQLite driver that exposes the custom ELF
+// functions to queries.
+const sqlDriver = "sqlite3_elfquery"
+
+func init() {
+	sql.Register(sqlDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return registerFuncs(conn)
+		},
+	})
+}
+
+// registerFuncs adds the custom SQL functions to a new connection.
+func registerFuncs(conn *sqlite3.SQLiteConn) error {
+	e := conn.RegisterFunc("value", sqlValue, true)
+	if e != nil {
+		return e
+	}
+	e = conn.RegisterFunc("sym_at", sqlSymAt, true)
+	if e != nil {
+		return e
+	}
+	return conn.RegisterFunc("peripheral_at", sqlPeripheralAt, true)
+}
+
+// sqlValue implements 'value(symbol [, type])', returning NULL when the
+// symbol can't be decoded. In an archive, the symbol is read from the first
+// member that defines it.
+func sqlValue(symbol string, typ ...string) interface{} {
+	img := curImage
+	for _, m := range curMembers {
+		if _, ok := m.Lookup(symbol); ok {
+			img = m
+			break
+		}
+	}
+	if img == nil {
+		return nil
+	}
+
+	t := ""
+	if len(typ) > 0 {
+		t = typ[0]
+	}
+	v, e := img.Value(symbol, t)
+	if e != nil {
+		return nil
+	}
+
+	return v
+}
+
+// sqlSymAt implements 'sym_at(addr)', returning the symbol containing the
+// address as 'symbol+0xoffset', or NULL if there isn't one.
+func sqlSymAt(addr int64) interface{} {
+	if curImage == nil {
+		return nil
+	}
+
+	sym, off, ok := curImage.SymbolAt(curImage.CodeAddr(uint64(addr)))
+	if !ok {
+		return nil
+	}
+
+	return AddrInfo{Symbol: sym.Name, Offset: off}.Location()
+}
+
+// sqlPeripheralAt implements 'peripheral_at(addr)', returning the peripheral
+// register at the address as 'PERIPHERAL.REGISTER', or NULL if the address
+// isn't in a peripheral or no SVD file was loaded.
+func sqlPeripheralAt(addr int64) interface{} {
+	if curDevice == nil {
+		return nil
+	}
+
+	loc, ok := curDevice.PeripheralLocation(uint64(addr))
+	if !ok {
+		return nil
+	}
+
+	return loc
+}
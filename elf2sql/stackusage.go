@@ -0,0 +1,216 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"bufio"
+	"debug/elf"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StackUsageDir is a directory to search recursively for GCC '.su' files
+// when populating the 'stack_usage' table. The table is empty if not set.
+var StackUsageDir string
+
+const createStackUsageTable string = `CREATE TABLE stack_usage (
+	ID        integer primary key autoincrement,
+	File      text,
+	Line      integer,
+	Function  text,
+	Bytes     integer,
+	Qualifier text,
+	Value     integer
+	)`
+
+// StackUsage is a single entry from a GCC '-fstack-usage' (.su) file
+type StackUsage struct {
+	File      string // Source file, as recorded by the compiler
+	Line      int    // Line number of the function definition
+	Function  string // Function name
+	Bytes     uint64 // Stack frame size in bytes
+	Qualifier string // 'static', 'dynamic' or 'dynamic,bounded'
+}
+
+// ReadStackUsage recursively parses every .su file found in dir.
+func ReadStackUsage(dir string) ([]StackUsage, error) {
+	var entries []StackUsage
+	e := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".su" {
+			return nil
+		}
+		su, err := readStackUsageFile(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, su...)
+		return nil
+	})
+
+	return entries, e
+}
+
+// readStackUsageFile parses a single .su file. Each line has the form
+// 'file.c:line:col:function<TAB>bytes<TAB>qualifier'.
+func readStackUsageFile(path string) ([]StackUsage, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	var entries []StackUsage
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		bytes, e := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if e != nil {
+			continue
+		}
+
+		// The function name follows the last ':', except for C++ names
+		// which can contain '::', so split off the location from the left
+		su := StackUsage{Bytes: bytes, Qualifier: strings.TrimSpace(fields[2])}
+		loc := strings.SplitN(fields[0], ":", 4)
+		switch len(loc) {
+		case 4:
+			su.File = loc[0]
+			su.Line, _ = strconv.Atoi(loc[1])
+			su.Function = loc[3]
+		case 3:
+			su.File = loc[0]
+			su.Line, _ = strconv.Atoi(loc[1])
+			su.Function = loc[2]
+		default:
+			su.Function = fields[0]
+		}
+		entries = append(entries, su)
+	}
+
+	return entries, scanner.Err()
+}
+
+// fileSymbol is a function symbol with the source file it was defined in
+type fileSymbol struct {
+	sym  elf.Symbol
+	file string
+}
+
+// functionsByName indexes the defined function symbols by name, recording
+// the STT_FILE symbol that precedes each group of local symbols.
+func (img *Image) functionsByName() map[string][]fileSymbol {
+	if img.funcNames != nil {
+		return img.funcNames
+	}
+
+	img.funcNames = make(map[string][]fileSymbol)
+	curFile := ""
+	for _, s := range img.Symbols {
+		t := elf.ST_TYPE(s.Info)
+		if t == elf.STT_FILE {
+			curFile = s.Name
+			continue
+		}
+		if t != elf.STT_FUNC || s.Section == elf.SHN_UNDEF {
+			continue
+		}
+		img.funcNames[s.Name] = append(img.funcNames[s.Name], fileSymbol{s, curFile})
+	}
+
+	return img.funcNames
+}
+
+// matchFunction finds the function symbol described by a '.su' entry. Static
+// functions with the same name in several files are told apart using the
+// STT_FILE symbol that precedes each group of local symbols.
+func (img *Image) matchFunction(name, file string) (elf.Symbol, bool) {
+	var match elf.Symbol
+	found := false
+	for _, fs := range img.functionsByName()[name] {
+		s := fs.sym
+		if elf.ST_BIND(s.Info) == elf.STB_LOCAL && filepath.Base(fs.file) == filepath.Base(file) {
+			return s, true
+		}
+		if !found || elf.ST_BIND(s.Info) != elf.STB_LOCAL {
+			match = s
+			found = true
+		}
+	}
+
+	return match, found
+}
+
+// stackFrames reads the frame size of each function from the 'stack_usage'
+// table, indexed by the function's address. Where several entries match
+// the same function, the largest frame is used.
+func stackFrames(img *Image) (map[uint64]StackUsage, error) {
+	rows, e := DBCon.Query(`SELECT File, Line, Function, Bytes, Qualifier, Value
+		FROM stack_usage WHERE Value IS NOT NULL`)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	frames := make(map[uint64]StackUsage)
+	for rows.Next() {
+		var su StackUsage
+		var value uint64
+		e = rows.Scan(&su.File, &su.Line, &su.Function, &su.Bytes, &su.Qualifier, &value)
+		if e != nil {
+			return nil, e
+		}
+		addr := img.CodeAddr(value)
+		if cur, ok := frames[addr]; !ok || su.Bytes > cur.Bytes {
+			frames[addr] = su
+		}
+	}
+
+	return frames, rows.Err()
+}
+
+// insertStackUsage populates the 'stack_usage' table from the '.su' files
+// in StackUsageDir. Each entry records the value of the matching symbol, so
+// that the table can be joined to 'symbols' on both Name and Value. img is
+// nil when an archive was loaded, and Value is then always NULL.
+func insertStackUsage(img *Image) error {
+	if StackUsageDir == "" {
+		return nil
+	}
+	entries, e := ReadStackUsage(StackUsageDir)
+	if e != nil {
+		return e
+	}
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO stack_usage VALUES (NULL,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, su := range entries {
+		var value interface{}
+		if img != nil {
+			if sym, ok := img.matchFunction(su.Function, su.File); ok {
+				value = sym.Value
+			}
+		}
+		_, e = stmt.Exec(su.File, su.Line, su.Function, su.Bytes, su.Qualifier, value)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,114 @@
+package elf2sql
+
+import (
+	"debug/elf"
+)
+
+// MinStringLength is the minimum number of printable characters required
+// for a byte sequence to be recorded in the 'strings' table.
+var MinStringLength = 4
+
+// StringEntry describes a printable string found in the memory image
+type StringEntry struct {
+	Address uint64
+	Section string
+	Symbol  string
+	Value   string
+}
+
+const createStringTable string = `CREATE TABLE strings (
+	ID      integer primary key autoincrement,
+	Address integer,
+	Section text,
+	Symbol  text,
+	Length  integer,
+	Value   text,
+	Member  text
+	)`
+
+// isPrintable indicates if c can be part of a printable string, using the
+// same character set as GNU 'strings' plus line breaks, which are common in
+// log format strings.
+func isPrintable(c byte) bool {
+	return (c >= 0x20 && c < 0x7F) || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Strings scans the non-executable allocated sections of the image for runs
+// of at least minLen printable characters. The owning symbol is looked up
+// from the string's address, and is empty for anonymous literals.
+func (img *Image) Strings(minLen int) []StringEntry {
+	var strs []StringEntry
+
+	for _, sec := range img.File.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || sec.Flags&elf.SHF_EXECINSTR != 0 ||
+			sec.Type == elf.SHT_NOBITS || sec.Size == 0 {
+			continue
+		}
+		data, e := sec.Data()
+		if e != nil {
+			continue
+		}
+
+		for _, r := range printableRuns(data, minLen) {
+			addr := sec.Addr + uint64(r[0])
+			entry := StringEntry{
+				Address: addr,
+				Section: sec.Name,
+				Value:   string(data[r[0]:r[1]]),
+			}
+			if sym, _, ok := img.SymbolAt(addr); ok && img.File.Type != elf.ET_REL {
+				entry.Symbol = sym.Name
+			}
+			strs = append(strs, entry)
+		}
+	}
+
+	return strs
+}
+
+// printableRuns returns the start and end offsets of each run of at least
+// minLen printable characters in data.
+func printableRuns(data []byte, minLen int) [][2]int {
+	var runs [][2]int
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && isPrintable(data[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minLen {
+			runs = append(runs, [2]int{start, i})
+		}
+		start = -1
+	}
+	return runs
+}
+
+// insertStrings populates the 'strings' table from the supplied image.
+func insertStrings(img *Image, member interface{}) error {
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	stmt, e := tx.Prepare(`INSERT INTO strings VALUES (NULL,?,?,?,?,?,?)`)
+	if e != nil {
+		return e
+	}
+	defer stmt.Close()
+
+	for _, s := range img.Strings(MinStringLength) {
+		var sym interface{}
+		if s.Symbol != "" {
+			sym = s.Symbol
+		}
+		_, e = stmt.Exec(s.Address, s.Section, sym, len(s.Value), s.Value, member)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,489 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microbuilder/elfquery/disasm"
+)
+
+// SVDFile is a CMSIS-SVD device description to load into the 'peripherals',
+// 'registers' and 'peripheral_refs' tables. The tables are empty if not set.
+var SVDFile string
+
+// curDevice is the device loaded by InitDB, used by 'peripheral_at()'
+var curDevice *SVDDevice
+
+const createPeripheralTable string = `CREATE TABLE peripherals (
+	ID          integer primary key autoincrement,
+	Name        text,
+	GroupName   text,
+	Description text,
+	Address     integer,
+	Size        integer,
+	DerivedFrom text
+	)`
+
+const createRegisterTable string = `CREATE TABLE registers (
+	ID          integer primary key autoincrement,
+	Peripheral  text,
+	Name        text,
+	Description text,
+	Address     integer,
+	Offset      integer,
+	Size        integer,
+	Access      text,
+	ResetValue  integer
+	)`
+
+const createPeripheralRefTable string = `CREATE TABLE peripheral_refs (
+	ID         integer primary key autoincrement,
+	Symbol     text,
+	Kind       text,
+	Address    integer,
+	Target     integer,
+	Peripheral text,
+	Register   text
+	)`
+
+// Peripheral is a memory-mapped peripheral from an SVD file. Size covers
+// the peripheral's address blocks, or its registers if it has none.
+type Peripheral struct {
+	Name        string
+	Group       string
+	Description string
+	DerivedFrom string
+	Address     uint64
+	Size        uint64
+	Registers   []Register
+}
+
+// Register is a single register of a peripheral. Registers in clusters and
+// arrays are listed individually, with names such as 'CH[2].CTRL'.
+type Register struct {
+	Name        string
+	Description string
+	Address     uint64
+	Offset      uint64 // Offset from the peripheral's base address
+	Size        int    // Size in bits
+	Access      string
+	Reset       uint64
+	HasReset    bool
+}
+
+// SVDDevice is a device description, with its peripherals sorted by address
+type SVDDevice struct {
+	Name        string
+	Peripherals []*Peripheral
+}
+
+// PeripheralRef is a reference to a peripheral's address, either loaded by
+// a function from its literal pool or stored in an initialised object.
+type PeripheralRef struct {
+	Symbol     string
+	Kind       string // 'code' or 'data'
+	Address    uint64 // Address of the instruction or pointer
+	Target     uint64
+	Peripheral string
+	Register   string // Empty if the target isn't a register
+}
+
+// Register properties, which are inherited by peripherals, clusters and
+// registers from their parents
+type svdProps struct {
+	Size       string `xml:"size"`
+	Access     string `xml:"access"`
+	ResetValue string `xml:"resetValue"`
+}
+
+// Array properties of peripherals, clusters and registers
+type svdDim struct {
+	Dim          string `xml:"dim"`
+	DimIncrement string `xml:"dimIncrement"`
+	DimIndex     string `xml:"dimIndex"`
+}
+
+type svdRegister struct {
+	svdProps
+	svdDim
+	Name          string `xml:"name"`
+	Description   string `xml:"description"`
+	AddressOffset string `xml:"addressOffset"`
+}
+
+type svdCluster struct {
+	svdProps
+	svdDim
+	Name          string        `xml:"name"`
+	AddressOffset string        `xml:"addressOffset"`
+	Registers     []svdRegister `xml:"register"`
+	Clusters      []svdCluster  `xml:"cluster"`
+}
+
+type svdAddressBlock struct {
+	Offset string `xml:"offset"`
+	Size   string `xml:"size"`
+}
+
+type svdPeripheral struct {
+	svdProps
+	svdDim
+	DerivedFrom   string            `xml:"derivedFrom,attr"`
+	Name          string            `xml:"name"`
+	Description   string            `xml:"description"`
+	GroupName     string            `xml:"groupName"`
+	BaseAddress   string            `xml:"baseAddress"`
+	AddressBlocks []svdAddressBlock `xml:"addressBlock"`
+	Registers     *svdCluster       `xml:"registers"`
+}
+
+type svdDevice struct {
+	svdProps
+	Name        string          `xml:"name"`
+	Peripherals []svdPeripheral `xml:"peripherals>peripheral"`
+}
+
+// inherit returns the properties with any unset values taken from parent.
+func (p svdProps) inherit(parent svdProps) svdProps {
+	if p.Size == "" {
+		p.Size = parent.Size
+	}
+	if p.Access == "" {
+		p.Access = parent.Access
+	}
+	if p.ResetValue == "" {
+		p.ResetValue = parent.ResetValue
+	}
+	return p
+}
+
+// parseSVDInt reads an SVD scaled non-negative integer, which can be
+// decimal, hex ('0x') or binary ('#', with 'x' for don't care bits).
+func parseSVDInt(s string) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return 0, false
+	case strings.HasPrefix(s, "#"):
+		v, e := strconv.ParseUint(strings.ReplaceAll(s[1:], "x", "0"), 2, 64)
+		return v, e == nil
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		v, e := strconv.ParseUint(s[2:], 16, 64)
+		return v, e == nil
+	}
+	v, e := strconv.ParseUint(s, 10, 64)
+	return v, e == nil
+}
+
+// svdText collapses the whitespace of multi-line descriptions.
+func svdText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// expand returns the names and offsets of the elements of an array, or the
+// name alone if it isn't one. Names contain '%s' for the index, as in
+// 'CH%s' or 'BUF[%s]'.
+func (d svdDim) expand(name string) ([]string, []uint64) {
+	n, ok := parseSVDInt(d.Dim)
+	if !ok || !strings.Contains(name, "%s") {
+		return []string{name}, []uint64{0}
+	}
+	inc, _ := parseSVDInt(d.DimIncrement)
+
+	// The indices are listed ('A,B,C'), a range ('0-7' or 'A-D') or 0..n-1
+	var index []string
+	if lo, hi, ok := strings.Cut(d.DimIndex, "-"); ok {
+		if a, e := strconv.Atoi(lo); e == nil {
+			b, _ := strconv.Atoi(hi)
+			for i := a; i <= b; i++ {
+				index = append(index, strconv.Itoa(i))
+			}
+		} else if len(lo) == 1 && len(hi) == 1 {
+			for c := lo[0]; c <= hi[0]; c++ {
+				index = append(index, string(c))
+			}
+		}
+	} else if d.DimIndex != "" {
+		index = strings.Split(d.DimIndex, ",")
+	}
+	if len(index) == 0 {
+		for i := uint64(0); i < n; i++ {
+			index = append(index, strconv.FormatUint(i, 10))
+		}
+	}
+
+	var names []string
+	var offsets []uint64
+	for i := 0; i < int(n) && i < len(index); i++ {
+		names = append(names, strings.ReplaceAll(name, "%s", strings.TrimSpace(index[i])))
+		offsets = append(offsets, uint64(i)*inc)
+	}
+	return names, offsets
+}
+
+// registers flattens a cluster into its registers, with names prefixed by
+// the cluster names and offsets relative to the peripheral.
+func (c *svdCluster) registers(prefix string, base uint64, props svdProps) []Register {
+	var regs []Register
+	for _, r := range c.Registers {
+		rp := r.svdProps.inherit(props)
+		offset, _ := parseSVDInt(r.AddressOffset)
+		names, offsets := r.svdDim.expand(r.Name)
+		for i, name := range names {
+			reg := Register{Name: prefix + name, Description: svdText(r.Description),
+				Offset: base + offset + offsets[i], Size: 32, Access: rp.Access}
+			if size, ok := parseSVDInt(rp.Size); ok {
+				reg.Size = int(size)
+			}
+			reg.Reset, reg.HasReset = parseSVDInt(rp.ResetValue)
+			regs = append(regs, reg)
+		}
+	}
+	for i := range c.Clusters {
+		sub := &c.Clusters[i]
+		offset, _ := parseSVDInt(sub.AddressOffset)
+		names, offsets := sub.svdDim.expand(sub.Name)
+		for j, name := range names {
+			regs = append(regs, sub.registers(prefix+name+".", base+offset+offsets[j],
+				sub.svdProps.inherit(props))...)
+		}
+	}
+	return regs
+}
+
+// ReadSVD parses a CMSIS-SVD file. Derived peripherals take the registers
+// and address blocks of the peripheral they're derived from.
+func ReadSVD(filename string) (*SVDDevice, error) {
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, e
+	}
+	var dev svdDevice
+	e = xml.Unmarshal(b, &dev)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", filename, e)
+	}
+
+	byName := make(map[string]*svdPeripheral)
+	for i := range dev.Peripherals {
+		byName[dev.Peripherals[i].Name] = &dev.Peripherals[i]
+	}
+
+	d := &SVDDevice{Name: dev.Name}
+	for _, sp := range dev.Peripherals {
+		if sp.DerivedFrom != "" {
+			from := sp.DerivedFrom[strings.LastIndex(sp.DerivedFrom, ".")+1:]
+			if base, ok := byName[from]; ok {
+				if sp.Registers == nil {
+					sp.Registers = base.Registers
+				}
+				if sp.AddressBlocks == nil {
+					sp.AddressBlocks = base.AddressBlocks
+				}
+				if sp.Description == "" {
+					sp.Description = base.Description
+				}
+				if sp.GroupName == "" {
+					sp.GroupName = base.GroupName
+				}
+				sp.svdProps = sp.svdProps.inherit(base.svdProps)
+			}
+		}
+		props := sp.svdProps.inherit(dev.svdProps)
+		base, _ := parseSVDInt(sp.BaseAddress)
+
+		names, offsets := sp.svdDim.expand(sp.Name)
+		for i, name := range names {
+			p := &Peripheral{Name: name, Group: sp.GroupName, DerivedFrom: sp.DerivedFrom,
+				Description: svdText(sp.Description), Address: base + offsets[i]}
+			if sp.Registers != nil {
+				p.Registers = sp.Registers.registers("", 0, props)
+			}
+			for j := range p.Registers {
+				p.Registers[j].Address = p.Address + p.Registers[j].Offset
+			}
+			for _, ab := range sp.AddressBlocks {
+				off, _ := parseSVDInt(ab.Offset)
+				size, _ := parseSVDInt(ab.Size)
+				if off+size > p.Size {
+					p.Size = off + size
+				}
+			}
+			if p.Size == 0 {
+				for _, r := range p.Registers {
+					if end := r.Offset + uint64(r.Size+7)/8; end > p.Size {
+						p.Size = end
+					}
+				}
+			}
+			d.Peripherals = append(d.Peripherals, p)
+		}
+	}
+	sort.SliceStable(d.Peripherals, func(i, j int) bool {
+		return d.Peripherals[i].Address < d.Peripherals[j].Address
+	})
+
+	return d, nil
+}
+
+// PeripheralAt returns the peripheral containing the specified address,
+// along with the register at that address, if any. When peripherals
+// overlap, such as the modes of a FLEXCOMM, one with a register at the
+// address is preferred.
+func (d *SVDDevice) PeripheralAt(addr uint64) (*Peripheral, *Register, bool) {
+	var found *Peripheral
+	for _, p := range d.Peripherals {
+		if addr < p.Address || addr-p.Address >= p.Size {
+			continue
+		}
+		for i := range p.Registers {
+			r := &p.Registers[i]
+			if addr >= r.Address && addr-r.Address < uint64(r.Size+7)/8 {
+				return p, r, true
+			}
+		}
+		if found == nil {
+			found = p
+		}
+	}
+	return found, nil, found != nil
+}
+
+// PeripheralLocation renders an address as 'PERIPHERAL.REGISTER', or as
+// 'PERIPHERAL+0xoffset' when it isn't a register.
+func (d *SVDDevice) PeripheralLocation(addr uint64) (string, bool) {
+	p, r, ok := d.PeripheralAt(addr)
+	switch {
+	case !ok:
+		return "", false
+	case r != nil && r.Address == addr:
+		return p.Name + "." + r.Name, true
+	case r != nil:
+		return symOffset(p.Name+"."+r.Name, addr-r.Address), true
+	}
+	return symOffset(p.Name, addr-p.Address), true
+}
+
+// PeripheralRefs finds the functions and objects of the image that refer to
+// the device's peripherals. Functions are disassembled to find addresses
+// loaded from literal pools, as used for register addresses on ARM, and
+// initialised objects are scanned for pointers, such as the base address
+// in a driver's config struct. Relocatable objects aren't scanned, since
+// their addresses aren't resolved until link time.
+func (img *Image) PeripheralRefs(d *SVDDevice) []PeripheralRef {
+	if img.File.Type == elf.ET_REL {
+		return nil
+	}
+
+	var refs []PeripheralRef
+	add := func(sym string, kind string, addr uint64, target uint64) {
+		if p, r, ok := d.PeripheralAt(target); ok {
+			ref := PeripheralRef{Symbol: sym, Kind: kind, Address: addr, Target: target,
+				Peripheral: p.Name}
+			if r != nil {
+				ref.Register = r.Name
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	for _, fc := range img.functionCode() {
+		arch := img.Arch(fc.start)
+		sym := fc.sym
+		for _, inst := range fc.insts {
+			if !inst.HasLiteral {
+				continue
+			}
+			target := inst.Literal
+			if (arch == disasm.ArchThumb || arch == disasm.ArchARM) &&
+				strings.HasPrefix(inst.Text, "ldr") {
+				v, e := img.ReadAddr(inst.Literal, 4)
+				if e != nil {
+					continue
+				}
+				target = uint64(img.File.ByteOrder.Uint32(v))
+			}
+			add(sym.Name, "code", inst.Addr, target)
+		}
+	}
+
+	ptr := img.ptrSize()
+	for _, s := range img.Symbols {
+		if elf.ST_TYPE(s.Info) != elf.STT_OBJECT || s.Size < ptr ||
+			s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE ||
+			int(s.Section) >= len(img.File.Sections) {
+			continue
+		}
+		sect := img.File.Sections[s.Section]
+		if sect.Type != elf.SHT_PROGBITS || sect.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		data, e := img.ReadAddr(s.Value, s.Size)
+		if e != nil {
+			continue
+		}
+		for off := uint64(0); off+ptr <= uint64(len(data)); off += ptr {
+			add(s.Name, "data", s.Value+off, readUint(data[off:off+ptr], img.File.ByteOrder))
+		}
+	}
+
+	return refs
+}
+
+// insertSVD populates the 'peripherals' and 'registers' tables from
+// SVDFile, and the 'peripheral_refs' table from the supplied image.
+func insertSVD(img *Image) error {
+	if SVDFile == "" {
+		return nil
+	}
+	d, e := ReadSVD(SVDFile)
+	if e != nil {
+		return e
+	}
+	curDevice = d
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	for _, p := range d.Peripherals {
+		_, e = tx.Exec(`INSERT INTO peripherals VALUES (NULL,?,?,?,?,?,?)`,
+			p.Name, nullable(p.Group), nullable(p.Description), int64(p.Address),
+			int64(p.Size), nullable(p.DerivedFrom))
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+		for _, r := range p.Registers {
+			var reset interface{}
+			if r.HasReset {
+				reset = int64(r.Reset)
+			}
+			_, e = tx.Exec(`INSERT INTO registers VALUES (NULL,?,?,?,?,?,?,?,?)`,
+				p.Name, r.Name, nullable(r.Description), int64(r.Address),
+				int64(r.Offset), r.Size, nullable(r.Access), reset)
+			if e != nil {
+				tx.Rollback()
+				return e
+			}
+		}
+	}
+	if img != nil {
+		for _, r := range img.PeripheralRefs(d) {
+			_, e = tx.Exec(`INSERT INTO peripheral_refs VALUES (NULL,?,?,?,?,?,?)`,
+				r.Symbol, r.Kind, int64(r.Address), int64(r.Target), r.Peripheral,
+				nullable(r.Register))
+			if e != nil {
+				tx.Rollback()
+				return e
+			}
+		}
+	}
+
+	return tx.Commit()
+}
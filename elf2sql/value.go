@@ -0,0 +1,409 @@
+package elf2sql
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Type specifiers accepted by Image.Value, along with their size in bytes.
+// A size of zero indicates a variable-length type.
+var valueTypeSizes = map[string]int{
+	"i8":    1,
+	"u8":    1,
+	"x8":    1,
+	"i16":   2,
+	"u16":   2,
+	"x16":   2,
+	"i32":   4,
+	"u32":   4,
+	"x32":   4,
+	"i64":   8,
+	"u64":   8,
+	"x64":   8,
+	"f32":   4,
+	"f64":   8,
+	"bool":  1,
+	"str":   0,
+	"bytes": 0,
+}
+
+// Value decodes the initial value of the named symbol.
+//
+// The type specifier is one of i8/u8/x8, i16/u16/x16, i32/u32/x32,
+// i64/u64/x64 (signed, unsigned and hex integers), f32, f64, bool, str
+// (NUL-terminated string) or bytes (hex dump). Integer and float types can
+// be followed by '[n]' to decode an array of n elements, or by '[]' to decode
+// as many elements as the symbol's size allows.
+//
+// When the type is empty or 'auto', the symbol's DWARF type information is
+// used to decode the value, including nested structs, unions and arrays.
+func (img *Image) Value(name string, typ string) (string, error) {
+	typ = strings.TrimSpace(strings.ToLower(typ))
+	if typ == "" || typ == "auto" {
+		if img.HasDWARF() {
+			return img.dwarfValue(name)
+		}
+		typ = "bytes"
+	}
+
+	data, e := img.ReadSymbol(name)
+	if e != nil {
+		return "", e
+	}
+
+	// Parse any array suffix
+	count := 1
+	array := false
+	if i := strings.Index(typ, "["); i >= 0 {
+		if !strings.HasSuffix(typ, "]") {
+			return "", fmt.Errorf("invalid type: %s", typ)
+		}
+		n := typ[i+1 : len(typ)-1]
+		typ = typ[:i]
+		array = true
+		count = -1
+		if n != "" {
+			count, e = strconv.Atoi(n)
+			if e != nil || count < 0 {
+				return "", fmt.Errorf("invalid array length: %s", n)
+			}
+		}
+	}
+
+	size, ok := valueTypeSizes[typ]
+	if !ok {
+		return "", fmt.Errorf("unknown type: %s", typ)
+	}
+
+	switch typ {
+	case "str":
+		return strconv.Quote(cString(data)), nil
+	case "bytes":
+		return hexBytes(data), nil
+	}
+
+	if count < 0 {
+		count = len(data) / size
+	}
+	if count*size > len(data) {
+		return "", fmt.Errorf("'%s' is %d bytes, %d required", name, len(data), count*size)
+	}
+
+	order := img.File.ByteOrder
+	vals := make([]string, count)
+	for i := range vals {
+		vals[i] = formatScalar(typ, data[i*size:(i+1)*size], order)
+	}
+	if !array {
+		return vals[0], nil
+	}
+
+	return "{" + strings.Join(vals, ", ") + "}", nil
+}
+
+// formatScalar renders a single fixed-size value described by a type
+// specifier from valueTypeSizes.
+func formatScalar(typ string, b []byte, order binary.ByteOrder) string {
+	u := readUint(b, order)
+
+	switch typ {
+	case "i8":
+		return strconv.FormatInt(int64(int8(u)), 10)
+	case "i16":
+		return strconv.FormatInt(int64(int16(u)), 10)
+	case "i32":
+		return strconv.FormatInt(int64(int32(u)), 10)
+	case "i64":
+		return strconv.FormatInt(int64(u), 10)
+	case "x8", "x16", "x32", "x64":
+		return fmt.Sprintf("0x%0*X", len(b)*2, u)
+	case "f32":
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(u))), 'g', -1, 32)
+	case "f64":
+		return strconv.FormatFloat(math.Float64frombits(u), 'g', -1, 64)
+	case "bool":
+		return strconv.FormatBool(u != 0)
+	default:
+		return strconv.FormatUint(u, 10)
+	}
+}
+
+// readUint reads an unsigned integer of 1 to 8 bytes in the supplied byte order.
+func readUint(b []byte, order binary.ByteOrder) uint64 {
+	var u uint64
+	for i := range b {
+		if order == binary.LittleEndian {
+			u |= uint64(b[i]) << (8 * i)
+		} else {
+			u = u<<8 | uint64(b[i])
+		}
+	}
+	return u
+}
+
+// signExtend converts the lowest 'bits' bits of u into a signed value.
+func signExtend(u uint64, bits int) int64 {
+	if bits <= 0 || bits >= 64 {
+		return int64(u)
+	}
+	shift := 64 - bits
+	return int64(u<<shift) >> shift
+}
+
+// cString returns the contents of b up to the first NUL byte.
+func cString(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// hexBytes renders b as a space-separated hex dump.
+func hexBytes(b []byte) string {
+	s := make([]string, len(b))
+	for i := range b {
+		s[i] = fmt.Sprintf("%02X", b[i])
+	}
+	return strings.Join(s, " ")
+}
+
+// dwarfVariable returns the DWARF type of the named global or static
+// variable.
+func (img *Image) dwarfVariable(name string) (dwarf.Type, error) {
+	off, ok := img.dwarfVariables()[name]
+	if !ok {
+		return nil, fmt.Errorf("no debug information for '%s'", name)
+	}
+
+	return img.dwarf.Type(off)
+}
+
+// dwarfVariables returns the offset of the DWARF type of every global and
+// static variable, indexed by name.
+func (img *Image) dwarfVariables() map[string]dwarf.Offset {
+	if img.variables == nil && img.dwarf != nil {
+		img.variables = make(map[string]dwarf.Offset)
+		r := img.dwarf.Reader()
+		for {
+			ent, e := r.Next()
+			if e != nil || ent == nil {
+				break
+			}
+			if ent.Tag != dwarf.TagVariable {
+				continue
+			}
+			vname, _ := ent.Val(dwarf.AttrName).(string)
+			toff, ok := ent.Val(dwarf.AttrType).(dwarf.Offset)
+			if vname == "" || !ok {
+				continue
+			}
+			if _, exists := img.variables[vname]; !exists {
+				img.variables[vname] = toff
+			}
+		}
+	}
+
+	return img.variables
+}
+
+// dwarfValue decodes the named symbol using its DWARF type description,
+// falling back to a hex dump for symbols without debug information.
+func (img *Image) dwarfValue(name string) (string, error) {
+	data, e := img.ReadSymbol(name)
+	if e != nil {
+		return "", e
+	}
+
+	t, e := img.dwarfVariable(name)
+	if e != nil {
+		return hexBytes(data), nil
+	}
+
+	return img.formatDWARF(t, data), nil
+}
+
+// formatDWARF renders data as an instance of the supplied DWARF type, using
+// C initialiser syntax for aggregate types.
+func (img *Image) formatDWARF(t dwarf.Type, data []byte) string {
+	order := img.File.ByteOrder
+	size := t.Size()
+	if size > int64(len(data)) {
+		return "<truncated>"
+	}
+
+	switch tt := t.(type) {
+	case *dwarf.TypedefType:
+		return img.formatDWARF(tt.Type, data)
+	case *dwarf.QualType:
+		return img.formatDWARF(tt.Type, data)
+	case *dwarf.BoolType:
+		return strconv.FormatBool(readUint(data[:size], order) != 0)
+	case *dwarf.CharType:
+		return strconv.FormatInt(signExtend(readUint(data[:size], order), int(size*8)), 10)
+	case *dwarf.IntType:
+		return strconv.FormatInt(signExtend(readUint(data[:size], order), int(size*8)), 10)
+	case *dwarf.UcharType:
+		return strconv.FormatUint(readUint(data[:size], order), 10)
+	case *dwarf.UintType:
+		return strconv.FormatUint(readUint(data[:size], order), 10)
+	case *dwarf.FloatType:
+		if size == 4 {
+			return formatScalar("f32", data[:size], order)
+		}
+		return formatScalar("f64", data[:size], order)
+	case *dwarf.EnumType:
+		v := signExtend(readUint(data[:size], order), int(size*8))
+		for _, ev := range tt.Val {
+			if ev.Val == v {
+				return ev.Name
+			}
+		}
+		return strconv.FormatInt(v, 10)
+	case *dwarf.PtrType:
+		addr := readUint(data[:size], order)
+		if addr == 0 {
+			return "NULL"
+		}
+		if tt.Type != nil && isCharType(tt.Type) {
+			if str, ok := img.readString(addr); ok {
+				return fmt.Sprintf("0x%X %s", addr, strconv.Quote(str))
+			}
+		}
+		if sym, off, ok := img.SymbolAt(addr); ok && off == 0 {
+			return fmt.Sprintf("0x%X <%s>", addr, sym.Name)
+		}
+		return fmt.Sprintf("0x%X", addr)
+	case *dwarf.ArrayType:
+		return img.formatDWARFArray(tt, data)
+	case *dwarf.StructType:
+		return img.formatDWARFStruct(tt, data)
+	default:
+		// Incomplete and void types have no size
+		if size < 0 || size > int64(len(data)) {
+			return hexBytes(data)
+		}
+		return hexBytes(data[:size])
+	}
+}
+
+// formatDWARFArray renders an array, showing character arrays as strings.
+func (img *Image) formatDWARFArray(t *dwarf.ArrayType, data []byte) string {
+	esize := t.Type.Size()
+	if esize <= 0 {
+		return "{}"
+	}
+
+	count := t.Count
+	if count < 0 || count*esize > int64(len(data)) {
+		count = int64(len(data)) / esize
+	}
+
+	if isCharType(t.Type) {
+		return strconv.Quote(cString(data[:count]))
+	}
+
+	vals := make([]string, count)
+	for i := range vals {
+		vals[i] = img.formatDWARF(t.Type, data[int64(i)*esize:])
+	}
+	return "{" + strings.Join(vals, ", ") + "}"
+}
+
+// formatDWARFStruct renders a struct or union using designated initialisers.
+func (img *Image) formatDWARFStruct(t *dwarf.StructType, data []byte) string {
+	order := img.File.ByteOrder
+	fields := make([]string, 0, len(t.Field))
+
+	for _, f := range t.Field {
+		var val string
+		if f.BitSize > 0 {
+			val = formatBitfield(f, data, order)
+		} else if f.ByteOffset < int64(len(data)) {
+			val = img.formatDWARF(f.Type, data[f.ByteOffset:])
+		} else {
+			break
+		}
+
+		if f.Name == "" {
+			fields = append(fields, val)
+		} else {
+			fields = append(fields, "."+f.Name+" = "+val)
+		}
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// formatBitfield extracts and renders a bitfield struct member from the
+// data of the struct containing it.
+func formatBitfield(f *dwarf.StructField, data []byte, order binary.ByteOrder) string {
+	var start, unit, shift int64
+	if f.ByteSize > 0 && f.DataBitOffset == 0 {
+		// DWARF 2/3: offset of the field's MSB within the storage unit
+		start = f.ByteOffset
+		unit = f.ByteSize
+		shift = unit*8 - f.BitOffset - f.BitSize
+	} else {
+		// DWARF 4+: bit offset from the start of the struct
+		start = f.DataBitOffset / 8
+		unit = (f.DataBitOffset%8 + f.BitSize + 7) / 8
+		shift = f.DataBitOffset % 8
+		if order == binary.BigEndian {
+			shift = unit*8 - shift - f.BitSize
+		}
+	}
+	if unit > 8 || shift < 0 || start+unit > int64(len(data)) {
+		return "<truncated>"
+	}
+
+	v := readUint(data[start:start+unit], order) >> uint(shift) & (1<<uint(f.BitSize) - 1)
+	switch under(f.Type).(type) {
+	case *dwarf.IntType, *dwarf.CharType:
+		return strconv.FormatInt(signExtend(v, int(f.BitSize)), 10)
+	case *dwarf.BoolType:
+		return strconv.FormatBool(v != 0)
+	}
+
+	return strconv.FormatUint(v, 10)
+}
+
+// under strips typedefs and qualifiers from t.
+func under(t dwarf.Type) dwarf.Type {
+	for {
+		switch tt := t.(type) {
+		case *dwarf.TypedefType:
+			t = tt.Type
+		case *dwarf.QualType:
+			t = tt.Type
+		default:
+			return t
+		}
+	}
+}
+
+// isCharType indicates if t is a single-byte character type.
+func isCharType(t dwarf.Type) bool {
+	switch under(t).(type) {
+	case *dwarf.CharType, *dwarf.UcharType:
+		return true
+	}
+	return false
+}
+
+// readString reads a NUL-terminated string from the initial memory image.
+func (img *Image) readString(addr uint64) (string, bool) {
+	sec := img.SectionAt(addr)
+	if sec == nil || sec.Type == elf.SHT_NOBITS {
+		return "", false
+	}
+	b, e := img.ReadAddr(addr, sec.Addr+sec.Size-addr)
+	if e != nil {
+		return "", false
+	}
+	return cString(b), true
+}
@@ -0,0 +1,109 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/elf"
+	"sort"
+)
+
+// Mismatch is a range of load addresses where a programmed image doesn't
+// match the ELF file. Symbols lists the code and data symbols in the range,
+// found from the section's run-time (VMA) addresses.
+type Mismatch struct {
+	Address  uint64
+	Size     uint64
+	Kind     string // 'differs', or 'missing' when the image has no data
+	Section  string
+	Symbols  []string
+	Expected []byte
+	Actual   []byte // Empty for missing data
+}
+
+// byteAt returns the byte at the specified address, if the image has one.
+func (m *MemImage) byteAt(addr uint64) (byte, bool) {
+	i := sort.Search(len(m.Blocks), func(i int) bool { return m.Blocks[i].End() > addr })
+	if i == len(m.Blocks) || addr < m.Blocks[i].Address {
+		return 0, false
+	}
+	return m.Blocks[i].Data[addr-m.Blocks[i].Address], true
+}
+
+// symbolsIn returns the code and data symbols overlapping a range of
+// run-time addresses, in address order.
+func (img *Image) symbolsIn(start, end uint64) []string {
+	type symAddr struct {
+		name string
+		addr uint64
+	}
+	var found []symAddr
+	for _, s := range img.Symbols {
+		typ := elf.ST_TYPE(s.Info)
+		if typ != elf.STT_FUNC && typ != elf.STT_OBJECT || s.Size == 0 ||
+			s.Section == elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE {
+			continue
+		}
+		addr := img.SymbolAddr(s)
+		if addr < end && addr+s.Size > start {
+			found = append(found, symAddr{s.Name, addr})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].addr < found[j].addr })
+
+	var names []string
+	for _, f := range found {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// Verify compares the loadable contents of the image (as given by
+// MemImage) with a programmed image, such as a HEX file or a flash
+// readback, returning each range of bytes that differs or is missing. Data
+// in the programmed image outside the ELF file's contents is ignored.
+func (img *Image) Verify(expected *MemImage, actual *MemImage) []Mismatch {
+	var mismatches []Mismatch
+	for _, b := range expected.Blocks {
+		var cur *Mismatch
+		for i, want := range b.Data {
+			addr := b.Address + uint64(i)
+			got, ok := actual.byteAt(addr)
+			kind := "missing"
+			if ok {
+				if got == want {
+					cur = nil
+					continue
+				}
+				kind = "differs"
+			}
+
+			if cur == nil || cur.Kind != kind {
+				mismatches = append(mismatches, Mismatch{Address: addr, Kind: kind,
+					Section: b.Section})
+				cur = &mismatches[len(mismatches)-1]
+			}
+			cur.Size++
+			cur.Expected = append(cur.Expected, want)
+			if ok {
+				cur.Actual = append(cur.Actual, got)
+			}
+		}
+	}
+
+	// Find the symbols at the run-time address of each range
+	sections := make(map[string]*elf.Section)
+	for _, s := range img.File.Sections {
+		sections[s.Name] = s
+	}
+	lmas := make(map[string]uint64)
+	for _, b := range expected.Blocks {
+		lmas[b.Section] = b.Address
+	}
+	for i := range mismatches {
+		m := &mismatches[i]
+		if s, ok := sections[m.Section]; ok {
+			vma := s.Addr + m.Address - lmas[m.Section]
+			m.Symbols = img.symbolsIn(vma, vma+m.Size)
+		}
+	}
+
+	return mismatches
+}
@@ -0,0 +1,717 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Zephyr enables the Zephyr RTOS analyser, which decodes the kernel's
+// iterable sections into the 'zephyr_*' tables. The tables are empty if not
+// set, or if the file isn't a Zephyr image.
+var Zephyr bool
+
+const createZephyrDeviceTable string = `CREATE TABLE zephyr_devices (
+	ID      integer primary key autoincrement,
+	Name    text,
+	Symbol  text,
+	Address integer,
+	Level   text,
+	Init    text,
+	Config  text,
+	API     text,
+	Data    text
+	)`
+
+const createZephyrInitTable string = `CREATE TABLE zephyr_init (
+	ID       integer primary key autoincrement,
+	Level    text,
+	Sequence integer,
+	Priority integer,
+	Kind     text,
+	Function text,
+	Device   text,
+	Symbol   text,
+	Address  integer
+	)`
+
+const createZephyrStackTable string = `CREATE TABLE zephyr_stacks (
+	ID        integer primary key autoincrement,
+	Name      text,
+	Address   integer,
+	Size      integer,
+	Count     integer,
+	StackSize integer,
+	Section   text,
+	Thread    text
+	)`
+
+const createZephyrObjectTable string = `CREATE TABLE zephyr_objects (
+	ID      integer primary key autoincrement,
+	Name    text,
+	Type    text,
+	Address integer,
+	Size    integer,
+	Count   integer,
+	Section text
+	)`
+
+const createZephyrShellTable string = `CREATE TABLE zephyr_shell (
+	ID      integer primary key autoincrement,
+	Command text,
+	Help    text,
+	Handler text,
+	Dynamic integer,
+	Address integer
+	)`
+
+const createZephyrLogTable string = `CREATE TABLE zephyr_log (
+	ID      integer primary key autoincrement,
+	Name    text,
+	Level   text,
+	Symbol  text,
+	Address integer
+	)`
+
+// zephyrInitLevels lists the init levels in the order they run at boot
+var zephyrInitLevels = []string{"EARLY", "PRE_KERNEL_1", "PRE_KERNEL_2",
+	"POST_KERNEL", "APPLICATION", "SMP"}
+
+// zephyrLogLevels maps compile-time log levels to their names
+var zephyrLogLevels = []string{"none", "err", "wrn", "inf", "dbg"}
+
+// zephyrObjectTypes are the structs of the kernel objects listed in the
+// 'zephyr_objects' table
+var zephyrObjectTypes = map[string]bool{
+	"k_condvar": true, "k_event": true, "k_fifo": true, "k_futex": true,
+	"k_heap": true, "k_lifo": true, "k_mbox": true, "k_mem_slab": true,
+	"k_msgq": true, "k_mutex": true, "k_pipe": true, "k_poll_signal": true,
+	"k_queue": true, "k_sem": true, "k_stack": true, "k_thread": true,
+	"k_timer": true, "k_work_q": true,
+}
+
+// ZephyrInit is an entry in the kernel's init sequence, registered with
+// SYS_INIT or by a device definition. Entries run in Sequence order, which
+// within a level is the order of their priorities.
+type ZephyrInit struct {
+	Level    string
+	Sequence int
+	Kind     string // 'device' or 'SYS_INIT'
+	Function string
+	Device   string // Name of the device, for device entries
+	Symbol   string
+	Address  uint64
+}
+
+// ZephyrDevice is a device instance from the devices section. Config, API
+// and Data are the symbols the device points to.
+type ZephyrDevice struct {
+	Name    string
+	Symbol  string
+	Address uint64
+	Level   string
+	Init    string
+	Config  string
+	API     string
+	Data    string
+}
+
+// ZephyrStack is a thread stack, or an array of Count stacks of StackSize
+// bytes each. Thread is the static thread using the stack, if known.
+type ZephyrStack struct {
+	Name      string
+	Address   uint64
+	Size      uint64
+	Count     int
+	StackSize uint64
+	Section   string
+	Thread    string
+}
+
+// ZephyrObject is a statically allocated kernel object, or array of Count
+// objects, such as a semaphore or thread.
+type ZephyrObject struct {
+	Name    string
+	Type    string
+	Address uint64
+	Size    uint64
+	Count   int
+	Section string
+}
+
+// ZephyrShellCommand is a shell command or subcommand. Command is the full
+// command line, as in 'kernel threads'. Dynamic commands have their
+// subcommands created at run time.
+type ZephyrShellCommand struct {
+	Command string
+	Help    string
+	Handler string
+	Dynamic bool
+	Address uint64
+}
+
+// ZephyrLogSource is a module registered with LOG_MODULE_REGISTER, along
+// with its compile-time log level.
+type ZephyrLogSource struct {
+	Name    string
+	Level   string
+	Symbol  string
+	Address uint64
+}
+
+// IsZephyr indicates if the image is a linked Zephyr kernel.
+func (img *Image) IsZephyr() bool {
+	if img.File.Type == elf.ET_REL {
+		return false
+	}
+	_, ok := img.Lookup("z_sys_init_run_level")
+	return ok
+}
+
+// ptrSize returns the size of a pointer in bytes.
+func (img *Image) ptrSize() uint64 {
+	if img.File.Class == elf.ELFCLASS64 {
+		return 8
+	}
+	return 4
+}
+
+// readPtr reads a pointer from the initial memory image.
+func (img *Image) readPtr(addr uint64) (uint64, bool) {
+	b, e := img.ReadAddr(addr, img.ptrSize())
+	if e != nil {
+		return 0, false
+	}
+	return readUint(b, img.File.ByteOrder), true
+}
+
+// ptrName describes a pointer as the symbol it points to, or as a hex
+// address when there is no symbol. Null pointers are empty.
+func (img *Image) ptrName(p uint64) string {
+	if p == 0 {
+		return ""
+	}
+	if sym, off, ok := img.SymbolAt(img.CodeAddr(p)); ok {
+		return symOffset(sym.Name, off)
+	}
+	return fmt.Sprintf("0x%X", p)
+}
+
+// zephyrSection returns the address range of an iterable section, using
+// either the '__name_start' or '_name_list_start' boundary symbols.
+func (img *Image) zephyrSection(name string) (uint64, uint64, bool) {
+	for _, f := range []string{"__%s_%s", "_%s_list_%s"} {
+		start, ok1 := img.Lookup(fmt.Sprintf(f, name, "start"))
+		end, ok2 := img.Lookup(fmt.Sprintf(f, name, "end"))
+		if ok1 && ok2 && end.Value >= start.Value {
+			return start.Value, end.Value, true
+		}
+	}
+	return 0, 0, false
+}
+
+// dataSymbols returns the sized data symbols between start and end, in
+// address order, with one symbol per address.
+func (img *Image) dataSymbols(start, end uint64) []elf.Symbol {
+	var syms []elf.Symbol
+	for _, s := range img.Symbols {
+		if s.Size > 0 && s.Value >= start && s.Value < end &&
+			elf.ST_TYPE(s.Info) == elf.STT_OBJECT {
+			syms = append(syms, s)
+		}
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].Value < syms[j].Value })
+
+	var unique []elf.Symbol
+	for i, s := range syms {
+		if i == 0 || s.Value != syms[i-1].Value {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// entries returns the address and symbol name of each entry of an iterable
+// section. Entries are found from their symbols, or are assumed to be
+// 'size' bytes apart when the symbols have been stripped.
+func (img *Image) entries(start, end uint64, size uint64) ([]uint64, []string) {
+	var addrs []uint64
+	var names []string
+	if syms := img.dataSymbols(start, end); len(syms) > 0 {
+		for _, s := range syms {
+			addrs = append(addrs, s.Value)
+			names = append(names, s.Name)
+		}
+		return addrs, names
+	}
+	for a := start; a+size <= end; a += size {
+		addrs = append(addrs, a)
+		names = append(names, "")
+	}
+	return addrs, names
+}
+
+// fieldOffset returns the offset of the named member of a struct type,
+// falling back to def when there is no debug information.
+func fieldOffset(t dwarf.Type, name string, def int64) int64 {
+	if st, ok := under(t).(*dwarf.StructType); ok {
+		for _, f := range st.Field {
+			if f.Name == name {
+				return f.ByteOffset
+			}
+		}
+	}
+	return def
+}
+
+// deviceName returns the name of the device at addr, read from the
+// device's 'name' member.
+func (img *Image) deviceName(addr uint64) string {
+	p, ok := img.readPtr(addr)
+	if !ok || p == 0 {
+		return ""
+	}
+	s, _ := img.readString(p)
+	return s
+}
+
+// ZephyrInits returns the entries of the init sequence in boot order. The
+// linker sorts each level by priority, but the priorities themselves aren't
+// stored in the image, so the 'zephyr_init' table only has them when a
+// linker map is loaded (see insertZephyr).
+func (img *Image) ZephyrInits() []ZephyrInit {
+	type level struct {
+		name  string
+		start uint64
+	}
+	var levels []level
+	for _, l := range zephyrInitLevels {
+		if s, ok := img.Lookup("__init_" + l + "_start"); ok {
+			levels = append(levels, level{l, s.Value})
+		}
+	}
+	start, end, ok := img.zephyrSection("init")
+	if !ok || len(levels) == 0 {
+		return nil
+	}
+
+	var inits []ZephyrInit
+	ptr := img.ptrSize()
+	addrs, names := img.entries(start, end, 2*ptr)
+	for n, addr := range addrs {
+		// Levels are in boot order, so the entry belongs to the last level
+		// starting at or before it
+		init := ZephyrInit{Sequence: n + 1, Kind: "SYS_INIT", Symbol: names[n], Address: addr}
+		for _, l := range levels {
+			if l.start <= addr {
+				init.Level = l.name
+			}
+		}
+
+		fn, _ := img.readPtr(addr)
+		init.Function = img.ptrName(fn)
+		if dev, _ := img.readPtr(addr + ptr); dev != 0 {
+			init.Device = img.deviceName(dev)
+			sym, _, ok := img.SymbolAt(dev)
+			if !ok || !strings.HasPrefix(sym.Name, "__device_sys_init_") {
+				init.Kind = "device"
+			}
+		}
+		inits = append(inits, init)
+	}
+
+	return inits
+}
+
+// ZephyrDevices returns the device instances in the order they're
+// initialised.
+func (img *Image) ZephyrDevices() []ZephyrDevice {
+	start, end, ok := img.zephyrSection("device")
+	if !ok {
+		return nil
+	}
+	syms := img.dataSymbols(start, end)
+	if len(syms) == 0 {
+		return nil
+	}
+
+	// The layout of struct device varies between Zephyr versions, so the
+	// debug information is used when available
+	ptr := int64(img.ptrSize())
+	t, _ := img.dwarfVariable(syms[0].Name)
+	nameOff := fieldOffset(t, "name", 0)
+	configOff := fieldOffset(t, "config", ptr)
+	apiOff := fieldOffset(t, "api", 2*ptr)
+	dataOff := fieldOffset(t, "data", -1)
+
+	// Old kernels sort devices by level, with boundary symbols for each
+	levels := make(map[uint64]string)
+	for _, l := range zephyrInitLevels {
+		if s, ok := img.Lookup("__device_" + l + "_start"); ok {
+			levels[s.Value] = l
+		}
+	}
+	inits := make(map[uint64]ZephyrInit)
+	for _, i := range img.ZephyrInits() {
+		dev, _ := img.readPtr(i.Address + uint64(ptr))
+		inits[dev] = i
+	}
+
+	var devs []ZephyrDevice
+	level := ""
+	for _, s := range syms {
+		if l, ok := levels[s.Value]; ok {
+			level = l
+		}
+		d := ZephyrDevice{Name: img.deviceName(s.Value + uint64(nameOff)),
+			Symbol: s.Name, Address: s.Value, Level: level}
+		if i, ok := inits[s.Value]; ok {
+			d.Init = i.Function
+			if d.Level == "" {
+				d.Level = i.Level
+			}
+		}
+		for _, f := range []struct {
+			off  int64
+			dest *string
+		}{{configOff, &d.Config}, {apiOff, &d.API}, {dataOff, &d.Data}} {
+			if f.off < 0 {
+				continue
+			}
+			p, _ := img.readPtr(s.Value + uint64(f.off))
+			*f.dest = img.ptrName(p)
+		}
+		devs = append(devs, d)
+	}
+
+	return devs
+}
+
+// dwarfObjects calls fn for each global or static variable with debug
+// information, passing the struct name at the core of its type (after
+// removing typedefs and arrays) and the dimensions of any arrays.
+func (img *Image) dwarfObjects(fn func(sym elf.Symbol, structName string, dims []int64)) {
+	names := make([]string, 0, len(img.dwarfVariables()))
+	for name := range img.dwarfVariables() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sym, ok := img.Lookup(name)
+		if !ok || sym.Size == 0 || elf.ST_TYPE(sym.Info) != elf.STT_OBJECT {
+			continue
+		}
+		t, e := img.dwarfVariable(name)
+		if e != nil {
+			continue
+		}
+		var dims []int64
+		t = under(t)
+		for {
+			at, ok := t.(*dwarf.ArrayType)
+			if !ok {
+				break
+			}
+			dims = append(dims, at.Count)
+			t = under(at.Type)
+		}
+		if st, ok := t.(*dwarf.StructType); ok {
+			fn(sym, st.StructName, dims)
+		}
+	}
+}
+
+// ZephyrStacks returns the thread stacks, which are arrays of
+// 'z_thread_stack_element' in the debug information. Without debug
+// information, data in NOBITS sections with 'stack' in its name is used.
+func (img *Image) ZephyrStacks() []ZephyrStack {
+	var stacks []ZephyrStack
+	add := func(sym elf.Symbol, count int64) {
+		if count < 1 {
+			count = 1
+		}
+		sec := img.SectionAt(sym.Value)
+		if sec == nil {
+			return
+		}
+		stacks = append(stacks, ZephyrStack{Name: sym.Name, Address: sym.Value,
+			Size: sym.Size, Count: int(count), StackSize: sym.Size / uint64(count),
+			Section: sec.Name})
+	}
+
+	if img.HasDWARF() {
+		img.dwarfObjects(func(sym elf.Symbol, name string, dims []int64) {
+			if name != "z_thread_stack_element" || len(dims) == 0 {
+				return
+			}
+			// The last dimension is the stack itself
+			count := int64(1)
+			for _, d := range dims[:len(dims)-1] {
+				count *= d
+			}
+			add(sym, count)
+		})
+	} else {
+		for _, s := range img.Symbols {
+			if elf.ST_TYPE(s.Info) != elf.STT_OBJECT || s.Size == 0 ||
+				!strings.Contains(strings.ToLower(s.Name), "stack") {
+				continue
+			}
+			if sec := img.SectionAt(s.Value); sec != nil && sec.Type == elf.SHT_NOBITS {
+				add(s, 1)
+			}
+		}
+	}
+
+	// Static threads (K_THREAD_DEFINE) name their thread and stack
+	threads := make(map[uint64]string)
+	if start, end, ok := img.zephyrSection("static_thread_data"); ok {
+		ptr := img.ptrSize()
+		syms := img.dataSymbols(start, end)
+		var t dwarf.Type
+		if len(syms) > 0 {
+			t, _ = img.dwarfVariable(syms[0].Name)
+		}
+		threadOff := uint64(fieldOffset(t, "init_thread", 0))
+		stackOff := uint64(fieldOffset(t, "init_stack", int64(ptr)))
+		for _, s := range syms {
+			thread, _ := img.readPtr(s.Value + threadOff)
+			stack, _ := img.readPtr(s.Value + stackOff)
+			threads[stack] = img.ptrName(thread)
+		}
+	}
+
+	sort.SliceStable(stacks, func(i, j int) bool { return stacks[i].Address < stacks[j].Address })
+	for i := range stacks {
+		stacks[i].Thread = threads[stacks[i].Address]
+	}
+	return stacks
+}
+
+// ZephyrObjects returns the statically allocated kernel objects. Objects
+// are found by type in the debug information, and in the iterable sections
+// used by K_SEM_DEFINE and similar macros.
+func (img *Image) ZephyrObjects() []ZephyrObject {
+	var objs []ZephyrObject
+	seen := make(map[uint64]bool)
+	add := func(sym elf.Symbol, typ string, count int64) {
+		sec := img.SectionAt(sym.Value)
+		if seen[sym.Value] || sec == nil {
+			return
+		}
+		seen[sym.Value] = true
+		objs = append(objs, ZephyrObject{Name: sym.Name, Type: typ, Address: sym.Value,
+			Size: sym.Size, Count: int(count), Section: sec.Name})
+	}
+
+	if img.HasDWARF() {
+		img.dwarfObjects(func(sym elf.Symbol, name string, dims []int64) {
+			if !zephyrObjectTypes[name] {
+				return
+			}
+			count := int64(1)
+			for _, d := range dims {
+				count *= d
+			}
+			add(sym, name, count)
+		})
+	}
+	for typ := range zephyrObjectTypes {
+		if start, end, ok := img.zephyrSection(typ); ok {
+			for _, s := range img.dataSymbols(start, end) {
+				add(s, typ, 1)
+			}
+		}
+	}
+
+	sort.SliceStable(objs, func(i, j int) bool { return objs[i].Address < objs[j].Address })
+	return objs
+}
+
+// ZephyrShellCommands returns the shell commands registered with
+// SHELL_CMD_REGISTER, followed by their static subcommands.
+func (img *Image) ZephyrShellCommands() []ZephyrShellCommand {
+	start, end, ok := img.zephyrSection("shell_root_cmds")
+	if !ok {
+		return nil
+	}
+	ptr := img.ptrSize()
+	dynStart, dynEnd, _ := img.zephyrSection("shell_dynamic_subcmds")
+
+	// Older kernels store each root command as a struct with an
+	// 'is_dynamic' flag, and newer ones as a pointer to the command
+	entrySize := 2 * ptr
+	if syms := img.dataSymbols(start, end); len(syms) > 0 && syms[0].Size == ptr {
+		entrySize = ptr
+	}
+	// cmdEntry returns the static entries of a 'shell_cmd_entry', or false
+	// if it's dynamic
+	cmdEntry := func(addr uint64) (uint64, bool) {
+		if entrySize == ptr {
+			p, _ := img.readPtr(addr)
+			return p, addr < dynStart || addr >= dynEnd
+		}
+		b, e := img.ReadAddr(addr, 1)
+		p, _ := img.readPtr(addr + ptr)
+		return p, e == nil && b[0] == 0
+	}
+
+	var cmds []ZephyrShellCommand
+	var walk func(entry uint64, prefix string, depth int)
+	walk = func(entry uint64, prefix string, depth int) {
+		// Static entries hold the syntax, help, subcommands and handler,
+		// followed by the argument counts
+		syntaxPtr, _ := img.readPtr(entry)
+		syntax, ok := img.readString(syntaxPtr)
+		if syntaxPtr == 0 || !ok {
+			return
+		}
+		cmd := ZephyrShellCommand{Command: strings.TrimSpace(prefix + " " + syntax), Address: entry}
+		if help, _ := img.readPtr(entry + ptr); help != 0 {
+			cmd.Help, _ = img.readString(help)
+		}
+		handler, _ := img.readPtr(entry + 3*ptr)
+		cmd.Handler = img.ptrName(handler)
+
+		sub, _ := img.readPtr(entry + 2*ptr)
+		var subs uint64
+		if sub != 0 {
+			subs, ok = cmdEntry(sub)
+			cmd.Dynamic = !ok
+		}
+		cmds = append(cmds, cmd)
+
+		if sub == 0 || cmd.Dynamic || depth > 8 {
+			return
+		}
+		for e := subs; ; e += 5 * ptr {
+			if p, ok := img.readPtr(e); !ok || p == 0 {
+				break
+			}
+			walk(e, cmd.Command, depth+1)
+		}
+	}
+
+	addrs, _ := img.entries(start, end, entrySize)
+	for _, a := range addrs {
+		if entry, static := cmdEntry(a); static && entry != 0 {
+			walk(entry, "", 0)
+		}
+	}
+
+	return cmds
+}
+
+// ZephyrLogSources returns the log modules and their compile-time levels.
+func (img *Image) ZephyrLogSources() []ZephyrLogSource {
+	start, end, ok := img.zephyrSection("log_const")
+	if !ok {
+		return nil
+	}
+	ptr := img.ptrSize()
+
+	var srcs []ZephyrLogSource
+	addrs, names := img.entries(start, end, 2*ptr)
+	for n, addr := range addrs {
+		p, _ := img.readPtr(addr)
+		name, ok := img.readString(p)
+		b, e := img.ReadAddr(addr+ptr, 1)
+		if !ok || e != nil {
+			continue
+		}
+		level := fmt.Sprintf("%d", b[0])
+		if int(b[0]) < len(zephyrLogLevels) {
+			level = zephyrLogLevels[b[0]]
+		}
+		srcs = append(srcs, ZephyrLogSource{name, level, names[n], addr})
+	}
+
+	return srcs
+}
+
+// zephyrInitPriority reads the priority of an init entry from the name of
+// its input section, which is '.z_init_PRE_KERNEL_130_' for priority 30 in
+// older kernels and '.z_init_PRE_KERNEL_1_P_30_SUB_0_' in newer ones.
+func zephyrInitPriority(section string, level string) (int, bool) {
+	s := strings.TrimPrefix(section, ".z_init_"+level)
+	if s == section {
+		return 0, false
+	}
+	s = strings.TrimPrefix(s, "_P_")
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(s)
+	}
+	p, e := strconv.Atoi(s[:end])
+	return p, e == nil
+}
+
+// nullable returns nil for empty strings, so they're stored as NULL.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// insertZephyr populates the 'zephyr_*' tables from the supplied image. The
+// priorities of init entries are found from the linker map, if available.
+func insertZephyr(img *Image, lmap *LinkerMap) error {
+	if !Zephyr || img == nil || !img.IsZephyr() {
+		return nil
+	}
+
+	tx, e := DBCon.Begin()
+	if e != nil {
+		return e
+	}
+	var rows [][]interface{}
+	for _, d := range img.ZephyrDevices() {
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_devices VALUES (NULL,?,?,?,?,?,?,?,?)`,
+			nullable(d.Name), d.Symbol, d.Address, nullable(d.Level), nullable(d.Init),
+			nullable(d.Config), nullable(d.API), nullable(d.Data)})
+	}
+	for _, i := range img.ZephyrInits() {
+		var prio interface{}
+		if lmap != nil {
+			if in, ok := lmap.InputAt(i.Address); ok {
+				if p, ok := zephyrInitPriority(in.Section, i.Level); ok {
+					prio = p
+				}
+			}
+		}
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_init VALUES (NULL,?,?,?,?,?,?,?,?)`,
+			i.Level, i.Sequence, prio, i.Kind, nullable(i.Function), nullable(i.Device),
+			nullable(i.Symbol), i.Address})
+	}
+	for _, s := range img.ZephyrStacks() {
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_stacks VALUES (NULL,?,?,?,?,?,?,?)`,
+			s.Name, s.Address, s.Size, s.Count, s.StackSize, s.Section, nullable(s.Thread)})
+	}
+	for _, o := range img.ZephyrObjects() {
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_objects VALUES (NULL,?,?,?,?,?,?)`,
+			o.Name, o.Type, o.Address, o.Size, o.Count, o.Section})
+	}
+	for _, c := range img.ZephyrShellCommands() {
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_shell VALUES (NULL,?,?,?,?,?)`,
+			c.Command, nullable(c.Help), nullable(c.Handler), c.Dynamic, c.Address})
+	}
+	for _, l := range img.ZephyrLogSources() {
+		rows = append(rows, []interface{}{`INSERT INTO zephyr_log VALUES (NULL,?,?,?,?)`,
+			l.Name, l.Level, nullable(l.Symbol), l.Address})
+	}
+
+	for _, r := range rows {
+		_, e = tx.Exec(r[0].(string), r[1:]...)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
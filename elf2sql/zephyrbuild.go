@@ -0,0 +1,84 @@
+package elf2sql // github.com/microbuilder/elfquery/elf2sql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ZephyrBuild lists the files found in a Zephyr build directory. Only the
+// ELF file is required, and the other paths are empty when not found.
+type ZephyrBuild struct {
+	ELF              string // zephyr/zephyr.elf
+	Map              string // zephyr/zephyr.map
+	Kconfig          string // zephyr/.config
+	Devicetree       string // zephyr/zephyr.dts
+	DevicetreeHeader string // zephyr/include/generated/devicetree_generated.h
+	Signed           string // zephyr/zephyr.signed.bin, for MCUboot images
+}
+
+// exists indicates if the named file exists.
+func exists(name string) bool {
+	st, e := os.Stat(name)
+	return e == nil && !st.IsDir()
+}
+
+// FindZephyrBuild locates the output files of a Zephyr build. dir can be
+// the build directory, its 'zephyr' subdirectory or, for sysbuild builds
+// with a single image, the top-level build directory.
+func FindZephyrBuild(dir string) (*ZephyrBuild, error) {
+	var zdir string
+	for _, d := range []string{filepath.Join(dir, "zephyr"), dir} {
+		if exists(filepath.Join(d, "zephyr.elf")) {
+			zdir = d
+			break
+		}
+	}
+	if zdir == "" {
+		images, _ := filepath.Glob(filepath.Join(dir, "*", "zephyr", "zephyr.elf"))
+		switch len(images) {
+		case 0:
+			return nil, fmt.Errorf("no zephyr/zephyr.elf found in '%s'", dir)
+		case 1:
+			zdir = filepath.Dir(images[0])
+		default:
+			for i := range images {
+				images[i] = filepath.Dir(filepath.Dir(images[i]))
+			}
+			return nil, fmt.Errorf("'%s' contains several images, choose one of: %s",
+				dir, strings.Join(images, ", "))
+		}
+	}
+
+	b := &ZephyrBuild{ELF: filepath.Join(zdir, "zephyr.elf")}
+	first := func(names ...string) string {
+		for _, n := range names {
+			if p := filepath.Join(zdir, n); exists(p) {
+				return p
+			}
+		}
+		return ""
+	}
+	b.Map = first("zephyr.map")
+	b.Kconfig = first(".config")
+	b.Devicetree = first("zephyr.dts")
+	b.DevicetreeHeader = first("include/generated/zephyr/devicetree_generated.h",
+		"include/generated/devicetree_generated.h",
+		"include/generated/devicetree_unfixed.h")
+	b.Signed = first("zephyr.signed.bin", "zephyr.signed.hex")
+
+	return b, nil
+}
+
+// Load sets the package's options to load every file of the build, along
+// with the Zephyr tables, and returns the path of the ELF file.
+func (b *ZephyrBuild) Load() string {
+	Zephyr = true
+	MapFile = b.Map
+	KconfigFile = b.Kconfig
+	DevicetreeFile = b.Devicetree
+	DevicetreeHeader = b.DevicetreeHeader
+	MCUbootFile = b.Signed
+	return b.ELF
+}
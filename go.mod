@@ -4,12 +4,14 @@ go 1.20
 
 require (
 	github.com/gorilla/mux v1.8.1
+	github.com/ianlancetaylor/demangle v0.0.0-20260724033716-83e58baca724
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
 	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/viper v1.17.0
 	github.com/yalue/elf_reader v1.0.0
+	golang.org/x/arch v0.14.0
 )
 
 require (
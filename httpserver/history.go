@@ -0,0 +1,233 @@
+package httpserver // github.com/microbuilder/elfquery/httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/microbuilder/elfquery/elf2sql"
+)
+
+// HistoryFile is the size history database charted on the /history page,
+// which is disabled if not set.
+var HistoryFile string
+
+// Threshold is the default growth between builds that is highlighted as a
+// regression on the /history page.
+var Threshold = "1%"
+
+// Chart dimensions, in pixels
+const (
+	chartWidth  = 900
+	chartHeight = 360
+	chartLeft   = 70
+	chartRight  = 20
+	chartTop    = 20
+	chartBottom = 90
+)
+
+// chartSeries is a line plotted on the history chart
+type chartSeries struct {
+	Name   string
+	Color  string
+	Values []int64
+}
+
+// historyChart draws the series as an SVG line chart, with one point per
+// build. Points that grew by more than the threshold are drawn in red.
+func historyChart(builds []elf2sql.HistoryBuild, series []chartSeries, t elf2sql.Threshold) string {
+	// Scale the y axis to the range of the values, so small changes show
+	var min, max int64 = -1, 1
+	for _, s := range series {
+		for _, v := range s.Values {
+			if v > max {
+				max = v
+			}
+			if min < 0 || v < min {
+				min = v
+			}
+		}
+	}
+	min -= (max - min) / 10
+	if min < 0 || min >= max {
+		min = 0
+	}
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	x := func(i int) float64 {
+		if len(builds) < 2 {
+			return chartLeft + plotW/2
+		}
+		return chartLeft + plotW*float64(i)/float64(len(builds)-1)
+	}
+	y := func(v int64) float64 {
+		return chartTop + plotH - plotH*float64(v-min)/float64(max-min)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight)
+
+	// Axes and grid lines
+	for i := 0; i <= 4; i++ {
+		v := min + (max-min)*int64(i)/4
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`,
+			chartLeft, y(v), chartWidth-chartRight, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`, chartLeft-6,
+			y(v)+4, v)
+	}
+	for i, bd := range builds {
+		fmt.Fprintf(&b, `<text transform="translate(%.1f,%d) rotate(-60)" text-anchor="end">%s</text>`,
+			x(i), chartHeight-chartBottom+14, template.HTMLEscapeString(shortCommit(bd.Commit)))
+	}
+
+	// Lines, then points on top, with the details in a tooltip
+	for _, s := range series {
+		var pts []string
+		for i, v := range s.Values {
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x(i), y(v)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`,
+			s.Color, strings.Join(pts, " "))
+	}
+	for _, s := range series {
+		for i, v := range s.Values {
+			color, r, note := s.Color, 3, ""
+			if i > 0 && t.Exceeded(s.Values[i-1], v) {
+				color, r = "#dc3545", 6
+				note = fmt.Sprintf(" (regression, %+d bytes)", v-s.Values[i-1])
+			}
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%d" fill="%s"><title>%s %s: %s %d bytes%s</title></circle>`,
+				x(i), y(v), r, color, template.HTMLEscapeString(shortCommit(builds[i].Commit)),
+				template.HTMLEscapeString(builds[i].Branch), s.Name, v, note)
+		}
+	}
+
+	// Legend
+	for i, s := range series {
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`,
+			chartLeft+10+i*80, chartTop, s.Color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, chartLeft+26+i*80, chartTop+10, s.Name)
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// shortCommit abbreviates a git commit hash to 12 characters.
+func shortCommit(c string) string {
+	if len(c) > 12 {
+		return c[:12]
+	}
+	return c
+}
+
+// History page handler, charting flash and RAM usage across commits, or
+// the size of a section or symbol when given
+func history(w http.ResponseWriter, r *http.Request) {
+	if HistoryFile == "" {
+		http.Error(w, "No history database (start with --history)", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	branch, section, symbol := q.Get("branch"), q.Get("section"), q.Get("symbol")
+	ts := Threshold
+	if q.Get("threshold") != "" {
+		ts = q.Get("threshold")
+	}
+	t, e := elf2sql.ParseThreshold(ts)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h, e := elf2sql.OpenHistory(HistoryFile)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer h.Close()
+
+	var builds []elf2sql.HistoryBuild
+	var series []chartSeries
+	title := "Flash and RAM usage"
+	if section != "" || symbol != "" {
+		kind, name := "section", section
+		if symbol != "" {
+			kind, name = "symbol", symbol
+		}
+		title = fmt.Sprintf("Size of %s %s", kind, name)
+		points, e := h.Trend(kind, name, branch)
+		if e != nil {
+			http.Error(w, e.Error(), http.StatusNotFound)
+			return
+		}
+		s := chartSeries{Name: "Size", Color: "#0d6efd"}
+		for _, p := range points {
+			builds = append(builds, p.Build)
+			s.Values = append(s.Values, p.Size)
+		}
+		series = append(series, s)
+	} else {
+		builds, e = h.Builds(branch)
+		if e != nil {
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+			return
+		}
+		flash := chartSeries{Name: "Flash", Color: "#0d6efd"}
+		ram := chartSeries{Name: "RAM", Color: "#198754"}
+		for _, b := range builds {
+			flash.Values = append(flash.Values, b.Flash)
+			ram.Values = append(ram.Values, b.RAM)
+		}
+		series = append(series, flash, ram)
+	}
+
+	// List the builds below the chart, newest first
+	header := []string{"Commit", "Branch", "Timestamp"}
+	for _, s := range series {
+		header = append(header, s.Name, "Change")
+	}
+	var rows [][]interface{}
+	for i := len(builds) - 1; i >= 0; i-- {
+		row := []interface{}{builds[i].Commit, builds[i].Branch, builds[i].Timestamp}
+		for _, s := range series {
+			change := ""
+			if i > 0 {
+				change = fmt.Sprintf("%+d", s.Values[i]-s.Values[i-1])
+				if t.Exceeded(s.Values[i-1], s.Values[i]) {
+					change += " (regression)"
+				}
+			}
+			row = append(row, s.Values[i], change)
+		}
+		rows = append(rows, row)
+	}
+
+	tmpl, e := template.ParseFiles("templates/history.html")
+	if e != nil {
+		fmt.Printf("Unable to load template file.\n")
+		return
+	}
+	data := struct {
+		PageTitle string
+		Title     string
+		Branch    string
+		Section   string
+		Symbol    string
+		Threshold string
+		Chart     template.HTML
+		Results   template.HTML
+	}{
+		PageTitle: "Size History",
+		Title:     title,
+		Branch:    branch,
+		Section:   section,
+		Symbol:    symbol,
+		Threshold: t.String(),
+		Chart:     template.HTML(historyChart(builds, series, t)),
+		Results:   template.HTML(elf2sql.RenderTable("", header, rows, elf2sql.DFHtml)),
+	}
+	tmpl.Execute(w, data)
+}
@@ -1,6 +1,7 @@
 package httpserver // github.com/microbuilder/elfquery/httpserver
 
 import (
+	"debug/elf"
 	"fmt"
 	"html/template"
 	"log"
@@ -53,6 +54,56 @@ func home(w http.ResponseWriter, r *http.Request) {
 	tmpl.Execute(w, data)
 }
 
+// Symbol detail page handler, showing the symbol's table entry and, for
+// functions, a disassembly listing
+func symbol(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	// Query the database
+	query := "SELECT Name, Type, Binding, Visibility, Section, printf('0x%X', Value) AS Address, Size FROM symbols WHERE Name = '" +
+		strings.ReplaceAll(name, "'", "''") + "'"
+	s, e := elf2sql.RunQuery(query, elf2sql.DFHtml)
+	if e != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	// Disassemble functions
+	var listing string
+	if img := elf2sql.CurrentImage(); img != nil {
+		if sym, ok := img.Lookup(name); ok && elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+			start, end, e := img.SymbolRange(name)
+			if e == nil {
+				listing, e = img.Listing(start, end)
+			}
+			if e != nil {
+				listing = e.Error()
+			}
+		}
+	}
+
+	// Load the template
+	tmpl, e := template.ParseFiles("templates/symbol.html")
+	if e != nil {
+		fmt.Printf("Unable to load template file.\n")
+		return
+	}
+
+	// Inject results
+	data := struct {
+		PageTitle string
+		Name      string
+		Results   template.HTML
+		Listing   string
+	}{
+		PageTitle: name,
+		Name:      name,
+		Results:   template.HTML(s),
+		Listing:   listing,
+	}
+	tmpl.Execute(w, data)
+}
+
 // Start the HTTP Server
 func Start(port int16) {
 	r := mux.NewRouter()
@@ -65,6 +116,8 @@ func Start(port int16) {
 	// so these will only be handled if they don't match anything above.
 	r.PathPrefix("/css/").Handler(http.StripPrefix("/css/", http.FileServer(http.Dir("templates/css/"))))
 	r.PathPrefix("/js/").Handler(http.StripPrefix("/js/", http.FileServer(http.Dir("templates/js/"))))
+	r.HandleFunc("/symbol/{name}", symbol)
+	r.HandleFunc("/history", history)
 	r.HandleFunc("/", home)
 
 	fmt.Println("Starting HTTP server on port http://localhost:" + strconv.Itoa(int(port)))